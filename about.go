@@ -0,0 +1,26 @@
+package main
+
+import "os"
+
+// Defaults for "!source", overridden by SPAWNBOT_ABOUT_TEXT and
+// SPAWNBOT_ABOUT_URL.
+const (
+	defaultAboutText = "SpawnBot, the IRC <-> Discord bridge for #spawn"
+	defaultAboutURL  = "https://github.com/alefnull/spawnbot"
+)
+
+// aboutReply is the reply to "!source" on both IRC and Discord, e.g.
+// "SpawnBot, the IRC <-> Discord bridge for #spawn — https://...".
+func aboutReply() string {
+	text := os.Getenv("SPAWNBOT_ABOUT_TEXT")
+	if text == "" {
+		text = defaultAboutText
+	}
+
+	url := os.Getenv("SPAWNBOT_ABOUT_URL")
+	if url == "" {
+		url = defaultAboutURL
+	}
+
+	return text + " — " + url
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log/slog"
+	"spawnbot/cmdhandler"
+	"spawnbot/internal/testutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+)
+
+func TestAboutReply(t *testing.T) {
+	tests := []struct {
+		text string
+		url  string
+		want string
+	}{
+		{"", "", defaultAboutText + " — " + defaultAboutURL},
+		{"", "https://example.com/bot", defaultAboutText + " — https://example.com/bot"},
+		{"A bridge", "https://example.com/bot", "A bridge — https://example.com/bot"},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("SPAWNBOT_ABOUT_TEXT", tt.text)
+		t.Setenv("SPAWNBOT_ABOUT_URL", tt.url)
+
+		if got := aboutReply(); got != tt.want {
+			t.Errorf("aboutReply() with %q, %q = %q, want %q", tt.text, tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestSourceDiscord(t *testing.T) {
+	t.Setenv("SPAWNBOT_ABOUT_URL", "https://example.com/bot")
+
+	dis_client, created := fakeDiscord(t)
+	fake := testutil.NewCommander(t)
+	ignored, _ := newIgnoreList("", nil)
+	opted_out, _ := newIgnoreList("", nil)
+	registerDiscordHandlers(slog.Default(), dis_client, cmdhandler.RealClock{}, fake.Client, spawnRoutes(), nil, newOutbox("IRC", 10), nil, ignored, opted_out, nil, nil, newMessageCache(10), nil, nil, nil, "", 0, "", func() {})
+
+	dispatchMessage(dis_client, discord.Message{ID: 100, ChannelID: SPAWN_CHAN_ID, Author: discord.User{ID: 7, Username: "alice"}, Content: "!source"})
+
+	select {
+	case message := <-created:
+		if !strings.Contains(message.Content, "https://example.com/bot") || message.MessageReference == nil {
+			t.Errorf("replied %q, want a reply with the configured URL", message.Content)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reply to !source")
+	}
+
+	select {
+	case e := <-fake.Sent():
+		t.Errorf("relayed %q to IRC", e.String())
+	case <-time.After(100 * time.Millisecond):
+	}
+}
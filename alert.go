@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"spawnbot/cmdhandler"
+	"sync"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+)
+
+// alertPayload is the JSON body POSTed to the alert webhook.
+type alertPayload struct {
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+// alerter POSTs connection events for each side of the bridge (e.g.
+// "irc_disconnect", "discord_reconnect") to a webhook for ops alerting. Each
+// event is sent at most once per debounce window, so a flapping connection
+// doesn't spam. A nil alerter is a no-op.
+type alerter struct {
+	url      string
+	debounce time.Duration
+	client   *http.Client
+	clock    cmdhandler.Clock
+
+	mu   sync.Mutex
+	last map[string]time.Time // when each event was last sent
+	down map[string]bool      // sides currently disconnected
+}
+
+// newAlerter returns an alerter posting to url, debounced by clock, or nil if
+// url is empty.
+func newAlerter(clock cmdhandler.Clock, url string, debounce time.Duration) *alerter {
+	if url == "" {
+		return nil
+	}
+
+	return &alerter{
+		url:      url,
+		debounce: debounce,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		clock:    clock,
+		last:     make(map[string]time.Time),
+		down:     make(map[string]bool),
+	}
+}
+
+// disconnected reports that side (e.g. "irc") lost its connection.
+func (a *alerter) disconnected(side, detail string) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	a.down[side] = true
+	a.mu.Unlock()
+
+	a.fire(side+"_disconnect", detail)
+}
+
+// connected reports that side is connected, alerting only if it was
+// previously reported as disconnected.
+func (a *alerter) connected(side, detail string) {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	was := a.down[side]
+	a.down[side] = false
+	a.mu.Unlock()
+
+	if was {
+		a.fire(side+"_reconnect", detail)
+	}
+}
+
+// fire sends event in the background, unless it was already sent within the
+// debounce window.
+func (a *alerter) fire(event, detail string) {
+	now := a.clock.Now()
+
+	a.mu.Lock()
+	if last, ok := a.last[event]; ok && now.Sub(last) < a.debounce {
+		a.mu.Unlock()
+		return
+	}
+	a.last[event] = now
+	a.mu.Unlock()
+
+	go func() {
+		if err := a.post(alertPayload{Event: event, Timestamp: now.UTC(), Detail: detail}); err != nil {
+			slog.Error("[ALERT] Unable to send alert", slog.String("event", event), slog.Any("err", err))
+		}
+	}()
+}
+
+func (a *alerter) post(payload alertPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := a.client.Post(a.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// watchGateway polls the Discord gateway status every interval by clk,
+// reporting transitions to a. It never returns.
+func watchGateway(clk cmdhandler.Clock, dis_client bot.Client, a *alerter, interval time.Duration) {
+	ready := true
+	for {
+		<-clk.After(interval)
+		status := dis_client.Gateway().Status()
+		if now := status.IsConnected(); now != ready {
+			ready = now
+			if ready {
+				a.connected("discord", status.String())
+			} else {
+				a.disconnected("discord", status.String())
+			}
+		}
+	}
+}
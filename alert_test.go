@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"spawnbot/internal/testutil"
+	"testing"
+	"time"
+)
+
+func TestAlerter(t *testing.T) {
+	posted := make(chan alertPayload, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload alertPayload
+		if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&payload) != nil {
+			http.Error(w, "bad alert", http.StatusBadRequest)
+			return
+		}
+
+		posted <- payload
+	}))
+	t.Cleanup(hook.Close)
+
+	expect := func(event string) {
+		t.Helper()
+
+		select {
+		case payload := <-posted:
+			if payload.Event != event || payload.Detail != "irc.example.org" || payload.Timestamp.IsZero() {
+				t.Errorf("posted %+v, want %s", payload, event)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("nothing posted, want %s", event)
+		}
+	}
+
+	clk := testutil.NewClock()
+	alerts := newAlerter(clk, hook.URL, time.Hour)
+
+	// Connecting for the first time isn't a reconnect.
+	alerts.connected("irc", "irc.example.org")
+
+	alerts.disconnected("irc", "irc.example.org")
+	expect("irc_disconnect")
+	alerts.connected("irc", "irc.example.org")
+	expect("irc_reconnect")
+
+	// Flapping within the debounce window stays quiet.
+	alerts.disconnected("irc", "irc.example.org")
+	alerts.connected("irc", "irc.example.org")
+	select {
+	case payload := <-posted:
+		t.Errorf("posted %+v while debouncing", payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Once the window has passed, the next disconnect is sent again.
+	clk.Advance(time.Hour)
+	alerts.disconnected("irc", "irc.example.org")
+	expect("irc_disconnect")
+
+	// Without a webhook, alerting does nothing.
+	newAlerter(clk, "", time.Hour).disconnected("irc", "irc.example.org")
+}
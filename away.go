@@ -0,0 +1,64 @@
+package main
+
+import (
+	"spawnbot/cmdhandler"
+	"sync"
+	"time"
+
+	"github.com/lrstanley/girc"
+)
+
+// awayTracker marks the bot AWAY on IRC once nothing has been relayed from
+// Discord for a while, and back again when relaying resumes, so IRC users
+// can tell the bridge is idle.
+type awayTracker struct {
+	msg  string
+	idle time.Duration
+
+	mu   sync.Mutex
+	last time.Time
+	away bool
+}
+
+// newAwayTracker returns a tracker which goes away with msg after idle
+// without activity, counting from now.
+func newAwayTracker(msg string, idle time.Duration, now time.Time) *awayTracker {
+	return &awayTracker{msg: msg, idle: idle, last: now}
+}
+
+// activity records a relay at now, reporting whether the bot was away and
+// should now be marked back.
+func (t *awayTracker) activity(now time.Time) (back bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.last = now
+	back, t.away = t.away, false
+
+	return back
+}
+
+// check reports whether the bot has just become idle at now and should be
+// marked away.
+func (t *awayTracker) check(now time.Time) (away bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.away || now.Sub(t.last) < t.idle {
+		return false
+	}
+
+	t.away = true
+	return true
+}
+
+// run checks for idleness every interval by clk, setting AWAY on c when it
+// begins. It never returns.
+func (t *awayTracker) run(c *girc.Client, clk cmdhandler.Clock, interval time.Duration) {
+	for {
+		now := <-clk.After(interval)
+		if c.IsConnected() && t.check(now) {
+			c.Cmd.Away(t.msg)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"spawnbot/internal/testutil"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+)
+
+func TestAwayTracker(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	away := newAwayTracker("idle", 30*time.Minute, start)
+
+	steps := []struct {
+		name     string
+		activity bool // relay at this time, rather than checking
+		at       time.Duration
+		want     bool
+	}{
+		{"not idle yet", false, 29 * time.Minute, false},
+		{"goes away", false, 30 * time.Minute, true},
+		{"already away", false, 45 * time.Minute, false},
+		{"comes back", true, 50 * time.Minute, true},
+		{"already back", true, 51 * time.Minute, false},
+		{"idle counts from last relay", false, 80 * time.Minute, false},
+		{"goes away again", false, 81 * time.Minute, true},
+	}
+
+	for _, s := range steps {
+		var got bool
+		if s.activity {
+			got = away.activity(at(s.at))
+		} else {
+			got = away.check(at(s.at))
+		}
+
+		if got != s.want {
+			t.Errorf("%s at %v: got %v, want %v", s.name, s.at, got, s.want)
+		}
+	}
+}
+
+func TestAwayRun(t *testing.T) {
+	fake := testutil.NewCommander(t)
+	clk := testutil.NewClock()
+	away := newAwayTracker("idle", 2*time.Minute, clk.Now())
+	go away.run(fake.Client, clk, time.Minute)
+
+	// tick advances the clock by a minute once run is waiting on it.
+	tick := func() {
+		t.Helper()
+		if !clk.WaitForWaiters(1) {
+			t.Fatal("run isn't waiting on the clock")
+		}
+		clk.Advance(time.Minute)
+	}
+
+	tick()
+	tick()
+
+	for {
+		select {
+		case e := <-fake.Sent():
+			if e.Command == girc.PONG {
+				continue
+			}
+			if e.Command != girc.AWAY || e.Last() != "idle" {
+				t.Errorf("sent %q, want AWAY idle", e.String())
+			}
+		case <-time.After(time.Second):
+			t.Fatal("not marked away after 2 idle minutes")
+		}
+		return
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lrstanley/girc"
+)
+
+// banMask resolves nick to a "*!*@host" ban mask, using girc's tracked state
+// when the user shares a channel with the bot and falling back to a WHOIS.
+func banMask(c *girc.Client, nick string) (string, error) {
+	if !girc.IsValidNick(nick) {
+		return "", fmt.Errorf("invalid nick %q", nick)
+	}
+
+	if girc.ToRFC1459(nick) == girc.ToRFC1459(c.GetNick()) {
+		return "", fmt.Errorf("refusing to ban myself")
+	}
+
+	if user := c.LookupUser(nick); user != nil && user.Host != "" {
+		return "*!*@" + user.Host, nil
+	}
+
+	reply, err := queryWhois(c, nick, 10*time.Second)
+	if err != nil {
+		return "", err
+	}
+
+	return "*!*@" + reply.host, nil
+}
+
+// banTarget works out the channel and mask for !ban/!unban, from args of the
+// form "<nick|mask> [#channel]". The channel defaults to the one the command
+// was sent from. Arguments containing "!" or "@" are used as the mask as-is,
+// which lets bans be lifted for users who have since left.
+func banTarget(c *girc.Client, origin *girc.Event, args []string) (channel, mask string, err error) {
+	if len(args) > 1 {
+		channel = args[1]
+	} else if origin.IsFromChannel() {
+		channel = origin.Params[0]
+	}
+
+	if !girc.IsValidChannel(channel) {
+		return "", "", fmt.Errorf("no channel given")
+	}
+
+	if girc.IsValidNick(args[0]) {
+		mask, err = banMask(c, args[0])
+	} else if isMask(args[0]) {
+		mask = args[0]
+	} else {
+		err = fmt.Errorf("invalid nick or mask %q", args[0])
+	}
+
+	return channel, mask, err
+}
+
+// isMask reports whether s looks like a nick!ident@host mask.
+func isMask(s string) bool {
+	for _, r := range s {
+		if r == '!' || r == '@' {
+			return true
+		}
+
+		if r == ' ' || r == ',' {
+			return false
+		}
+	}
+
+	return false
+}
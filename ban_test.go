@@ -0,0 +1,78 @@
+package main
+
+import (
+	"spawnbot/internal/testutil"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+)
+
+func TestBan(t *testing.T) {
+	fake := testutil.NewCommander(t)
+	irc_client, send, sent := fake.Client, fake.Send, fake.Sent()
+	origin := girc.ParseEvent(":admin!a@example.org PRIVMSG #spawn :!ban alice")
+
+	// next returns the next line the client sends, skipping PONGs.
+	next := func() *girc.Event {
+		t.Helper()
+		for {
+			select {
+			case e := <-sent:
+				if e.Command != girc.PONG {
+					return e
+				}
+			case <-time.After(time.Second):
+				t.Fatal("nothing sent")
+			}
+		}
+	}
+
+	t.Run("nick resolved by whois", func(t *testing.T) {
+		type target struct {
+			channel, mask string
+			err           error
+		}
+		result := make(chan target, 1)
+		go func() {
+			channel, mask, err := banTarget(irc_client, origin, []string{"alice"})
+			result <- target{channel, mask, err}
+		}()
+
+		if e := next(); e.Command != girc.WHOIS || e.Params[0] != "alice" {
+			t.Fatalf("sent %q, want a WHOIS for alice", e.String())
+		}
+		send(":irc.example.org 311 SpawnBot alice ~alice user.example.org * :Alice")
+		send(":irc.example.org 318 SpawnBot alice :End of /WHOIS list.")
+
+		got := <-result
+		if got.err != nil || got.channel != "#spawn" || got.mask != "*!*@user.example.org" {
+			t.Fatalf("banTarget() = %q, %q, %v, want #spawn, *!*@user.example.org", got.channel, got.mask, got.err)
+		}
+
+		irc_client.Cmd.Ban(got.channel, got.mask)
+		if e := next(); e.String() != "MODE #spawn +b *!*@user.example.org" {
+			t.Errorf("sent %q, want the ban", e.String())
+		}
+	})
+
+	t.Run("mask and channel given", func(t *testing.T) {
+		channel, mask, err := banTarget(irc_client, origin, []string{"*!*@bad.example.org", "#dev"})
+		if err != nil || channel != "#dev" || mask != "*!*@bad.example.org" {
+			t.Fatalf("banTarget() = %q, %q, %v, want #dev, *!*@bad.example.org", channel, mask, err)
+		}
+
+		irc_client.Cmd.Unban(channel, mask)
+		if e := next(); e.String() != "MODE #dev -b *!*@bad.example.org" {
+			t.Errorf("sent %q, want the unban", e.String())
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		for _, args := range [][]string{{"SpawnBot"}, {"not a nick"}, {"alice", "nochannel"}} {
+			if _, _, err := banTarget(irc_client, origin, args); err == nil {
+				t.Errorf("banTarget(%q) didn't fail", args)
+			}
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// blocklist matches words which mustn't be bridged, either masking them with
+// asterisks or dropping messages containing them. A nil blocklist blocks
+// nothing.
+type blocklist struct {
+	patterns []*regexp.Regexp
+	drop     bool
+}
+
+// newBlocklist compiles entries, matched case-insensitively. An entry wrapped
+// in slashes, e.g. "/fo+/", is a regular expression; anything else is a
+// literal matched as a whole word, so "ass" doesn't block "class". With drop,
+// matching messages are dropped rather than masked. No entries returns nil.
+func newBlocklist(entries []string, drop bool) (*blocklist, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	b := &blocklist{drop: drop}
+	for _, entry := range entries {
+		var expr string
+		if inner, ok := strings.CutPrefix(entry, "/"); ok && len(inner) > 0 && strings.HasSuffix(inner, "/") {
+			expr = strings.TrimSuffix(inner, "/")
+		} else {
+			expr = wordPattern(entry)
+		}
+
+		re, err := regexp.Compile("(?i)" + expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid blocklist entry %q: %w", entry, err)
+		}
+
+		b.patterns = append(b.patterns, re)
+	}
+
+	return b, nil
+}
+
+// wordPattern matches word literally, anchored with \b on each side that
+// starts or ends with a word character, as \b never matches next to
+// punctuation.
+func wordPattern(word string) string {
+	expr := regexp.QuoteMeta(word)
+	if first, _ := utf8.DecodeRuneInString(word); isWordRune(first) {
+		expr = `\b` + expr
+	}
+	if last, _ := utf8.DecodeLastRuneInString(word); isWordRune(last) {
+		expr += `\b`
+	}
+
+	return expr
+}
+
+// isWordRune reports whether r is matched by \w.
+func isWordRune(r rune) bool {
+	return r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+}
+
+// filter returns content with blocked words masked, or false if content
+// should be dropped.
+func (b *blocklist) filter(content string) (string, bool) {
+	if b == nil {
+		return content, true
+	}
+
+	for _, re := range b.patterns {
+		if !re.MatchString(content) {
+			continue
+		}
+
+		if b.drop {
+			return "", false
+		}
+
+		content = re.ReplaceAllStringFunc(content, func(match string) string {
+			return strings.Repeat("*", utf8.RuneCountInString(match))
+		})
+	}
+
+	return content, true
+}
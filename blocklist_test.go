@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestBlocklist(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		drop    bool
+		content string
+		want    string
+		ok      bool
+	}{
+		{"none", nil, false, "anything goes", "anything goes", true},
+		{"mask", []string{"darn"}, false, "well darn it", "well **** it", true},
+		{"case", []string{"darn"}, false, "DARN", "****", true},
+		{"whole words", []string{"ass"}, false, "a class act", "a class act", true},
+		{"punctuation", []string{"c++"}, false, "i like c++.", "i like ***.", true},
+		{"regexp", []string{"/fo+/"}, false, "fooo bar", "**** bar", true},
+		{"runes", []string{"/é+/"}, false, "ééé!", "***!", true},
+		{"drop", []string{"darn"}, true, "well darn it", "", false},
+		{"drop unmatched", []string{"darn"}, true, "well done", "well done", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := newBlocklist(tt.entries, tt.drop)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok := b.filter(tt.content)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("filter(%q) = %q, %v, want %q, %v", tt.content, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+
+	if _, err := newBlocklist([]string{"/(/"}, false); err == nil {
+		t.Error("newBlocklist accepted an invalid regexp")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxCalcLen caps the length of a !calc expression, which also bounds how
+// deeply it can nest.
+const maxCalcLen = 200
+
+var errDivByZero = errors.New("division by zero")
+
+// calc evaluates an arithmetic expression supporting + - * / % ^ and
+// parentheses, with the usual precedence (^ binds tightest and is right
+// associative). It is a small recursive descent parser; nothing is ever
+// executed.
+func calc(expr string) (float64, error) {
+	if len(expr) > maxCalcLen {
+		return 0, fmt.Errorf("expression too long (max %d characters)", maxCalcLen)
+	}
+
+	p := &calcParser{s: expr}
+	if strings.TrimSpace(p.s) == "" {
+		return 0, errors.New("empty expression")
+	}
+
+	v, err := p.expr()
+	if err != nil {
+		return 0, err
+	}
+
+	if p.peek() != 0 {
+		return 0, fmt.Errorf("unexpected %q at position %d", p.s[p.pos], p.pos+1)
+	}
+
+	if math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0, errors.New("result is not a finite number")
+	}
+
+	return v, nil
+}
+
+type calcParser struct {
+	s   string
+	pos int
+}
+
+// peek returns the next byte after any whitespace, or 0 at the end of input.
+// Whitespace separates tokens, so "1 2" is an error rather than 12.
+func (p *calcParser) peek() byte {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+
+	return 0
+}
+
+// expr = term { ("+" | "-") term }
+func (p *calcParser) expr() (float64, error) {
+	v, err := p.term()
+	for err == nil && (p.peek() == '+' || p.peek() == '-') {
+		op := p.peek()
+		p.pos++
+
+		var rhs float64
+		if rhs, err = p.term(); op == '+' {
+			v += rhs
+		} else {
+			v -= rhs
+		}
+	}
+
+	return v, err
+}
+
+// term = unary { ("*" | "/" | "%") unary }
+func (p *calcParser) term() (float64, error) {
+	v, err := p.unary()
+	for err == nil && (p.peek() == '*' || p.peek() == '/' || p.peek() == '%') {
+		op := p.peek()
+		p.pos++
+
+		var rhs float64
+		if rhs, err = p.unary(); err != nil {
+			break
+		}
+
+		switch {
+		case op == '*':
+			v *= rhs
+		case rhs == 0:
+			err = errDivByZero
+		case op == '/':
+			v /= rhs
+		default:
+			v = math.Mod(v, rhs)
+		}
+	}
+
+	return v, err
+}
+
+// unary = ("-" | "+") unary | power
+func (p *calcParser) unary() (float64, error) {
+	switch p.peek() {
+	case '-':
+		p.pos++
+		v, err := p.unary()
+		return -v, err
+	case '+':
+		p.pos++
+		return p.unary()
+	}
+
+	return p.power()
+}
+
+// power = primary [ "^" unary ]
+func (p *calcParser) power() (float64, error) {
+	v, err := p.primary()
+	if err != nil || p.peek() != '^' {
+		return v, err
+	}
+	p.pos++
+
+	exp, err := p.unary()
+	return math.Pow(v, exp), err
+}
+
+// primary = number | "(" expr ")"
+func (p *calcParser) primary() (float64, error) {
+	if p.peek() == '(' {
+		p.pos++
+		v, err := p.expr()
+		if err != nil {
+			return 0, err
+		}
+
+		if p.peek() != ')' {
+			return 0, errors.New("missing closing parenthesis")
+		}
+		p.pos++
+
+		return v, nil
+	}
+
+	if p.peek() == 0 {
+		return 0, errors.New("unexpected end of expression")
+	}
+
+	start := p.pos
+	for p.pos < len(p.s) && (p.s[p.pos] == '.' || (p.s[p.pos] >= '0' && p.s[p.pos] <= '9')) {
+		p.pos++
+	}
+
+	if start == p.pos {
+		return 0, fmt.Errorf("unexpected %q at position %d", p.s[p.pos], p.pos+1)
+	}
+
+	v, err := strconv.ParseFloat(p.s[start:p.pos], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", p.s[start:p.pos])
+	}
+
+	return v, nil
+}
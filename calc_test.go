@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCalc(t *testing.T) {
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		{"1 + 2", 3},
+		{"2 + 3 * 4", 14},
+		{"(2 + 3) * 4", 20},
+		{"10 - 4 - 3", 3},
+		{"2 ^ 3 ^ 2", 512},
+		{"-2 ^ 2", -4},
+		{"7 % 4", 3},
+		{"1.5 * 2", 3},
+		{" ( ( 1 ) ) ", 1},
+		{"\t3*3", 9},
+	}
+
+	for _, tt := range tests {
+		got, err := calc(tt.expr)
+		if err != nil || got != tt.want {
+			t.Errorf("calc(%q) = %v, %v, want %v", tt.expr, got, err, tt.want)
+		}
+	}
+}
+
+func TestCalcErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"   ",
+		"1 2",
+		"12 34 + 1",
+		"1 +",
+		"(1 + 2",
+		"1 + 2)",
+		"1 / 0",
+		"5 % 0",
+		"2 ^ 10000",
+		"1..2",
+		"abc",
+	}
+
+	for _, expr := range tests {
+		if got, err := calc(expr); err == nil {
+			t.Errorf("calc(%q) = %v, want an error", expr, got)
+		}
+	}
+
+	if _, err := calc("1 / 0"); !errors.Is(err, errDivByZero) {
+		t.Errorf("calc(%q) error = %v, want %v", "1 / 0", err, errDivByZero)
+	}
+}
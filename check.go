@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// checkConfig validates the configuration without starting the bridge, for
+// "--check" (or SPAWNBOT_CHECK=true): everything parsed at startup, the
+// format of the Discord token, and that server resolves. Every problem found
+// is returned, not just the first.
+func checkConfig(server string) error {
+	var errs []error
+	step := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	_, err := parseCaps(envList("SPAWNBOT_IRC_CAPS"))
+	step("SPAWNBOT_IRC_CAPS", err)
+
+	_, err = parseNickMap(envList("SPAWNBOT_NICK_MAP"))
+	step("SPAWNBOT_NICK_MAP", err)
+
+	_, err = loadClientCert(os.Getenv("SPAWNBOT_IRC_CERT"), os.Getenv("SPAWNBOT_IRC_KEY"), server)
+	step("irc client certificate", err)
+
+	_, err = loadAppConfig()
+	step("discord config", err)
+
+	_, err = newBlocklist(envList("SPAWNBOT_BLOCKLIST"), false)
+	step("SPAWNBOT_BLOCKLIST", err)
+
+	if forum_id := os.Getenv("SPAWNBOT_DISCORD_FORUM_ID"); forum_id != "" {
+		_, err = snowflake.Parse(forum_id)
+		step("SPAWNBOT_DISCORD_FORUM_ID", err)
+	}
+
+	if token := os.Getenv("SPAWNBOT_TOKEN"); token != "" || !envBool("SPAWNBOT_DISCORD_OPTIONAL", false) {
+		step("SPAWNBOT_TOKEN", checkToken(token))
+	}
+
+	_, err = net.LookupHost(server)
+	step("irc server", err)
+
+	return errors.Join(errs...)
+}
+
+// checkToken checks token looks like a Discord bot token: three dot-separated
+// base64 parts, the first of which encodes the bot's user ID. It can't tell
+// whether Discord will accept it.
+func checkToken(token string) error {
+	parts := strings.Split(strings.TrimPrefix(token, "Bot "), ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return errors.New("not a bot token, expected three dot-separated parts")
+	}
+
+	id, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(parts[0], "="))
+	if err != nil {
+		return errors.New("not a bot token, the first part isn't base64")
+	}
+
+	if _, err := strconv.ParseUint(string(id), 10, 64); err != nil {
+		return errors.New("not a bot token, the first part isn't a user ID")
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestCheckToken(t *testing.T) {
+	id := base64.RawStdEncoding.EncodeToString([]byte("1226973379303706768"))
+
+	tests := []struct {
+		token string
+		ok    bool
+	}{
+		{id + ".GhIjKl.abcdefghijklmnopqrstuvwxyz", true},
+		{"Bot " + id + ".GhIjKl.abcdefghijklmnopqrstuvwxyz", true},
+		{"", false},
+		{id + ".GhIjKl", false},
+		{id + "..abcdef", false},
+		{"!!!.GhIjKl.abcdef", false},
+		{base64.RawStdEncoding.EncodeToString([]byte("spawnbot")) + ".GhIjKl.abcdef", false},
+	}
+
+	for _, tt := range tests {
+		if err := checkToken(tt.token); (err == nil) != tt.ok {
+			t.Errorf("checkToken(%q) = %v, want ok %v", tt.token, err, tt.ok)
+		}
+	}
+}
+
+func TestCheckConfig(t *testing.T) {
+	token := base64.RawStdEncoding.EncodeToString([]byte("1226973379303706768")) + ".GhIjKl.abcdef"
+	for _, key := range []string{"SPAWNBOT_IRC_CAPS", "SPAWNBOT_NICK_MAP", "SPAWNBOT_DISCORD_ADMIN_ROLES", "SPAWNBOT_BLOCKLIST", "SPAWNBOT_DISCORD_FORUM_ID"} {
+		t.Setenv(key, "")
+	}
+	t.Setenv("SPAWNBOT_TOKEN", token)
+
+	// localhost resolves without a network.
+	if err := checkConfig("localhost"); err != nil {
+		t.Errorf("checkConfig() = %v, want a valid config", err)
+	}
+
+	// Every problem is reported, not just the first.
+	t.Setenv("SPAWNBOT_NICK_MAP", "alice")
+	t.Setenv("SPAWNBOT_DISCORD_FORUM_ID", "forum")
+	t.Setenv("SPAWNBOT_TOKEN", "not-a-token")
+
+	err := checkConfig("localhost")
+	if err == nil {
+		t.Fatal("checkConfig() accepted an invalid config")
+	}
+
+	for _, name := range []string{"SPAWNBOT_NICK_MAP", "SPAWNBOT_DISCORD_FORUM_ID", "SPAWNBOT_TOKEN"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("checkConfig() = %q, want a problem with %s", err, name)
+		}
+	}
+}
@@ -7,9 +7,15 @@ package cmdhandler
 import (
 	"errors"
 	"fmt"
+	"log/slog"
 	"regexp"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/lrstanley/girc"
 )
@@ -19,6 +25,36 @@ type Input struct {
 	Origin  *girc.Event
 	Args    []string
 	RawArgs string
+	// WasMentioned is true when the command was invoked by addressing the
+	// bot by nick (e.g. "SpawnBot: ping"), rather than with the prefix.
+	WasMentioned bool
+	// Account is the services account of the invoker, taken from the IRCv3
+	// account-tag when the server supports it. Empty if not logged in.
+	Account string
+	// IsAdmin is true when the invoker matches one of the admin masks
+	// registered with CmdHandler.SetAdmins, whether or not the command is
+	// flagged Admin.
+	IsAdmin bool
+
+	client *girc.Client
+	bridge func(*Input, string)
+}
+
+// Reply sends msg back to where the command was invoked from, and for
+// commands with BridgeResponses, to the bridge function registered with
+// CmdHandler.SetBridge as well.
+func (in *Input) Reply(msg string) {
+	in.client.Cmd.Reply(*in.Origin, msg)
+	if in.bridge != nil {
+		in.bridge(in, msg)
+	}
+}
+
+// ReplyPrivate sends msg as a NOTICE to the nick which invoked the command,
+// rather than to the channel the command was invoked from. Useful for noisy
+// output which only the invoker cares about.
+func (in *Input) ReplyPrivate(msg string) {
+	in.client.Cmd.Notice(in.Origin.Source.Name, msg)
 }
 
 // Command is an IRC command, supporting aliases, help documentation and easy
@@ -36,11 +72,75 @@ type Command struct {
 	// above 0, this means that the command handler will throw an error asking
 	// the person to check "<prefix>help <command>" for more info.
 	MinArgs int
+	// MaxArgs, if above 0, is the number of arguments the command takes, with
+	// anything beyond that joined into the last argument. E.g. with MinArgs 1
+	// and MaxArgs 2, "<nick> [reason...]" gives Args of "bob" and "spamming
+	// repeatedly".
+	MaxArgs int
+	// Category groups the command in the help listing, e.g. "Admin" or
+	// "Fun". Commands without a category are listed under "General".
+	Category string
+	// Hidden omits the command from the help listing. It can still be run,
+	// and "<prefix>help <command>" still shows its help.
+	Hidden bool
+	// Cooldown, if set, is how long each user must wait between uses of the
+	// command. Admins (see CmdHandler.SetAdmins) are exempt.
+	Cooldown time.Duration
+	// BridgeResponses also sends replies made with Input.Reply to the bridge
+	// function registered with CmdHandler.SetBridge, e.g. so the other side
+	// of a bridge sees the response.
+	BridgeResponses bool
+	// Admin restricts the command to sources matching one of the admin
+	// hostmasks registered with CmdHandler.SetAdmins.
+	Admin bool
+	// Contexts, if set, restricts where the command may be invoked from,
+	// e.g. ContextPM for commands which shouldn't be run in public. Zero
+	// allows both channels and private messages.
+	Contexts Context
 	// Fn is the function which is executed when the command is ran from a
 	// private message, or channel.
 	Fn func(*girc.Client, *Input)
 }
 
+// Context is a set of places a command may be invoked from, for
+// Command.Contexts.
+type Context uint8
+
+const (
+	// ContextChannel allows invocation from a channel.
+	ContextChannel Context = 1 << iota
+	// ContextPM allows invocation by private message to the bot.
+	ContextPM
+)
+
+// allows reports whether c includes where event was sent. An empty c allows
+// anywhere.
+func (c Context) allows(event girc.Event) bool {
+	if c == 0 {
+		return true
+	}
+
+	if event.IsFromChannel() {
+		return c&ContextChannel != 0
+	}
+
+	return c&ContextPM != 0
+}
+
+// names returns the contexts in c, e.g. ["channel", "pm"], or nil if c is
+// empty.
+func (c Context) names() []string {
+	var names []string
+	if c&ContextChannel != 0 {
+		names = append(names, "channel")
+	}
+	if c&ContextPM != 0 {
+		names = append(names, "pm")
+	}
+
+	return names
+}
+
 func (c *Command) genHelp(prefix string) string {
 	out := "{b}" + prefix + c.Name + "{b}"
 
@@ -50,6 +150,21 @@ func (c *Command) genHelp(prefix string) string {
 
 	out += " :: " + c.Help
 
+	if c.Cooldown > 0 {
+		out += " {b}Cooldown:{b} " + c.Cooldown.String()
+	}
+
+	if c.Admin {
+		out += " {b}(admin only){b}"
+	}
+
+	switch c.Contexts {
+	case ContextChannel:
+		out += " {b}(channel only){b}"
+	case ContextPM:
+		out += " {b}(PM only){b}"
+	}
+
 	return out
 }
 
@@ -73,11 +188,124 @@ func (c *Command) genHelp(prefix string) string {
 //
 //	client.Handlers.AddHandler(girc.PRIVMSG, ch)
 type CmdHandler struct {
-	prefix string
-	re     *regexp.Regexp
+	prefix    string
+	prefixes  []string
+	caseFold  bool
+	autoHelp  bool
+	re        *regexp.Regexp
+	mentionRe *regexp.Regexp
+
+	mu       sync.Mutex
+	cmds     map[string]*Command
+	guard    func(*Input) bool
+	observer func(name string, took time.Duration, failed bool)
+	notFound func(input *Input, name string)
+	bridge   func(input *Input, msg string)
+	allow    map[string]bool
+	used     map[string]time.Time // "command nick" -> last use, for cooldowns
+	admins   []string
+	clock    Clock
+}
+
+// Clock is the source of time for cooldowns and the bot's other timing
+// dependent features, so they can be driven by a fake clock in tests.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// RealClock is a Clock backed by the time package.
+type RealClock struct{}
+
+func (RealClock) Now() time.Time                         { return time.Now() }
+func (RealClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// defaultCategory is the help category for commands without one.
+const defaultCategory = "General"
+
+// helpPageLen caps the length of each page of the help listing, leaving room
+// within the 512 byte IRC line limit for the message prefix and page footer.
+const helpPageLen = 350
 
-	mu   sync.Mutex
-	cmds map[string]*Command
+// genPages returns the help listing of all registered commands, grouped by
+// category, e.g. "Admin: die, say | General: ping", split into pages of at
+// most max bytes. ch.mu must be held by the caller.
+func (ch *CmdHandler) genPages(max int) []string {
+	groups := make(map[string][]string)
+	for name, cmd := range ch.cmds {
+		// Skip aliases, which share the command pointer.
+		if name != cmd.Name || cmd.Hidden || !ch.allowed(name) {
+			continue
+		}
+
+		category := cmd.Category
+		if category == "" {
+			category = defaultCategory
+		}
+
+		groups[category] = append(groups[category], name)
+	}
+
+	categories := make([]string, 0, len(groups))
+	for category := range groups {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	var pages []string
+	var page, pageCategory string
+	for _, category := range categories {
+		sort.Strings(groups[category])
+		for _, name := range groups[category] {
+			next := ", " + name
+			if pageCategory != category {
+				next = "{b}" + category + "{b}: " + name
+				if page != "" {
+					next = " | " + next
+				}
+			}
+
+			if page != "" && len(page)+len(next) > max {
+				pages = append(pages, page)
+				page = ""
+				next = "{b}" + category + "{b}: " + name
+			}
+
+			page += next
+			pageCategory = category
+		}
+	}
+
+	if page != "" {
+		pages = append(pages, page)
+	}
+
+	return pages
+}
+
+// replyListing replies to event with the given page (1-based) of the help
+// listing. ch.mu must be held by the caller.
+func (ch *CmdHandler) replyListing(client *girc.Client, event girc.Event, page int) {
+	pages := ch.genPages(helpPageLen)
+	if len(pages) > 0 && (page < 1 || page > len(pages)) {
+		client.Cmd.ReplyTof(event, girc.Fmt("there is no help page {b}%d{b} (there are %d)."), page, len(pages))
+		return
+	}
+
+	if len(pages) > 0 {
+		listing := pages[page-1]
+		if page < len(pages) {
+			listing += fmt.Sprintf(" (page %d/%d, use %shelp %d)", page, len(pages), ch.prefix, page+1)
+		} else if len(pages) > 1 {
+			listing += fmt.Sprintf(" (page %d/%d)", page, len(pages))
+		}
+
+		client.Cmd.ReplyTo(event, girc.Fmt(listing))
+	}
+
+	if page == 1 {
+		client.Cmd.ReplyTof(event, girc.Fmt("type '{b}%shelp {blue}<command>{c}{b}' to optionally get more info about a specific command."), ch.prefix)
+	}
 }
 
 // return a list of all registered commands
@@ -93,38 +321,227 @@ func (ch *CmdHandler) Commands() string {
 	return strings.Join(cmds, ", ")
 }
 
+// CommandInfo describes a registered command, e.g. for generating
+// documentation. It has JSON tags so it can be written out as is.
+type CommandInfo struct {
+	Name     string   `json:"name"`
+	Aliases  []string `json:"aliases,omitempty"`
+	Help     string   `json:"help"`
+	MinArgs  int      `json:"min_args"`
+	MaxArgs  int      `json:"max_args"`
+	Category string   `json:"category"`
+	Admin    bool     `json:"admin,omitempty"`
+	Hidden   bool     `json:"hidden,omitempty"`
+	Cooldown string   `json:"cooldown,omitempty"` // e.g. "10s"
+	Contexts []string `json:"contexts,omitempty"` // "channel", "pm"; empty is anywhere
+}
+
+// Export describes every registered command, sorted by name, with aliases
+// listed under their command rather than separately. Hidden commands are
+// included, flagged as such, as are ones excluded by SetAllowlist.
+func (ch *CmdHandler) Export() []CommandInfo {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	var infos []CommandInfo
+	for name, cmd := range ch.cmds {
+		// Skip aliases, which share the command pointer.
+		if name != cmd.Name {
+			continue
+		}
+
+		info := CommandInfo{
+			Name:     cmd.Name,
+			Aliases:  slices.Clone(cmd.Aliases),
+			Help:     cmd.Help,
+			MinArgs:  cmd.MinArgs,
+			MaxArgs:  cmd.MaxArgs,
+			Category: cmd.Category,
+			Admin:    cmd.Admin,
+			Hidden:   cmd.Hidden,
+			Contexts: cmd.Contexts.names(),
+		}
+		if info.Category == "" {
+			info.Category = defaultCategory
+		}
+		if cmd.Cooldown > 0 {
+			info.Cooldown = cmd.Cooldown.String()
+		}
+
+		infos = append(infos, info)
+	}
+
+	slices.SortFunc(infos, func(a, b CommandInfo) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return infos
+}
+
+// Errors returned by New, NewWithOptions and CmdHandler.Add, which callers
+// can check for with errors.Is.
+var (
+	ErrEmptyPrefix      = errors.New("empty command prefix")
+	ErrNilCommand       = errors.New("nil command")
+	ErrNilFunc          = errors.New("command has no Fn")
+	ErrInvalidName      = errors.New("invalid command name")
+	ErrReservedName     = errors.New("reserved command name")
+	ErrDuplicateCommand = errors.New("command already registered")
+)
+
+// Has reports whether name is a registered command or alias.
+func (ch *CmdHandler) Has(name string) bool {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	_, ok := ch.cmds[strings.ToLower(name)]
+	return ok
+}
+
 var cmdMatch = `^%s([a-z0-9-_]{1,20})(?: (.*))?$`
 
+// Option configures a CmdHandler created with NewWithOptions.
+type Option func(*CmdHandler)
+
+// WithPrefix sets the command prefix, e.g. "!" or ".".
+func WithPrefix(prefix string) Option {
+	return func(ch *CmdHandler) {
+		ch.prefixes = []string{prefix}
+	}
+}
+
+// WithPrefixes sets multiple command prefixes, any of which may be used to
+// invoke a command. The first is the one shown in help output.
+func WithPrefixes(prefixes ...string) Option {
+	return func(ch *CmdHandler) {
+		ch.prefixes = append([]string(nil), prefixes...)
+	}
+}
+
+// WithCaseInsensitive allows commands to be invoked in any case, e.g. "!PING"
+// as well as "!ping".
+func WithCaseInsensitive(enabled bool) Option {
+	return func(ch *CmdHandler) {
+		ch.caseFold = enabled
+	}
+}
+
+// WithAutoHelp toggles the built-in "help" command. It is enabled by default.
+func WithAutoHelp(enabled bool) Option {
+	return func(ch *CmdHandler) {
+		ch.autoHelp = enabled
+	}
+}
+
+// WithClock sets the Clock used for cooldowns. It defaults to RealClock.
+func WithClock(clock Clock) Option {
+	return func(ch *CmdHandler) {
+		ch.clock = clock
+	}
+}
+
 // New returns a new CmdHandler based on the specified command prefix. A good
 // prefix is a single character, and easy to remember/use. E.g. "!", or ".".
 func New(prefix string) (*CmdHandler, error) {
-	re, err := regexp.Compile(fmt.Sprintf(cmdMatch, regexp.QuoteMeta(prefix)))
+	return NewWithOptions(WithPrefix(prefix))
+}
+
+// NewWithOptions returns a new CmdHandler configured by opts. At least one
+// prefix must be supplied with WithPrefix or WithPrefixes.
+func NewWithOptions(opts ...Option) (*CmdHandler, error) {
+	ch := &CmdHandler{autoHelp: true, cmds: make(map[string]*Command), used: make(map[string]time.Time), clock: RealClock{}}
+	for _, opt := range opts {
+		opt(ch)
+	}
+
+	if len(ch.prefixes) == 0 {
+		return nil, ErrEmptyPrefix
+	}
+	ch.prefix = ch.prefixes[0]
+
+	quoted := make([]string, 0, len(ch.prefixes))
+	for _, prefix := range ch.prefixes {
+		if prefix == "" {
+			return nil, ErrEmptyPrefix
+		}
+
+		quoted = append(quoted, regexp.QuoteMeta(prefix))
+	}
+
+	match := cmdMatch
+	if ch.caseFold {
+		match = "(?i)" + match
+	}
+
+	var err error
+	ch.re, err = regexp.Compile(fmt.Sprintf(match, "(?:"+strings.Join(quoted, "|")+")"))
 	if err != nil {
 		return nil, err
 	}
 
-	return &CmdHandler{prefix: prefix, re: re, cmds: make(map[string]*Command)}, nil
+	// When the bot is addressed directly, the prefix is optional.
+	ch.mentionRe, err = regexp.Compile(fmt.Sprintf(match, "(?:"+strings.Join(quoted, "|")+")?"))
+	if err != nil {
+		return nil, err
+	}
+
+	return ch, nil
+}
+
+// StripMention returns the remainder of text if it begins with nick followed
+// by ":" or ",", e.g. "SpawnBot: ping" or "spawnbot, ping".
+func StripMention(nick, text string) (string, bool) {
+	if nick == "" || len(text) <= len(nick) || girc.ToRFC1459(text[:len(nick)]) != girc.ToRFC1459(nick) {
+		return "", false
+	}
+
+	if text[len(nick)] != ':' && text[len(nick)] != ',' {
+		return "", false
+	}
+
+	return strings.TrimSpace(text[len(nick)+1:]), true
 }
 
-var validName = regexp.MustCompile(`^[a-z0-9-_]{1,20}$`)
+// validName allows multi-word names (e.g. "weather forecast"), where each word
+// follows the same rules as a single-word name.
+var validName = regexp.MustCompile(`^[a-z0-9-_]{1,20}( [a-z0-9-_]{1,20})*$`)
+
+// checkName validates a lowercased command name or alias.
+func (ch *CmdHandler) checkName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("%w: %q (req: %q)", ErrInvalidName, name, validName.String())
+	}
+
+	if ch.autoHelp && name == "help" {
+		return fmt.Errorf("%w: %q", ErrReservedName, name)
+	}
+
+	return nil
+}
 
 // Add registers a new command to the handler. Note that you cannot remove
-// commands once added, unless you add another CmdHandler to the client.
+// commands once added, unless you add another CmdHandler to the client. The
+// command is rejected, and nothing is registered, if it has no Fn, an invalid
+// or reserved name, or a name or alias which is already taken.
 func (ch *CmdHandler) Add(cmd *Command) error {
 	if cmd == nil {
-		return errors.New("nil command provided to CmdHandler")
+		return ErrNilCommand
+	}
+
+	if cmd.Fn == nil {
+		return fmt.Errorf("%w: %s", ErrNilFunc, cmd.Name)
 	}
 
 	cmd.Name = strings.ToLower(cmd.Name)
-	if !validName.MatchString(cmd.Name) {
-		return fmt.Errorf("invalid command name: %q (req: %q)", cmd.Name, validName.String())
+	if err := ch.checkName(cmd.Name); err != nil {
+		return err
 	}
 
 	if cmd.Aliases != nil {
 		for i := range cmd.Aliases {
 			cmd.Aliases[i] = strings.ToLower(cmd.Aliases[i])
-			if !validName.MatchString(cmd.Aliases[i]) {
-				return fmt.Errorf("invalid command name: %q (req: %q)", cmd.Aliases[i], validName.String())
+			if err := ch.checkName(cmd.Aliases[i]); err != nil {
+				return err
 			}
 		}
 	}
@@ -136,31 +553,242 @@ func (ch *CmdHandler) Add(cmd *Command) error {
 	ch.mu.Lock()
 	defer ch.mu.Unlock()
 
-	if _, ok := ch.cmds[cmd.Name]; ok {
-		return fmt.Errorf("command already registered: %s", cmd.Name)
+	// Check every name up front, so a collision doesn't leave the command
+	// partially registered.
+	names := append([]string{cmd.Name}, cmd.Aliases...)
+	for i, name := range names {
+		if _, ok := ch.cmds[name]; ok || slices.Contains(names[:i], name) {
+			return fmt.Errorf("%w: %s", ErrDuplicateCommand, name)
+		}
 	}
 
-	ch.cmds[cmd.Name] = cmd
+	for _, name := range names {
+		ch.cmds[name] = cmd
+	}
 
-	// Since we'd be storing pointers, duplicates do not matter.
-	for i := range cmd.Aliases {
-		if _, ok := ch.cmds[cmd.Aliases[i]]; ok {
-			return fmt.Errorf("alias already registered: %s", cmd.Aliases[i])
-		}
+	return nil
+}
 
-		ch.cmds[cmd.Aliases[i]] = cmd
+// AddAll registers each of cmds, as with Add. If any is rejected, those
+// already added from the batch are removed again, so the handler is never
+// left half-populated, and the error is returned.
+func (ch *CmdHandler) AddAll(cmds ...*Command) error {
+	for i, cmd := range cmds {
+		if err := ch.Add(cmd); err != nil {
+			ch.remove(cmds[:i]...)
+			return err
+		}
 	}
 
 	return nil
 }
 
+// remove unregisters cmds, including their aliases.
+func (ch *CmdHandler) remove(cmds ...*Command) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	for _, cmd := range cmds {
+		for _, name := range append([]string{cmd.Name}, cmd.Aliases...) {
+			if ch.cmds[name] == cmd {
+				delete(ch.cmds, name)
+			}
+		}
+	}
+}
+
+// lookup resolves the longest registered command name which prefixes words,
+// returning the matched name, the command and the remaining words. ch.mu must
+// be held by the caller.
+func (ch *CmdHandler) lookup(words []string) (string, *Command, []string) {
+	for n := len(words); n > 0; n-- {
+		name := strings.ToLower(strings.Join(words[:n], " "))
+		if cmd, ok := ch.cmds[name]; ok {
+			return name, cmd, words[n:]
+		}
+	}
+
+	return "", nil, nil
+}
+
+// SetAdmins sets the hostmasks (e.g. "nick!*@host.example.com") which are
+// allowed to run commands flagged as Admin. Masks may contain "*" globs, and
+// are matched case-insensitively. A mask of the form "$a:account" instead
+// matches the services account from the IRCv3 account-tag, which is more
+// reliable than a hostmask on networks which support it.
+func (ch *CmdHandler) SetAdmins(masks ...string) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.admins = make([]string, 0, len(masks))
+	for _, mask := range masks {
+		ch.admins = append(ch.admins, girc.ToRFC1459(mask))
+	}
+}
+
+// IsAdmin reports whether the source of event matches one of the admin masks
+// registered with SetAdmins, by hostmask or by its IRCv3 account-tag.
+func (ch *CmdHandler) IsAdmin(event girc.Event) bool {
+	account, _ := event.Tags.Get("account")
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	return ch.isAdmin(event.Source, account)
+}
+
+// isAdmin reports whether src or account matches one of the admin masks.
+// ch.mu must be held by the caller.
+func (ch *CmdHandler) isAdmin(src *girc.Source, account string) bool {
+	if src == nil {
+		return false
+	}
+
+	mask := girc.ToRFC1459(src.String())
+	account = girc.ToRFC1459(account)
+	for _, admin := range ch.admins {
+		if name, ok := strings.CutPrefix(admin, "$a:"); ok {
+			if account != "" && girc.Glob(account, name) {
+				return true
+			}
+
+			continue
+		}
+
+		if girc.Glob(mask, admin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cooldown returns how much longer nick must wait to use cmd, or 0 if they
+// may use it now, in which case the use is recorded. ch.mu must be held by
+// the caller.
+func (ch *CmdHandler) cooldown(cmd *Command, nick string, now time.Time) time.Duration {
+	key := cmd.Name + " " + girc.ToRFC1459(nick)
+	if wait := ch.used[key].Add(cmd.Cooldown).Sub(now); wait > 0 {
+		return wait
+	}
+
+	ch.used[key] = now
+
+	// Prune expired entries now and then, so the map doesn't grow forever.
+	if len(ch.used) > 1000 {
+		for key, used := range ch.used {
+			if now.Sub(used) > time.Hour {
+				delete(ch.used, key)
+			}
+		}
+	}
+
+	return 0
+}
+
+// SetGuard registers a function which is called with the parsed input before
+// any command lookup takes place. If the guard returns false, the input is
+// dropped and no command (including help) is executed. The guard is called
+// without the handler locked, so it may call the handler's methods. Passing
+// nil removes the guard.
+func (ch *CmdHandler) SetGuard(guard func(input *Input) bool) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.guard = guard
+}
+
+// SetObserver registers a function which is called after each command's Fn
+// returns, with the command name and how long it took. failed is true if Fn
+// panicked. Passing nil removes the observer.
+func (ch *CmdHandler) SetObserver(observer func(name string, took time.Duration, failed bool)) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.observer = observer
+}
+
+// SetAllowlist restricts the handler to only running the named commands
+// (including "help"), ignoring all others even if registered. An empty list
+// allows all commands.
+func (ch *CmdHandler) SetAllowlist(names []string) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.allow = nil
+	if len(names) == 0 {
+		return
+	}
+
+	ch.allow = make(map[string]bool, len(names))
+	for _, name := range names {
+		ch.allow[strings.ToLower(name)] = true
+	}
+}
+
+// allowed reports whether the command name may be run. ch.mu must be held by
+// the caller.
+func (ch *CmdHandler) allowed(name string) bool {
+	return ch.allow == nil || ch.allow[name]
+}
+
+// SetBridge registers a function which is also sent the replies of commands
+// with BridgeResponses set. Passing nil removes it.
+func (ch *CmdHandler) SetBridge(bridge func(input *Input, msg string)) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.bridge = bridge
+}
+
+// SetNotFound registers a function which is called when input uses the
+// command prefix (or addresses the bot) but name isn't a registered command.
+// Passing nil removes it.
+func (ch *CmdHandler) SetNotFound(notFound func(input *Input, name string)) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	ch.notFound = notFound
+}
+
+// run calls cmd.Fn, reporting its duration to observer if set. A panic in Fn
+// is recovered and logged, and the invoker is told the command failed, so one
+// bad command can't take down the bot.
+func run(cmd *Command, client *girc.Client, in *Input, observer func(string, time.Duration, bool)) {
+	start := time.Now()
+	defer func() {
+		perr := recover()
+		if perr != nil {
+			slog.Error("[CMD] Command panicked", slog.String("command", cmd.Name), slog.Any("err", perr), slog.String("stack", string(debug.Stack())))
+			client.Cmd.ReplyTof(*in.Origin, girc.Fmt("command {b}%q{b} failed."), cmd.Name)
+		}
+
+		if observer != nil {
+			observer(cmd.Name, time.Since(start), perr != nil)
+		}
+	}()
+
+	cmd.Fn(client, in)
+}
+
 // Execute satisfies the girc.Handler interface.
 func (ch *CmdHandler) Execute(client *girc.Client, event girc.Event) {
 	if event.Source == nil || event.Command != girc.PRIVMSG {
 		return
 	}
 
-	parsed := ch.re.FindStringSubmatch(event.Last())
+	// Commands are only recognised at the start of the line, ignoring leading
+	// whitespace, so "blah !ping" never triggers one.
+	text := strings.TrimLeft(event.Last(), " \t")
+
+	var parsed []string
+	rest, mentioned := StripMention(client.GetNick(), text)
+	if mentioned {
+		parsed = ch.mentionRe.FindStringSubmatch(rest)
+	} else {
+		parsed = ch.re.FindStringSubmatch(text)
+	}
+
 	if len(parsed) != 3 {
 		return
 	}
@@ -171,33 +799,100 @@ func (ch *CmdHandler) Execute(client *girc.Client, event girc.Event) {
 		args = []string{}
 	}
 
+	in := &Input{
+		Origin:       &event,
+		Args:         args,
+		RawArgs:      parsed[2],
+		WasMentioned: mentioned,
+		client:       client,
+	}
+
+	if account, ok := event.Tags.Get("account"); ok {
+		in.Account = account
+	}
+
+	ch.mu.Lock()
+	guard := ch.guard
+	in.IsAdmin = ch.isAdmin(event.Source, in.Account)
+	ch.mu.Unlock()
+
+	// The guard runs without ch.mu held, so it may use ch itself.
+	if guard != nil && !guard(in) {
+		return
+	}
+
 	ch.mu.Lock()
 	defer ch.mu.Unlock()
 
-	if invCmd == "help" {
-		if len(args) == 0 {
-			client.Cmd.ReplyTo(event, girc.Fmt("type '{b}!help {blue}<command>{c}{b}' to optionally get more info about a specific command."))
+	if ch.autoHelp && invCmd == "help" {
+		if !ch.allowed("help") {
 			return
 		}
 
-		args[0] = strings.ToLower(args[0])
+		query := strings.ToLower(strings.Join(args, " "))
+
+		// "help <n>" shows page n of the listing, unless a command is
+		// actually named n.
+		page := 1
+		if n, err := strconv.Atoi(query); err == nil && ch.cmds[query] == nil {
+			page, query = n, ""
+		}
+
+		if query == "" {
+			ch.replyListing(client, event, page)
+			return
+		}
 
-		if _, ok := ch.cmds[args[0]]; !ok {
-			client.Cmd.ReplyTof(event, girc.Fmt("unknown command {b}%q{b}."), args[0])
+		if _, ok := ch.cmds[query]; !ok {
+			client.Cmd.ReplyTof(event, girc.Fmt("unknown command {b}%q{b}."), query)
 			return
 		}
 
-		if ch.cmds[args[0]].Help == "" {
-			client.Cmd.ReplyTof(event, girc.Fmt("there is no help documentation for {b}%q{b}"), args[0])
+		if ch.cmds[query].Help == "" {
+			client.Cmd.ReplyTof(event, girc.Fmt("there is no help documentation for {b}%q{b}"), query)
 			return
 		}
 
-		client.Cmd.ReplyTo(event, girc.Fmt(ch.cmds[args[0]].genHelp(ch.prefix)))
+		client.Cmd.ReplyTo(event, girc.Fmt(ch.cmds[query].genHelp(ch.prefix)))
+		return
+	}
+
+	name := invCmd
+	invCmd, cmd, args := ch.lookup(append([]string{invCmd}, args...))
+	if cmd == nil {
+		if ch.notFound != nil {
+			go ch.notFound(in, name)
+		}
 		return
 	}
 
-	cmd, ok := ch.cmds[invCmd]
-	if !ok {
+	if !ch.allowed(cmd.Name) {
+		return
+	}
+
+	in.RawArgs = strings.Join(args, " ")
+	if cmd.MaxArgs > 0 && len(args) > cmd.MaxArgs {
+		// Fold the optional trailing args into the last one, e.g. a reason.
+		args = append(args[:cmd.MaxArgs-1:cmd.MaxArgs-1], strings.Join(args[cmd.MaxArgs-1:], " "))
+	}
+	in.Args = args
+
+	if cmd.BridgeResponses {
+		in.bridge = ch.bridge
+	}
+
+	if cmd.Admin && !in.IsAdmin {
+		client.Cmd.ReplyTof(event, girc.Fmt("you are not allowed to use {b}%q{b}."), invCmd)
+		return
+	}
+
+	if !cmd.Contexts.allows(event) {
+		where := "in a channel"
+		if cmd.Contexts == ContextPM {
+			where = "by private message"
+		}
+
+		client.Cmd.Noticef(event.Source.Name, girc.Fmt("{b}%q{b} can only be used %s."), invCmd, where)
 		return
 	}
 
@@ -206,11 +901,12 @@ func (ch *CmdHandler) Execute(client *girc.Client, event girc.Event) {
 		return
 	}
 
-	in := &Input{
-		Origin:  &event,
-		Args:    args,
-		RawArgs: parsed[2],
+	if cmd.Cooldown > 0 && !in.IsAdmin {
+		if wait := ch.cooldown(cmd, event.Source.Name, ch.clock.Now()); wait > 0 {
+			client.Cmd.ReplyTof(event, girc.Fmt("{b}%q{b} is on cooldown, try again in %s."), invCmd, wait.Round(time.Second))
+			return
+		}
 	}
 
-	go cmd.Fn(client, in)
+	go run(cmd, client, in, ch.observer)
 }
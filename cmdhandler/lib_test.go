@@ -0,0 +1,905 @@
+package cmdhandler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"spawnbot/internal/testutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+)
+
+// privmsg returns a channel message from nick, as Execute receives it.
+func privmsg(nick, text string) girc.Event {
+	return girc.Event{
+		Source:  &girc.Source{Name: nick, Ident: nick, Host: "example.org"},
+		Command: girc.PRIVMSG,
+		Params:  []string{"#spawn", text},
+	}
+}
+
+// execute runs text from nick through ch, reporting whether it ran a
+// command, which records its name on ran.
+func execute(t *testing.T, ch *CmdHandler, client *girc.Client, ran chan string, nick, text string) (string, bool) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		ch.Execute(client, privmsg(nick, text))
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Execute(%q) from %s deadlocked", text, nick)
+	}
+
+	select {
+	case name := <-ran:
+		return name, true
+	case <-time.After(100 * time.Millisecond):
+		return "", false
+	}
+}
+
+// newTestHandler returns a handler with a command for each of names, which
+// records its name on the returned channel when run.
+func newTestHandler(t *testing.T, names ...string) (*CmdHandler, chan string) {
+	t.Helper()
+
+	ch, err := New("!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ran := make(chan string, 1)
+	for _, name := range names {
+		if err = ch.Add(&Command{Name: name, Fn: func(_ *girc.Client, input *Input) {
+			ran <- name
+		}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return ch, ran
+}
+
+func TestGuard(t *testing.T) {
+	client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "spawnbot"})
+
+	t.Run("blocks everything", func(t *testing.T) {
+		ch, ran := newTestHandler(t, "ping")
+		ch.SetGuard(func(*Input) bool { return false })
+
+		if name, ok := execute(t, ch, client, ran, "alice", "!ping"); ok {
+			t.Errorf("%s ran despite the guard", name)
+		}
+	})
+
+	t.Run("uses the handler", func(t *testing.T) {
+		ch, ran := newTestHandler(t, "ping")
+		// Commands locks the handler, which deadlocks if the guard is
+		// called with it held.
+		ch.SetGuard(func(input *Input) bool {
+			return ch.Commands() != "" && input.Origin.Source.Name != "troll"
+		})
+
+		if _, ok := execute(t, ch, client, ran, "alice", "!ping"); !ok {
+			t.Error("ping didn't run for alice")
+		}
+		if _, ok := execute(t, ch, client, ran, "troll", "!ping"); ok {
+			t.Error("ping ran for troll despite the guard")
+		}
+	})
+
+	t.Run("removed", func(t *testing.T) {
+		ch, ran := newTestHandler(t, "ping")
+		ch.SetGuard(func(*Input) bool { return false })
+		ch.SetGuard(nil)
+
+		if _, ok := execute(t, ch, client, ran, "alice", "!ping"); !ok {
+			t.Error("ping didn't run with the guard removed")
+		}
+	})
+}
+
+func TestMultiWordNames(t *testing.T) {
+	ch, ran := newTestHandler(t, "weather", "weather forecast")
+
+	tests := []struct {
+		text string
+		name string
+		args string
+	}{
+		{"weather london", "weather", "london"},
+		{"weather forecast london", "weather forecast", "london"},
+		{"weather forecast", "weather forecast", ""},
+		{"WEATHER Forecast Paris", "weather forecast", "Paris"},
+		{"weather forecasts", "weather", "forecasts"},
+		{"weather", "weather", ""},
+		{"forecast", "", ""},
+	}
+
+	for _, tt := range tests {
+		name, cmd, args := ch.lookup(strings.Split(tt.text, " "))
+		if name != tt.name || strings.Join(args, " ") != tt.args || (cmd == nil) != (tt.name == "") {
+			t.Errorf("lookup(%q) = %q, %q, want %q, %q", tt.text, name, args, tt.name, tt.args)
+		}
+	}
+
+	// Through Execute, the longest name wins.
+	client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "spawnbot"})
+	if name, ok := execute(t, ch, client, ran, "alice", "!weather forecast london"); !ok || name != "weather forecast" {
+		t.Errorf("!weather forecast london ran %q, want %q", name, "weather forecast")
+	}
+}
+
+func TestMention(t *testing.T) {
+	ch, ran := newTestHandler(t, "ping")
+	client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "spawnbot"})
+
+	tests := []struct {
+		text string
+		runs bool
+	}{
+		{"SpawnBot: ping", true},
+		{"spawnbot, ping", true},
+		{"SpawnBot: !ping", true},
+		{"SpawnBot ping", false},
+		{"SpawnBotty: ping", false},
+		{"alice: ping", false},
+		{"ping", false},
+	}
+
+	for _, tt := range tests {
+		if _, ok := execute(t, ch, client, ran, "alice", tt.text); ok != tt.runs {
+			t.Errorf("%q ran ping = %v, want %v", tt.text, ok, tt.runs)
+		}
+	}
+}
+
+func TestReplyPrivate(t *testing.T) {
+	irc := testutil.NewCommander(t)
+	client, sent := irc.Client, irc.Sent()
+
+	ch, err := New("!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ch.Add(&Command{Name: "secret", Fn: func(_ *girc.Client, input *Input) {
+		input.ReplyPrivate("just for you")
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ch.Execute(client, privmsg("alice", "!secret"))
+
+	select {
+	case e := <-sent:
+		if e.Command != girc.NOTICE || e.Params[0] != "alice" || e.Last() != "just for you" {
+			t.Errorf("sent %q, want a NOTICE to alice", e.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nothing sent")
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	ch, ran := newTestHandler(t)
+	if err := ch.Add(&Command{Name: "say", Admin: true, Fn: func(*girc.Client, *Input) {
+		ran <- "say"
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "spawnbot"})
+
+	if _, ok := execute(t, ch, client, ran, "alice", "!say hi"); ok {
+		t.Error("say ran with no admins set")
+	}
+
+	ch.SetAdmins("ALICE!*@example.org")
+	if _, ok := execute(t, ch, client, ran, "alice", "!say hi"); !ok {
+		t.Error("say didn't run for an admin")
+	}
+	if _, ok := execute(t, ch, client, ran, "mallory", "!say hi"); ok {
+		t.Error("say ran for a non-admin")
+	}
+}
+
+func TestInputIsAdmin(t *testing.T) {
+	ch, ran := newTestHandler(t)
+	// whoami isn't an Admin command, but branches on the invoker.
+	if err := ch.Add(&Command{Name: "whoami", Fn: func(_ *girc.Client, input *Input) {
+		ran <- fmt.Sprint(input.IsAdmin)
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	ch.SetAdmins("admin!*@example.org")
+	client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "spawnbot"})
+
+	for nick, want := range map[string]string{"admin": "true", "alice": "false"} {
+		if got, ok := execute(t, ch, client, ran, nick, "!whoami"); !ok || got != want {
+			t.Errorf("IsAdmin for %s = %q, want %s", nick, got, want)
+		}
+	}
+}
+
+func TestAccountTag(t *testing.T) {
+	ch, err := New("!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounts := make(chan string, 1)
+	if err = ch.Add(&Command{Name: "whoami", Fn: func(_ *girc.Client, input *Input) {
+		accounts <- input.Account
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "spawnbot"})
+
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"@account=AliceQ :alice!a@example.org PRIVMSG #spawn :!whoami", "AliceQ"},
+		{":alice!a@example.org PRIVMSG #spawn :!whoami", ""},
+	}
+
+	for _, tt := range tests {
+		ch.Execute(client, *girc.ParseEvent(tt.line))
+
+		select {
+		case got := <-accounts:
+			if got != tt.want {
+				t.Errorf("%q: Account = %q, want %q", tt.line, got, tt.want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q: whoami didn't run", tt.line)
+		}
+	}
+}
+
+func TestAdminAccount(t *testing.T) {
+	ch, ran := newTestHandler(t)
+	if err := ch.Add(&Command{Name: "say", Admin: true, Fn: func(*girc.Client, *Input) {
+		ran <- "say"
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	ch.SetAdmins("$a:AliceQ")
+	client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "spawnbot"})
+
+	tests := []struct {
+		line string
+		runs bool
+	}{
+		{"@account=aliceq :alice!a@example.org PRIVMSG #spawn :!say hi", true},
+		{"@account=mallory :alice!a@example.org PRIVMSG #spawn :!say hi", false},
+		{":alice!a@example.org PRIVMSG #spawn :!say hi", false},
+	}
+
+	for _, tt := range tests {
+		ch.Execute(client, *girc.ParseEvent(tt.line))
+
+		select {
+		case <-ran:
+			if !tt.runs {
+				t.Errorf("%q ran say", tt.line)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if tt.runs {
+				t.Errorf("%q didn't run say", tt.line)
+			}
+		}
+	}
+}
+
+func TestHelpCategories(t *testing.T) {
+	ch, err := New("!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, cmd := range []*Command{
+		{Name: "say", Category: "Admin"},
+		{Name: "die", Category: "Admin"},
+		{Name: "roll", Category: "Fun"},
+		{Name: "8ball", Category: "Fun"},
+		{Name: "ping"},
+	} {
+		cmd.Fn = func(*girc.Client, *Input) {}
+		if err = ch.Add(cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		max  int
+		want []string
+	}{
+		{helpPageLen, []string{"{b}Admin{b}: die, say | {b}Fun{b}: 8ball, roll | {b}General{b}: ping"}},
+		{40, []string{"{b}Admin{b}: die, say | {b}Fun{b}: 8ball", "{b}Fun{b}: roll | {b}General{b}: ping"}},
+		{30, []string{"{b}Admin{b}: die, say", "{b}Fun{b}: 8ball, roll", "{b}General{b}: ping"}},
+		// A category split across pages is named again on the next.
+		{20, []string{"{b}Admin{b}: die", "{b}Admin{b}: say", "{b}Fun{b}: 8ball", "{b}Fun{b}: roll", "{b}General{b}: ping"}},
+		// A single entry longer than max still gets a page of its own.
+		{5, []string{"{b}Admin{b}: die", "{b}Admin{b}: say", "{b}Fun{b}: 8ball", "{b}Fun{b}: roll", "{b}General{b}: ping"}},
+	}
+
+	for _, tt := range tests {
+		if got := ch.genPages(tt.max); !slices.Equal(got, tt.want) {
+			t.Errorf("genPages(%d) = %q, want %q", tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestHelpPager(t *testing.T) {
+	irc := testutil.NewCommander(t)
+	client, sent := irc.Client, irc.Sent()
+
+	names := make([]string, 60)
+	for i := range names {
+		names[i] = fmt.Sprintf("command%02d", i)
+	}
+	ch, _ := newTestHandler(t, names...)
+
+	help := func(text string) string {
+		t.Helper()
+
+		ch.Execute(client, privmsg("alice", text))
+		select {
+		case e := <-sent:
+			if len(e.Last()) > helpPageLen+40 {
+				t.Errorf("%s replied with %d bytes", text, len(e.Last()))
+			}
+
+			return e.Last()
+		case <-time.After(time.Second):
+			t.Fatalf("%s didn't reply", text)
+			return ""
+		}
+	}
+
+	if got := help("!help"); !strings.Contains(got, "General\x02: command00, ") || !strings.HasSuffix(got, " (page 1/2, use !help 2)") {
+		t.Errorf("!help replied %q, want page 1 of 2", got)
+	}
+	<-sent // the "!help <command>" hint
+
+	if got := help("!help 2"); !strings.Contains(got, "command59") || !strings.HasSuffix(got, " (page 2/2)") {
+		t.Errorf("!help 2 replied %q, want the last page", got)
+	}
+
+	if got := help("!help 3"); !strings.Contains(got, "no help page") {
+		t.Errorf("!help 3 replied %q, want an error", got)
+	}
+}
+
+func TestHiddenCommand(t *testing.T) {
+	irc := testutil.NewCommander(t)
+	client, sent := irc.Client, irc.Sent()
+	ch, ran := newTestHandler(t, "ping")
+	if err := ch.Add(&Command{Name: "debug", Help: "Dumps internal state.", Hidden: true, Fn: func(*girc.Client, *Input) {
+		ran <- "debug"
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if listing := strings.Join(ch.genPages(helpPageLen), " "); strings.Contains(listing, "debug") || !strings.Contains(listing, "ping") {
+		t.Errorf("genPages() = %q, want ping without debug", listing)
+	}
+
+	ch.Execute(client, privmsg("alice", "!help debug"))
+	select {
+	case e := <-sent:
+		if !strings.Contains(e.Last(), "Dumps internal state.") {
+			t.Errorf("!help debug replied %q, want its help", e.Last())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("!help debug didn't reply")
+	}
+
+	if name, ok := execute(t, ch, client, ran, "alice", "!debug"); !ok || name != "debug" {
+		t.Error("hidden command didn't run")
+	}
+}
+
+func TestDetailedHelp(t *testing.T) {
+	irc := testutil.NewCommander(t)
+	client, sent := irc.Client, irc.Sent()
+	ch, _ := newTestHandler(t)
+	if err := ch.Add(&Command{Name: "pong", Aliases: []string{"p", "pingpong"}, Help: "Sends a ping.", Cooldown: 5 * time.Second, Admin: true, Fn: func(*girc.Client, *Input) {}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ch.Execute(client, privmsg("alice", "!help pong"))
+	select {
+	case e := <-sent:
+		help := girc.StripRaw(e.Last())
+		for _, want := range []string{"!pong (!p, !pingpong)", "Sends a ping.", "Cooldown: 5s", "(admin only)"} {
+			if !strings.Contains(help, want) {
+				t.Errorf("!help pong replied %q, want %q in it", help, want)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatal("!help pong didn't reply")
+	}
+}
+
+func TestNewWithOptions(t *testing.T) {
+	if _, err := NewWithOptions(WithCaseInsensitive(true)); err == nil {
+		t.Error("NewWithOptions() without a prefix didn't fail")
+	}
+
+	irc := testutil.NewCommander(t)
+	client, sent := irc.Client, irc.Sent()
+	ch, err := NewWithOptions(WithPrefixes("!", "."), WithCaseInsensitive(true), WithAutoHelp(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ran := make(chan string, 1)
+	if err = ch.Add(&Command{Name: "ping", Fn: func(*girc.Client, *Input) { ran <- "ping" }}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, text := range []string{"!ping", ".ping", "!PING", ".Ping"} {
+		if _, ok := execute(t, ch, client, ran, "alice", text); !ok {
+			t.Errorf("%q didn't run ping", text)
+		}
+	}
+	if _, ok := execute(t, ch, client, ran, "alice", "?ping"); ok {
+		t.Error("?ping ran with an unregistered prefix")
+	}
+
+	ch.Execute(client, privmsg("alice", "!help"))
+	select {
+	case e := <-sent:
+		t.Errorf("!help replied %q with auto-help disabled", e.Last())
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if ch, err = New("!"); err != nil {
+		t.Fatal(err)
+	}
+	if !ch.autoHelp || ch.caseFold || ch.prefix != "!" {
+		t.Errorf("New(\"!\") = autoHelp %v, caseFold %v, prefix %q, want true, false, \"!\"", ch.autoHelp, ch.caseFold, ch.prefix)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, prefixes := range [][]string{{}, {""}, {"!", ""}} {
+		if _, err := NewWithOptions(WithPrefixes(prefixes...)); !errors.Is(err, ErrEmptyPrefix) {
+			t.Errorf("NewWithOptions(WithPrefixes(%q)) = %v, want ErrEmptyPrefix", prefixes, err)
+		}
+	}
+
+	fn := func(*girc.Client, *Input) {}
+	tests := []struct {
+		cmd  *Command
+		want error
+	}{
+		{nil, ErrNilCommand},
+		{&Command{Name: "no way!", Fn: fn}, ErrInvalidName},
+		{&Command{Name: "pong", Aliases: []string{""}, Fn: fn}, ErrInvalidName},
+		{&Command{Name: "help", Fn: fn}, ErrReservedName},
+		{&Command{Name: "pong", Aliases: []string{"HELP"}, Fn: fn}, ErrReservedName},
+		{&Command{Name: "PING", Fn: fn}, ErrDuplicateCommand},
+		{&Command{Name: "pong", Aliases: []string{"ping"}, Fn: fn}, ErrDuplicateCommand},
+	}
+
+	for _, tt := range tests {
+		ch, _ := newTestHandler(t, "ping")
+		if err := ch.Add(tt.cmd); !errors.Is(err, tt.want) {
+			t.Errorf("Add(%+v) = %v, want %v", tt.cmd, err, tt.want)
+		}
+	}
+
+	// Without auto-help, "help" is free to use.
+	ch, err := NewWithOptions(WithPrefix("!"), WithAutoHelp(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ch.Add(&Command{Name: "help", Fn: fn}); err != nil {
+		t.Errorf("Add(help) without auto-help = %v", err)
+	}
+}
+
+func TestAddRejects(t *testing.T) {
+	fn := func(*girc.Client, *Input) {}
+	tests := []struct {
+		name string
+		cmd  *Command
+		want error
+	}{
+		{"nil command", nil, ErrNilCommand},
+		{"no Fn", &Command{Name: "pong"}, ErrNilFunc},
+		{"empty name", &Command{Name: "", Fn: fn}, ErrInvalidName},
+		{"name taken", &Command{Name: "ping", Fn: fn}, ErrDuplicateCommand},
+		{"alias taken", &Command{Name: "pong", Aliases: []string{"p", "ping"}, Fn: fn}, ErrDuplicateCommand},
+		{"alias repeats name", &Command{Name: "pong", Aliases: []string{"pong"}, Fn: fn}, ErrDuplicateCommand},
+		{"alias repeated", &Command{Name: "pong", Aliases: []string{"p", "P"}, Fn: fn}, ErrDuplicateCommand},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, _ := newTestHandler(t, "ping")
+			if err := ch.Add(tt.cmd); !errors.Is(err, tt.want) {
+				t.Fatalf("Add() = %v, want %v", err, tt.want)
+			}
+
+			// A rejected command leaves nothing behind.
+			if got := ch.Commands(); got != "ping" {
+				t.Errorf("registered %q after the rejected Add, want just ping", got)
+			}
+		})
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	irc := testutil.NewCommander(t)
+	client, sent := irc.Client, irc.Sent()
+	ch, ran := newTestHandler(t, "ping")
+
+	failed := make(chan bool, 1)
+	ch.SetObserver(func(name string, _ time.Duration, fail bool) {
+		if name == "boom" {
+			failed <- fail
+		}
+	})
+
+	if err := ch.Add(&Command{Name: "boom", Fn: func(_ *girc.Client, input *Input) {
+		_ = input.Args[5]
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ch.Execute(client, privmsg("alice", "!boom"))
+
+	select {
+	case e := <-sent:
+		if !strings.Contains(e.Last(), "\"boom\"\x02 failed") {
+			t.Errorf("!boom replied %q, want a failure notice", e.Last())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("!boom didn't reply")
+	}
+
+	if !<-failed {
+		t.Error("observer wasn't told the command failed")
+	}
+
+	if name, ok := execute(t, ch, client, ran, "alice", "!ping"); !ok || name != "ping" {
+		t.Error("handler didn't survive the panic")
+	}
+}
+
+func TestMaxArgs(t *testing.T) {
+	irc := testutil.NewCommander(t)
+	client := irc.Client
+	ch, _ := newTestHandler(t)
+
+	got := make(chan []string, 1)
+	if err := ch.Add(&Command{Name: "kick", MinArgs: 1, MaxArgs: 2, Fn: func(_ *girc.Client, input *Input) {
+		got <- input.Args
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"!kick bob", []string{"bob"}},
+		{"!kick bob spamming", []string{"bob", "spamming"}},
+		{"!kick bob spamming repeatedly", []string{"bob", "spamming repeatedly"}},
+	}
+
+	for _, tt := range tests {
+		ch.Execute(client, privmsg("alice", tt.text))
+
+		select {
+		case args := <-got:
+			if !slices.Equal(args, tt.want) {
+				t.Errorf("%s gave args %q, want %q", tt.text, args, tt.want)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s didn't run", tt.text)
+		}
+	}
+
+	// The minimum is still enforced.
+	ch.Execute(client, privmsg("alice", "!kick"))
+	select {
+	case args := <-got:
+		t.Errorf("!kick ran with args %q", args)
+	case e := <-irc.Sent():
+		if !strings.Contains(e.Last(), "help kick") {
+			t.Errorf("!kick replied %q, want a pointer to its help", e.Last())
+		}
+	case <-time.After(time.Second):
+		t.Error("!kick without a nick didn't reply")
+	}
+}
+
+func TestAllowlist(t *testing.T) {
+	irc := testutil.NewCommander(t)
+	client := irc.Client
+	ch, ran := newTestHandler(t, "roll", "die")
+	if err := ch.Add(&Command{Name: "ping", Aliases: []string{"pong"}, Fn: func(*girc.Client, *Input) {
+		ran <- "ping"
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ch.SetAllowlist([]string{"PING", "help"})
+	for _, tt := range []struct {
+		text string
+		want string // empty if nothing should run
+	}{
+		{"!ping", "ping"},
+		{"!pong", "ping"},
+		{"!roll", ""},
+		{"!die", ""},
+	} {
+		if name, ok := execute(t, ch, client, ran, "alice", tt.text); name != tt.want {
+			t.Errorf("%s ran %q (%v), want %q", tt.text, name, ok, tt.want)
+		}
+	}
+
+	if listing := strings.Join(ch.genPages(helpPageLen), " "); listing != "{b}General{b}: ping" {
+		t.Errorf("help listing = %q, want just ping", listing)
+	}
+
+	// An empty allowlist allows everything again.
+	ch.SetAllowlist(nil)
+	if name, ok := execute(t, ch, client, ran, "alice", "!die"); !ok || name != "die" {
+		t.Error("!die didn't run without an allowlist")
+	}
+}
+
+func TestContexts(t *testing.T) {
+	irc := testutil.NewCommander(t)
+	client, sent := irc.Client, irc.Sent()
+	ch, ran := newTestHandler(t)
+	for _, cmd := range []*Command{
+		{Name: "topic", Contexts: ContextChannel},
+		{Name: "die", Contexts: ContextPM},
+		{Name: "roll", Contexts: ContextChannel | ContextPM},
+	} {
+		name := cmd.Name
+		cmd.Fn = func(*girc.Client, *Input) { ran <- name }
+		if err := ch.Add(cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		target string // "#spawn" or the bot's nick for a PM
+		text   string
+		want   string // the notice sent if refused, empty if it should run
+	}{
+		{"#spawn", "!topic", ""},
+		{"SpawnBot", "!topic", `"topic" can only be used in a channel.`},
+		{"SpawnBot", "!die", ""},
+		{"#spawn", "!die", `"die" can only be used by private message.`},
+		{"#spawn", "!roll", ""},
+		{"SpawnBot", "!roll", ""},
+	} {
+		event := privmsg("alice", tt.text)
+		event.Params[0] = tt.target
+		ch.Execute(client, event)
+
+		if tt.want == "" {
+			select {
+			case <-ran:
+			case <-time.After(time.Second):
+				t.Errorf("%s to %s didn't run", tt.text, tt.target)
+			}
+			continue
+		}
+
+		select {
+		case e := <-sent:
+			if e.Command != girc.NOTICE || e.Params[0] != "alice" || girc.StripRaw(e.Last()) != tt.want {
+				t.Errorf("%s to %s sent %q, want a NOTICE to alice saying %q", tt.text, tt.target, e.String(), tt.want)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("%s to %s wasn't refused", tt.text, tt.target)
+		}
+
+		select {
+		case name := <-ran:
+			t.Errorf("%s to %s ran %s", tt.text, tt.target, name)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	var got []string
+	for _, info := range ch.Export() {
+		got = append(got, info.Name+"="+strings.Join(info.Contexts, ","))
+	}
+	if want := []string{"die=pm", "roll=channel,pm", "topic=channel"}; !slices.Equal(got, want) {
+		t.Errorf("Export contexts = %q, want %q", got, want)
+	}
+}
+
+func TestAddAll(t *testing.T) {
+	ch, _ := newTestHandler(t, "ping")
+	fn := func(*girc.Client, *Input) {}
+
+	err := ch.AddAll(
+		&Command{Name: "Roll", Aliases: []string{"dice"}, Fn: fn},
+		&Command{Name: "8ball", Fn: fn},
+		&Command{Name: "ping", Fn: fn},
+		&Command{Name: "time", Fn: fn},
+	)
+	if !errors.Is(err, ErrDuplicateCommand) {
+		t.Fatalf("AddAll() with a duplicate = %v, want ErrDuplicateCommand", err)
+	}
+
+	if names := slices.Sorted(maps.Keys(ch.cmds)); !slices.Equal(names, []string{"ping"}) {
+		t.Errorf("registered %q after a failed batch, want just ping", names)
+	}
+
+	if err = ch.AddAll(&Command{Name: "roll", Aliases: []string{"dice"}, Fn: fn}, &Command{Name: "8ball", Fn: fn}); err != nil {
+		t.Fatalf("AddAll() = %v", err)
+	}
+	if names := slices.Sorted(maps.Keys(ch.cmds)); !slices.Equal(names, []string{"8ball", "dice", "ping", "roll"}) {
+		t.Errorf("registered %q, want the whole batch", names)
+	}
+}
+
+func TestExport(t *testing.T) {
+	ch, err := New("!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := func(*girc.Client, *Input) {}
+
+	err = ch.AddAll(
+		&Command{Name: "Roll", Aliases: []string{"dice", "r"}, Help: "<NdM> -- rolls dice", MinArgs: 1, MaxArgs: 1, Category: "Fun", Cooldown: 10 * time.Second, Fn: fn},
+		&Command{Name: "kick", Help: "<nick> [reason...] -- kicks nick", MinArgs: 1, MaxArgs: 2, Category: "Admin", Admin: true, Fn: fn},
+		&Command{Name: "secret", Hidden: true, Fn: fn},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []CommandInfo{
+		{Name: "kick", Help: "<nick> [reason...] -- kicks nick", MinArgs: 1, MaxArgs: 2, Category: "Admin", Admin: true},
+		{Name: "roll", Aliases: []string{"dice", "r"}, Help: "<NdM> -- rolls dice", MinArgs: 1, MaxArgs: 1, Category: "Fun", Cooldown: "10s"},
+		{Name: "secret", Category: defaultCategory, Hidden: true},
+	}
+
+	got := ch.Export()
+	if !slices.EqualFunc(got, want, func(a, b CommandInfo) bool {
+		return a.Name == b.Name && slices.Equal(a.Aliases, b.Aliases) && a.Help == b.Help && a.MinArgs == b.MinArgs && a.MaxArgs == b.MaxArgs &&
+			a.Category == b.Category && a.Admin == b.Admin && a.Hidden == b.Hidden && a.Cooldown == b.Cooldown
+	}) {
+		t.Errorf("Export() = %+v, want %+v", got, want)
+	}
+
+	// The export is a copy, so changing it leaves the commands alone.
+	got[1].Aliases[0] = "changed"
+	if ch.cmds["roll"].Aliases[0] != "dice" {
+		t.Error("changing the exported aliases changed the command's")
+	}
+
+	encoded, err := json.Marshal(got[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"name":"kick","help":"\u003cnick\u003e [reason...] -- kicks nick","min_args":1,"max_args":2,"category":"Admin","admin":true}`; string(encoded) != want {
+		t.Errorf("JSON = %s, want %s", encoded, want)
+	}
+}
+
+func TestLineStart(t *testing.T) {
+	irc := testutil.NewCommander(t)
+	client := irc.Client
+	ch, ran := newTestHandler(t, "ping")
+
+	for _, tt := range []struct {
+		text string
+		want bool
+	}{
+		{"!ping", true},
+		{"   !ping", true},
+		{"\t!ping", true},
+		{"  SpawnBot: ping", true},
+		{"blah !ping", false},
+		{"blah  !ping now", false},
+		{"! ping", false},
+	} {
+		if _, ok := execute(t, ch, client, ran, "alice", tt.text); ok != tt.want {
+			t.Errorf("%q ran the command: %v, want %v", tt.text, ok, tt.want)
+		}
+	}
+}
+
+func TestCooldownAdminBypass(t *testing.T) {
+	irc := testutil.NewCommander(t)
+	client := irc.Client
+	ch, ran := newTestHandler(t)
+	if err := ch.Add(&Command{Name: "whois", Cooldown: time.Hour, Fn: func(*girc.Client, *Input) {
+		ran <- "whois"
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	ch.SetAdmins("admin!*@example.org")
+
+	for _, nick := range []string{"alice", "admin"} {
+		if _, ok := execute(t, ch, client, ran, nick, "!whois bob"); !ok {
+			t.Errorf("first !whois from %s didn't run", nick)
+		}
+	}
+
+	// Alice is now on cooldown, and told so.
+	if _, ok := execute(t, ch, client, ran, "alice", "!whois bob"); ok {
+		t.Error("!whois ran again for alice during the cooldown")
+	}
+	select {
+	case e := <-irc.Sent():
+		if !strings.Contains(e.Last(), "is on cooldown, try again in 1h0m0s") {
+			t.Errorf("replied %q, want a cooldown notice", e.Last())
+		}
+	case <-time.After(time.Second):
+		t.Error("no cooldown notice")
+	}
+
+	// Each user has their own cooldown, and admins have none.
+	if _, ok := execute(t, ch, client, ran, "bob", "!whois alice"); !ok {
+		t.Error("!whois didn't run for bob")
+	}
+	for range 3 {
+		if _, ok := execute(t, ch, client, ran, "admin", "!whois bob"); !ok {
+			t.Error("admin was held to the cooldown")
+		}
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	clk := testutil.NewClock()
+	ch, err := NewWithOptions(WithPrefix("!"), WithClock(clk))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ran := make(chan string, 1)
+	if err = ch.Add(&Command{Name: "roll", Cooldown: time.Minute, Fn: func(*girc.Client, *Input) {
+		ran <- "roll"
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "spawnbot"})
+
+	steps := []struct {
+		advance time.Duration
+		nick    string
+		runs    bool
+	}{
+		{0, "alice", true},
+		{30 * time.Second, "alice", false},
+		// Cooldowns are per nick.
+		{0, "bob", true},
+		{29 * time.Second, "alice", false},
+		{time.Second, "alice", true},
+		{59 * time.Second, "bob", true},
+	}
+
+	for i, step := range steps {
+		clk.Advance(step.advance)
+		if _, ok := execute(t, ch, client, ran, step.nick, "!roll"); ok != step.runs {
+			t.Errorf("step %d: !roll from %s ran: %v, want %v", i, step.nick, ok, step.runs)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"spawnbot/cmdhandler"
+	"sync"
+	"time"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// ircLine is a line from IRC, ready to relay to Discord.
+type ircLine struct {
+	platform  string // "IRC", or the platform of a bridged user
+	username  string
+	content   string
+	mentioned []snowflake.ID
+}
+
+// pendingLines are the lines a coalescer holds for a channel.
+type pendingLines struct {
+	lines []ircLine
+	first time.Time // when the first line was buffered
+	last  time.Time // when the latest line was buffered
+}
+
+// A coalescer flushes someone who keeps typing anyway once they've buffered
+// coalesceMaxLines lines, or coalesceMaxWindows windows have passed since
+// their first, so they're still relayed and the buffer stays bounded.
+const (
+	coalesceMaxLines   = 10
+	coalesceMaxWindows = 5
+)
+
+// coalescer buffers consecutive lines from the same user in a channel,
+// flushing them together once window passes without another, so a paragraph
+// typed line by line becomes one Discord message. A line from someone else
+// flushes the buffer first, keeping the order lines were said in. flush is
+// called with the coalescer locked, so mustn't block.
+type coalescer struct {
+	clock  cmdhandler.Clock
+	window time.Duration
+	flush  func(channel snowflake.ID, lines []ircLine)
+
+	mu      sync.Mutex
+	pending map[snowflake.ID]*pendingLines
+}
+
+// newCoalescer returns a coalescer flushing after window, timed by clock.
+func newCoalescer(clock cmdhandler.Clock, window time.Duration, flush func(channel snowflake.ID, lines []ircLine)) *coalescer {
+	return &coalescer{clock: clock, window: window, flush: flush, pending: make(map[snowflake.ID]*pendingLines)}
+}
+
+// add buffers line for channel.
+func (c *coalescer) add(channel snowflake.ID, line ircLine) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	p, ok := c.pending[channel]
+	if ok && (p.lines[0].platform != line.platform || p.lines[0].username != line.username) {
+		delete(c.pending, channel)
+		c.flush(channel, p.lines)
+		ok = false
+	}
+
+	if !ok {
+		p = &pendingLines{first: now}
+		c.pending[channel] = p
+	}
+
+	p.lines = append(p.lines, line)
+	p.last = now
+
+	if len(p.lines) >= coalesceMaxLines || now.Sub(p.first) >= coalesceMaxWindows*c.window {
+		delete(c.pending, channel)
+		c.flush(channel, p.lines)
+		return
+	}
+
+	go c.expire(channel, p)
+}
+
+// expire waits out the window after a line, then flushes p unless another
+// line has been added since (which waits out its own window) or it has been
+// flushed already.
+func (c *coalescer) expire(channel snowflake.ID, p *pendingLines) {
+	<-c.clock.After(c.window)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pending[channel] != p || c.clock.Now().Sub(p.last) < c.window {
+		return
+	}
+
+	delete(c.pending, channel)
+	c.flush(channel, p.lines)
+}
+
+// flushAll flushes the lines pending in every channel, e.g. on shutdown.
+func (c *coalescer) flushAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for channel, p := range c.pending {
+		delete(c.pending, channel)
+		c.flush(channel, p.lines)
+	}
+}
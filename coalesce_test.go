@@ -0,0 +1,128 @@
+package main
+
+import (
+	"slices"
+	"spawnbot/internal/testutil"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+func TestCoalescer(t *testing.T) {
+	const window = 2 * time.Second
+
+	newTest := func(t *testing.T) (*coalescer, *testutil.Clock, func() []string, func()) {
+		t.Helper()
+
+		clock := testutil.NewClock()
+		flushed := make(chan []ircLine, 20)
+		c := newCoalescer(clock, window, func(channel snowflake.ID, lines []ircLine) {
+			flushed <- lines
+		})
+
+		expect := func() []string {
+			t.Helper()
+
+			select {
+			case lines := <-flushed:
+				var contents []string
+				for _, line := range lines {
+					contents = append(contents, line.username+": "+line.content)
+				}
+				return contents
+			case <-time.After(time.Second):
+				t.Fatal("nothing flushed")
+				return nil
+			}
+		}
+		expectNone := func() {
+			t.Helper()
+
+			select {
+			case lines := <-flushed:
+				t.Fatalf("flushed %v early", lines)
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+
+		return c, clock, expect, expectNone
+	}
+	say := func(c *coalescer, username, content string) {
+		c.add(SPAWN_CHAN_ID, ircLine{platform: "IRC", username: username, content: content})
+	}
+
+	t.Run("two quick lines", func(t *testing.T) {
+		c, clock, expect, expectNone := newTest(t)
+
+		say(c, "alice", "one")
+		clock.WaitForWaiters(1)
+		clock.Advance(time.Second)
+		say(c, "alice", "two")
+		clock.WaitForWaiters(2)
+
+		// The first line's window has passed, but not the second's.
+		clock.Advance(time.Second)
+		expectNone()
+
+		clock.Advance(time.Second)
+		if got, want := expect(), []string{"alice: one", "alice: two"}; !slices.Equal(got, want) {
+			t.Errorf("flushed %q, want %q", got, want)
+		}
+	})
+
+	t.Run("someone else", func(t *testing.T) {
+		c, clock, expect, _ := newTest(t)
+
+		say(c, "alice", "one")
+		say(c, "bob", "hi")
+		if got, want := expect(), []string{"alice: one"}; !slices.Equal(got, want) {
+			t.Errorf("flushed %q, want %q", got, want)
+		}
+
+		clock.WaitForWaiters(2)
+		clock.Advance(window)
+		if got, want := expect(), []string{"bob: hi"}; !slices.Equal(got, want) {
+			t.Errorf("flushed %q, want %q", got, want)
+		}
+	})
+
+	t.Run("max lines", func(t *testing.T) {
+		c, _, expect, _ := newTest(t)
+
+		for range coalesceMaxLines {
+			say(c, "alice", "spam")
+		}
+		if got := expect(); len(got) != coalesceMaxLines {
+			t.Errorf("flushed %d lines, want %d", len(got), coalesceMaxLines)
+		}
+	})
+
+	t.Run("max age", func(t *testing.T) {
+		c, clock, expect, expectNone := newTest(t)
+
+		// alice never pauses for a whole window, but is relayed anyway.
+		var said int
+		for elapsed := time.Duration(0); elapsed < coalesceMaxWindows*window; elapsed += window * 3 / 4 {
+			say(c, "alice", "still typing")
+			said++
+			clock.Advance(window * 3 / 4)
+		}
+		expectNone()
+
+		say(c, "alice", "still typing")
+		if got := expect(); len(got) != said+1 {
+			t.Errorf("flushed %d lines, want %d", len(got), said+1)
+		}
+	})
+
+	t.Run("flush all", func(t *testing.T) {
+		c, _, expect, _ := newTest(t)
+
+		say(c, "alice", "bye")
+		c.flushAll()
+		if got, want := expect(), []string{"alice: bye"}; !slices.Equal(got, want) {
+			t.Errorf("flushed %q, want %q", got, want)
+		}
+	})
+}
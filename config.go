@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/disgoorg/disgo/gateway"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// appConfig holds the settings the connections are built from, read once
+// from the environment by loadAppConfig rather than inline in main.
+type appConfig struct {
+	// intents are the Discord gateway intents, from SPAWNBOT_DISCORD_INTENTS
+	// plus any needed by enabled features (see gatewayIntents).
+	intents []gateway.Intents
+	// status is shown as the bot's Discord activity while IRC is up, from
+	// SPAWNBOT_DISCORD_STATUS. Empty for none.
+	status string
+	// shards is the Discord gateway shard count, from SPAWNBOT_DISCORD_SHARDS.
+	// Only a single shard is supported for now.
+	shards int
+	// adminRoles are the Discord role IDs, from SPAWNBOT_DISCORD_ADMIN_ROLES,
+	// which may run admin commands from Discord. Empty allows nobody.
+	adminRoles []snowflake.ID
+	// nameStrip, from SPAWNBOT_DISCORD_NAME_STRIP, is removed from Discord
+	// author names relayed to IRC.
+	nameStrip *nameStrip
+	// voiceChannel, from SPAWNBOT_DISCORD_VOICE_ID when SPAWNBOT_RELAY_VOICE
+	// is set, is the voice channel whose joins and leaves are posted to IRC.
+	// Zero leaves them unreported.
+	voiceChannel snowflake.ID
+}
+
+// loadAppConfig reads appConfig from the environment.
+func loadAppConfig() (appConfig, error) {
+	intents, err := gatewayIntents()
+	if err != nil {
+		return appConfig{}, err
+	}
+
+	// Announcing who's in voice needs the channel to watch (and its intent,
+	// which gatewayIntents adds).
+	var voice snowflake.ID
+	if envBool("SPAWNBOT_RELAY_VOICE", false) {
+		if voice, err = snowflake.Parse(os.Getenv("SPAWNBOT_DISCORD_VOICE_ID")); err != nil {
+			return appConfig{}, fmt.Errorf("invalid SPAWNBOT_DISCORD_VOICE_ID: %w", err)
+		}
+	}
+
+	shards := envInt("SPAWNBOT_DISCORD_SHARDS", 1)
+	if shards != 1 {
+		slog.Warn("[CONFIG] Sharding isn't supported yet, using a single shard", slog.Int("shards", shards))
+		shards = 1
+	}
+
+	var roles []snowflake.ID
+	for _, role := range envList("SPAWNBOT_DISCORD_ADMIN_ROLES") {
+		id, err := snowflake.Parse(role)
+		if err != nil {
+			return appConfig{}, fmt.Errorf("invalid discord admin role: %q", role)
+		}
+
+		roles = append(roles, id)
+	}
+
+	strip, err := newNameStrip(os.Getenv("SPAWNBOT_DISCORD_NAME_STRIP"))
+	if err != nil {
+		return appConfig{}, err
+	}
+
+	return appConfig{intents: intents, status: os.Getenv("SPAWNBOT_DISCORD_STATUS"), shards: shards, adminRoles: roles, nameStrip: strip, voiceChannel: voice}, nil
+}
+
+// gatewayOpts builds the Discord gateway options from the config. IRC isn't
+// connected yet when the gateway opens, so the status starts out idle.
+func (c appConfig) gatewayOpts() []gateway.ConfigOpt {
+	opts := []gateway.ConfigOpt{
+		gateway.WithIntents(c.intents...),
+		gateway.WithShardID(0),
+		gateway.WithShardCount(c.shards),
+	}
+
+	if c.status != "" {
+		opts = append(opts, gateway.WithPresenceOpts(presenceOpts(c.status, false)...))
+	}
+
+	return opts
+}
+
+// envBool reads a boolean from the environment variable name, falling back to
+// def when it is unset or can't be parsed.
+func envBool(name string, def bool) bool {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return def
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		slog.Warn("[CONFIG] Invalid boolean, using default", slog.String("var", name), slog.String("value", value), slog.Bool("default", def))
+		return def
+	}
+
+	return parsed
+}
+
+// envList reads a comma-separated list from the environment variable name,
+// trimming whitespace and dropping empty entries.
+func envList(name string) []string {
+	var out []string
+	for _, item := range strings.Split(os.Getenv(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+
+	return out
+}
+
+// envDuration reads a time.Duration (e.g. "5s") from the environment variable
+// name, falling back to def when it is unset or can't be parsed.
+func envDuration(name string, def time.Duration) time.Duration {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return def
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		slog.Warn("[CONFIG] Invalid duration, using default", slog.String("var", name), slog.String("value", value), slog.Duration("default", def))
+		return def
+	}
+
+	return parsed
+}
+
+// envInt reads an integer from the environment variable name, falling back to
+// def when it is unset or can't be parsed.
+func envInt(name string, def int) int {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return def
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("[CONFIG] Invalid integer, using default", slog.String("var", name), slog.String("value", value), slog.Int("default", def))
+		return def
+	}
+
+	return parsed
+}
+
+// envPrefix reads a command prefix (e.g. "!" or ".") from the environment
+// variable name, falling back to "!" when it is unset or contains whitespace.
+func envPrefix(name string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return "!"
+	}
+
+	if strings.ContainsFunc(value, unicode.IsSpace) {
+		slog.Warn("[CONFIG] Invalid command prefix, using default", slog.String("var", name), slog.String("value", value), slog.String("default", "!"))
+		return "!"
+	}
+
+	return value
+}
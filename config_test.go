@@ -0,0 +1,112 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/disgoorg/disgo/gateway"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+func TestLoadAppConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    map[string]string
+		status string
+		guilds bool
+	}{
+		{"defaults", nil, "", false},
+		{"forum", map[string]string{"SPAWNBOT_DISCORD_FORUM_ID": "123"}, "", true},
+		{"status", map[string]string{"SPAWNBOT_DISCORD_STATUS": "bridging #spawn"}, "bridging #spawn", false},
+		{"shards", map[string]string{"SPAWNBOT_DISCORD_SHARDS": "4"}, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"SPAWNBOT_DISCORD_INTENTS", "SPAWNBOT_DISCORD_FORUM_ID", "SPAWNBOT_RELAY_REACTIONS", "SPAWNBOT_DISCORD_STATUS", "SPAWNBOT_DISCORD_SHARDS"} {
+				t.Setenv(key, tt.env[key])
+			}
+
+			config, err := loadAppConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if config.status != tt.status || config.shards != 1 {
+				t.Errorf("config = %+v, want status %q on 1 shard", config, tt.status)
+			}
+
+			gateway_config := gateway.DefaultConfig()
+			gateway_config.Apply(config.gatewayOpts())
+
+			if got := gateway_config.Intents.Has(gateway.IntentGuilds); got != tt.guilds {
+				t.Errorf("intents %v include IntentGuilds = %v, want %v", gateway_config.Intents, got, tt.guilds)
+			}
+
+			if !gateway_config.Intents.Has(gateway.IntentMessageContent) {
+				t.Errorf("intents %v are missing IntentMessageContent", gateway_config.Intents)
+			}
+
+			if gateway_config.ShardID != 0 || gateway_config.ShardCount != 1 {
+				t.Errorf("shard = %d/%d, want 0/1", gateway_config.ShardID, gateway_config.ShardCount)
+			}
+
+			if got := gateway_config.Presence != nil; got != (tt.status != "") {
+				t.Errorf("presence set = %v, want %v", got, tt.status != "")
+			}
+		})
+	}
+}
+
+func TestLoadAppConfigAdminRoles(t *testing.T) {
+	t.Setenv("SPAWNBOT_DISCORD_ADMIN_ROLES", "10, 20")
+
+	config, err := loadAppConfig()
+	if err != nil || !slices.Equal(config.adminRoles, []snowflake.ID{10, 20}) {
+		t.Errorf("loadAppConfig() = %v, %v, want admin roles [10 20]", config.adminRoles, err)
+	}
+
+	t.Setenv("SPAWNBOT_DISCORD_ADMIN_ROLES", "admins")
+	if _, err := loadAppConfig(); err == nil {
+		t.Error("loadAppConfig() accepted an invalid admin role")
+	}
+}
+
+func TestLoadAppConfigVoice(t *testing.T) {
+	t.Setenv("SPAWNBOT_DISCORD_VOICE_ID", "42")
+
+	t.Setenv("SPAWNBOT_RELAY_VOICE", "")
+	if config, err := loadAppConfig(); err != nil || config.voiceChannel != 0 {
+		t.Errorf("loadAppConfig() = %v, %v, want no voice channel unless enabled", config.voiceChannel, err)
+	}
+
+	t.Setenv("SPAWNBOT_RELAY_VOICE", "true")
+	if config, err := loadAppConfig(); err != nil || config.voiceChannel != 42 {
+		t.Errorf("loadAppConfig() = %v, %v, want voice channel 42", config.voiceChannel, err)
+	}
+
+	t.Setenv("SPAWNBOT_DISCORD_VOICE_ID", "")
+	if _, err := loadAppConfig(); err == nil {
+		t.Error("loadAppConfig() accepted voice relay without a channel")
+	}
+}
+
+func TestEnvPrefix(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", "!"},
+		{".", "."},
+		{"/", "/"},
+		{"a b", "!"},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("SPAWNBOT_TEST_PREFIX", tt.value)
+
+		if got := envPrefix("SPAWNBOT_TEST_PREFIX"); got != tt.want {
+			t.Errorf("envPrefix with %q = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
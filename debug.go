@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+)
+
+// slogWriter is an io.Writer which logs each line written to it at debug
+// level, for routing girc's raw debug output through slog.
+type slogWriter struct {
+	prefix string
+}
+
+func (w slogWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\r\n"), "\n") {
+		if line = strings.TrimRight(line, "\r"); line != "" {
+			slog.Debug(w.prefix + " " + line)
+		}
+	}
+
+	return len(p), nil
+}
+
+// componentLogger returns base with the bridge it belongs to and the
+// component logging attached, so several instances sharing one log stream
+// can be told apart, as can their IRC and Discord sides.
+func componentLogger(base *slog.Logger, bridge, component string) *slog.Logger {
+	return base.With(slog.String("bridge", bridge), slog.String("component", component))
+}
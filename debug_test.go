@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"spawnbot/cmdhandler"
+	"spawnbot/internal/testutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+func TestSlogWriter(t *testing.T) {
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	w := slogWriter{prefix: "[IRC]"}
+	raw := "<-- :irc.example.org PING :123\r\n--> PONG :123\r\n\r\n"
+	if n, err := w.Write([]byte(raw)); n != len(raw) || err != nil {
+		t.Errorf("Write() = %d, %v, want %d, nil", n, err, len(raw))
+	}
+
+	want := []string{
+		`level=DEBUG msg="[IRC] <-- :irc.example.org PING :123"`,
+		`level=DEBUG msg="[IRC] --> PONG :123"`,
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("logged %d lines, want %d:\n%s", len(lines), len(want), buf.String())
+	}
+	for i, line := range lines {
+		if !strings.Contains(line, want[i]) {
+			t.Errorf("line %d = %q, want %s", i, line, want[i])
+		}
+	}
+}
+
+// recordWriter passes on each JSON log record written to it.
+type recordWriter chan map[string]any
+
+func (w recordWriter) Write(p []byte) (int, error) {
+	var record map[string]any
+	if err := json.Unmarshal(p, &record); err != nil {
+		return 0, err
+	}
+
+	w <- record
+	return len(p), nil
+}
+
+func TestComponentLogger(t *testing.T) {
+	records := make(recordWriter, 10)
+	base := slog.New(slog.NewJSONHandler(records, nil))
+
+	next := func(t *testing.T) map[string]any {
+		t.Helper()
+
+		select {
+		case record := <-records:
+			return record
+		case <-time.After(time.Second):
+			t.Fatal("nothing logged")
+			return nil
+		}
+	}
+
+	check := func(t *testing.T, record map[string]any, msg, component string) {
+		t.Helper()
+
+		if record["msg"] != msg || record["bridge"] != "test" || record["component"] != component {
+			t.Errorf("logged %v, want msg %q with bridge \"test\" and component %q", record, msg, component)
+		}
+	}
+
+	t.Run("irc", func(t *testing.T) {
+		fake := testutil.NewCommander(t)
+		cmdHandler, err := cmdhandler.New("!")
+		if err != nil {
+			t.Fatal(err)
+		}
+		registerInviteHandler(componentLogger(base, "test", "irc"), fake.Client, cmdHandler, nil, nil)
+
+		fake.Send(":alice!a@example.org INVITE SpawnBot #elsewhere")
+		check(t, next(t), "[IRC] Declined invite", "irc")
+	})
+
+	t.Run("discord", func(t *testing.T) {
+		dis_client, _ := fakeDiscord(t)
+		fake := testutil.NewCommander(t)
+		ignored, _ := newIgnoreList("", nil)
+		registerDiscordHandlers(componentLogger(base, "test", "discord"), dis_client, cmdhandler.RealClock{}, fake.Client, spawnRoutes(), nil, newOutbox("IRC", 10), nil, ignored, ignored, nil, nil, newMessageCache(10), []snowflake.ID{10}, nil, nil, "", 0, "", func() {})
+
+		dispatchMessage(dis_client, discord.Message{ChannelID: SPAWN_CHAN_ID, Author: discord.User{Username: "alice"}, Member: &discord.Member{}, Content: "!die"})
+		check(t, next(t), "[DISCORD] Admin command denied", "discord")
+	})
+}
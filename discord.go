@@ -0,0 +1,721 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"spawnbot/cmdhandler"
+	"strings"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+	"github.com/disgoorg/disgo/gateway"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/lrstanley/girc"
+)
+
+// registerDiscordHandlers wires up the Discord event listeners: the
+// Discord->IRC relay between the channels paired in routes, queued on irc_out
+// and throttled by limiter, and the Discord-side commands (see commands
+// below), such as "!die", which calls die if the invoker holds one of
+// admin_roles. Messages from usernames on ignored, or from users who opted out
+// with "!bridge optout" (recorded by ID in opted_out), aren't relayed, words
+// on blocked are masked or dropped, author names are passed through strip, and
+// hooks is notified of each relay. Relayed messages are remembered in cache so
+// deletions can quote them. Posts in the threads of forum, if set, are relayed
+// into its IRC channel prefixed with the thread name. Joins and leaves of the
+// voice channel voice, if set, are announced in voice_channel. The "!whois"
+// rate limit is timed by clk, and everything is logged to logger. Lines are
+// split to fit the limits the IRC server advertised in support, and marked as
+// relayed with marker, if set, while messages marked with it aren't relayed.
+// This must only be called once per client.
+func registerDiscordHandlers(logger *slog.Logger, dis_client bot.Client, clk cmdhandler.Clock, irc_client *girc.Client, routes *channelMap, forum *forumThreads, irc_out *outbox, limiter *relayLimiter, ignored *ignoreList, opted_out *ignoreList, blocked *blocklist, hooks *relay, cache *messageCache, admin_roles []snowflake.ID, strip *nameStrip, support *serverSupport, marker string, voice snowflake.ID, voice_channel string, die func()) {
+	// SPAWNBOT_RELAY_DISCORD_TO_IRC=false makes the bridge a one-way mirror,
+	// though the commands still work.
+	relay := envBool("SPAWNBOT_RELAY_DISCORD_TO_IRC", true)
+	// SPAWNBOT_RELAY_DELETES=true posts a notice to IRC when a message is
+	// deleted on Discord. It's off by default as it's noisy.
+	relay_deletes := envBool("SPAWNBOT_RELAY_DELETES", false)
+	// SPAWNBOT_RELAY_REACTIONS=true relays reactions to bridged messages,
+	// naming whose message was reacted to.
+	relay_reactions := envBool("SPAWNBOT_RELAY_REACTIONS", false)
+	// SPAWNBOT_RELAY_PINS=true posts a notice to IRC when a message is pinned
+	// in a bridged channel, previewing it.
+	relay_pins := envBool("SPAWNBOT_RELAY_PINS", false)
+	// SPAWNBOT_IRC_RELAY_AS_NOTICE=true relays as NOTICE rather than PRIVMSG,
+	// which some channels prefer for bots. Other bots won't reply to it.
+	send_irc := irc_client.Cmd.Message
+	if envBool("SPAWNBOT_IRC_RELAY_AS_NOTICE", false) {
+		send_irc = irc_client.Cmd.Notice
+	}
+	// Everything relayed is marked, so it isn't relayed back.
+	send := func(target, message string) {
+		send_irc(target, markRelayed(message, marker))
+	}
+	// SPAWNBOT_MENTION_REPLY, if set, is the reply to anyone who @-mentions
+	// the bot without running a command, e.g. "Hi! I bridge this channel to
+	// IRC. Try !help.", sent at most once per SPAWNBOT_MENTION_REPLY_INTERVAL.
+	mention_reply := os.Getenv("SPAWNBOT_MENTION_REPLY")
+	mention_limiter := newRelayLimiter(clk, 1, envDuration("SPAWNBOT_MENTION_REPLY_INTERVAL", time.Minute))
+	// Up to SPAWNBOT_MAX_ATTACHMENT_URLS attachments are linked on IRC, any
+	// more are summed up instead.
+	max_attachments := envInt("SPAWNBOT_MAX_ATTACHMENT_URLS", 3)
+
+	// Lookups of IRC users with "!whois <nick>", at most one per 10 seconds.
+	whois_limiter := newRelayLimiter(clk, 1, 10*time.Second)
+	// Commands run from Discord, by their text without the prefix, which is
+	// SPAWNBOT_DISCORD_PREFIX (e.g. "." to differ from IRC), "!" by default.
+	prefix := envPrefix("SPAWNBOT_DISCORD_PREFIX")
+	commands := map[string]discordCommand{
+		"die": {admin: true, channels: []snowflake.ID{SPAWN_CHAN_ID}, run: func(discord.Message, string) {
+			die()
+		}},
+		"whois": {args: true, run: func(message discord.Message, nick string) {
+			whoisDiscord(logger, dis_client, irc_client, whois_limiter, prefix, message.ChannelID, nick)
+		}},
+		"bridge optout": {run: func(message discord.Message, _ string) {
+			bridgePreference(logger, dis_client, message, opted_out, true)
+		}},
+		"bridge optin": {run: func(message discord.Message, _ string) {
+			bridgePreference(logger, dis_client, message, opted_out, false)
+		}},
+		"source": {run: func(message discord.Message, _ string) {
+			replyDiscord(logger, dis_client, message, aboutReply())
+		}},
+		// Works in any channel, as it's for finding the IDs to bridge.
+		"id": {anywhere: true, run: func(message discord.Message, _ string) {
+			replyDiscord(logger, dis_client, message, channelIDs(message))
+		}},
+	}
+
+	dis_client.AddEventListeners(bot.NewListenerFunc(func(event *events.MessageCreate) {
+		if event.Message.Author.Bot || isRelayed(event.Message.Content, marker) {
+			return
+		}
+
+		// disgo RESUMEs after a blip, replaying anything missed, which can
+		// include messages already relayed. Those are still cached.
+		if _, seen := cache.get(event.Message.ID.String()); seen {
+			logger.Debug("[DISCORD] Skipping message already relayed", slog.String("id", event.Message.ID.String()))
+			return
+		}
+
+		// "[DISCORD]", or "[DISCORD] [thread name]" for forum posts.
+		tag := "[DISCORD]"
+		irc_channel, ok := routes.irc(event.Message.ChannelID)
+		if !ok && forum != nil {
+			var thread string
+			if thread, ok = forum.thread(event.Message.ChannelID); ok {
+				irc_channel = forum.channel
+				tag += " [" + thread + "]"
+			}
+		}
+
+		if name, cmd, args, found := lookupDiscordCommand(commands, prefix, event.Message.Content); found && cmd.allowedIn(event.Message.ChannelID, ok) {
+			if !cmd.admin || hasAdminRole(event.Message.Member, admin_roles) {
+				cmd.run(event.Message, args)
+				return
+			}
+
+			logger.Warn("[DISCORD] Admin command denied", slog.String("command", name), slog.String("user", event.Message.Author.Username))
+		}
+
+		if mention_reply != "" && mentionsUser(event.Message.Content, dis_client.ID()) {
+			if allowed, _ := mention_limiter.allow(); allowed {
+				replyDiscord(logger, dis_client, event.Message, mention_reply)
+			}
+		}
+
+		// if event.Message.ChannelID == BRINE_CHAN_ID {
+		if ok {
+			if !relay || hooks.isPaused() || ignored.has(event.Message.Author.Username) || opted_out.has(event.Message.Author.ID.String()) {
+				return
+			}
+
+			ok, dropped := limiter.allow()
+			if !ok {
+				return
+			}
+
+			if dropped > 0 {
+				notice := throttleNotice("DISCORD", dropped)
+				logger.Warn(notice)
+				irc_out.push(func() {
+					send(irc_channel, notice)
+				})
+			}
+
+			var author string = strip.apply(event.Message.Author.Username)
+			content, ok := blocked.filter(formatDiscordContent(event.Message.Content))
+			if !ok {
+				return
+			}
+
+			// for _, mention := range event.Message.Mentions {
+			// 	if strings.Contains(content, mention.ID.String()) {
+			// 		content = strings.Replace(content, mention.ID.String(), mention.Username, 1)
+			// 	}
+			// }
+
+			//         /## /##                 /##          /##
+			//        | ##|__/                |  ##        |__/
+			//    /####### /##  /#######       \  ##        /##  /######   /#######
+			//   /##__  ##| ## /##_____/        \  ##      | ## /##__  ## /##_____/
+			//  | ##  | ##| ##|  ######          /##/      | ##| ##  \__/| ##
+			//  | ##  | ##| ## \____  ##        /##/       | ##| ##      | ##
+			//  |  #######| ## /#######/       /##/        | ##| ##      |  #######
+			//   \_______/|__/|_______/       |__/         |__/|__/       \_______/
+			// Long messages are split into lines which each fit on IRC with
+			// the "[DISCORD] author: " prefix repeated, sized to the server's
+			// advertised limits in support.
+			max_len := support.maxEventLength() - len(marker)
+			var messages []string
+			if content != "" {
+				messages = append(messages, splitIRC(irc_channel, fmt.Sprintf("%s %s: ", tag, author), sanitizeIRC(content), max_len)...)
+			}
+
+			for _, embed := range event.Message.Embeds {
+				if summary, ok := blocked.filter(embedSummary(embed)); ok && summary != "" {
+					messages = append(messages, splitIRC(irc_channel, fmt.Sprintf("%s %s: ", tag, author), sanitizeIRC(summary), max_len)...)
+				}
+			}
+
+			// Attachments are linked one per line, or summed up once there
+			// are more than max_attachments of them.
+			for _, line := range attachmentLines(event.Message.Attachments, max_attachments) {
+				messages = append(messages, fmt.Sprintf("%s %s: %s", tag, author, line))
+			}
+
+			for _, sticker := range event.Message.StickerItems {
+				messages = append(messages, fmt.Sprintf("%s %s sent sticker: %s", tag, author, sticker.Name))
+			}
+
+			if len(messages) == 0 {
+				return
+			}
+
+			cache.add(event.Message.ID.String(), author, content)
+
+			irc_out.push(func() {
+				for _, message := range messages {
+					send(irc_channel, sanitizeIRC(message))
+					// irc_client.Cmd.Message("#spawnbot", message)
+					// logger.Info(message)
+				}
+
+				hooks.relayed(discordToIRC, author, strings.Join(messages, "\n"))
+			})
+		}
+	}))
+
+	if forum != nil {
+		dis_client.AddEventListeners(bot.NewListenerFunc(func(event *events.ThreadCreate) {
+			forum.add(event.Thread)
+		}), bot.NewListenerFunc(func(event *events.ThreadUpdate) {
+			forum.add(event.Thread)
+		}))
+	}
+
+	if relay_reactions {
+		dis_client.AddEventListeners(bot.NewListenerFunc(func(event *events.GuildMessageReactionAdd) {
+			if !relay || hooks.isPaused() || event.Member.User.Bot {
+				return
+			}
+
+			irc_channel, ok := routes.irc(event.ChannelID)
+			if !ok || ignored.has(event.Member.User.Username) || opted_out.has(event.UserID.String()) {
+				return
+			}
+
+			// Only messages we bridged are cached, so reactions to anything
+			// else are dropped.
+			cached, ok := cache.get(event.MessageID.String())
+			if !ok {
+				return
+			}
+
+			notice := reactionNotice(event.Member.User.Username, event.Emoji, cached.author)
+			irc_out.push(func() {
+				send(irc_channel, sanitizeIRC(notice))
+			})
+		}))
+	}
+
+	// Joins and leaves of the voice channel, if set, are announced in
+	// voice_channel. They need the GuildVoiceStates intent.
+	if voice != 0 {
+		dis_client.AddEventListeners(bot.NewListenerFunc(func(event *events.GuildVoiceStateUpdate) {
+			if !relay || hooks.isPaused() {
+				return
+			}
+
+			notice, ok := voiceUpdate(event, voice, strip, ignored, opted_out)
+			if !ok {
+				return
+			}
+
+			irc_out.push(func() {
+				send(voice_channel, sanitizeIRC(notice))
+				hooks.relayed(discordToIRC, event.Member.User.Username, notice)
+			})
+		}))
+	}
+
+	if relay_pins {
+		dis_client.AddEventListeners(bot.NewListenerFunc(func(event *events.GuildChannelPinsUpdate) {
+			if !relay || hooks.isPaused() {
+				return
+			}
+
+			irc_channel, ok := routes.irc(event.ChannelID)
+			if !ok {
+				return
+			}
+
+			// The event fires for unpins too, but only a pin moves the last
+			// pin time forward. It doesn't say which message was pinned, but
+			// the newest pin comes first.
+			if event.NewLastPinTimestamp == nil || (event.OldLastPinTimestamp != nil && !event.NewLastPinTimestamp.After(*event.OldLastPinTimestamp)) {
+				return
+			}
+
+			pins, err := dis_client.Rest().GetPinnedMessages(event.ChannelID)
+			if err != nil {
+				logger.Error("[DISCORD] Unable to fetch pinned messages", slog.Any("err", err))
+				return
+			} else if len(pins) == 0 {
+				return
+			}
+
+			// Bridged messages are previewed as relayed, e.g. under the IRC
+			// nick for those from IRC.
+			author, content := strip.apply(pins[0].Author.Username), formatDiscordContent(pins[0].Content)
+			if cached, ok := cache.get(pins[0].ID.String()); ok {
+				author, content = cached.author, cached.content
+			}
+
+			if content, ok = blocked.filter(content); !ok {
+				return
+			}
+
+			// Those who opted out or are ignored aren't quoted, or named.
+			if opted_out.has(pins[0].Author.ID.String()) || ignored.has(pins[0].Author.Username) || ignored.has(author) {
+				author, content = "", ""
+			}
+
+			notice := pinNotice(author, content)
+			irc_out.push(func() {
+				send(irc_channel, sanitizeIRC(notice))
+			})
+		}))
+	}
+
+	dis_client.AddEventListeners(bot.NewListenerFunc(func(event *events.MessageDelete) {
+		if !relay || !relay_deletes || hooks.isPaused() {
+			return
+		}
+
+		irc_channel, ok := routes.irc(event.ChannelID)
+		if !ok {
+			return
+		}
+
+		notice := "[DISCORD] a message was deleted"
+		if cached, ok := cache.get(event.MessageID.String()); ok {
+			notice = fmt.Sprintf("[DISCORD] %s deleted: %s", cached.author, cached.content)
+		}
+
+		irc_out.push(func() {
+			send(irc_channel, sanitizeIRC(notice))
+		})
+	}))
+}
+
+// whoisDiscord answers "<prefix>whois <nick>" from Discord by looking nick up
+// on IRC and posting the result to channel, unless limiter is exhausted. The
+// reply takes a round trip to the IRC server, so it's waited for off the
+// event loop.
+func whoisDiscord(logger *slog.Logger, dis_client bot.Client, irc_client *girc.Client, limiter *relayLimiter, prefix string, channel snowflake.ID, nick string) {
+	if ok, _ := limiter.allow(); !ok {
+		return
+	}
+
+	go func() {
+		var content string
+		if !girc.IsValidNick(nick) {
+			content = "usage: " + prefix + "whois <nick>"
+		} else if reply, err := queryWhois(irc_client, nick, 10*time.Second); err != nil {
+			content = err.Error()
+		} else {
+			content = reply.String()
+		}
+
+		if _, err := dis_client.Rest().CreateMessage(channel, discordMessage(content, nil, false).Build()); err != nil {
+			logger.Error("[DISCORD] Errors while sending message to discord", slog.Any("err", err))
+		}
+	}()
+}
+
+// discordCommand is a command run from a bridged Discord channel.
+type discordCommand struct {
+	// admin restricts the command to members holding one of the admin roles.
+	admin bool
+	// channels are the Discord channels the command may be run in. Empty
+	// allows every bridged channel, or with anywhere, every channel at all.
+	// Elsewhere, it's relayed as a message.
+	channels []snowflake.ID
+	anywhere bool
+	// args lets the command take arguments after its name, e.g. "!whois
+	// alice", which are passed to run.
+	args bool
+	run  func(message discord.Message, args string)
+}
+
+// allowedIn reports whether the command may be run in channel, which may or
+// may not be bridged.
+func (c discordCommand) allowedIn(channel snowflake.ID, bridged bool) bool {
+	if len(c.channels) > 0 {
+		return slices.Contains(c.channels, channel)
+	}
+
+	return bridged || c.anywhere
+}
+
+// lookupDiscordCommand returns the command content runs, its name and any
+// arguments, if content is prefix followed by one of commands, e.g. "!bridge
+// optout", or "!whois alice" for those taking arguments. Text without the
+// prefix never runs a command, so chatting about "id" is safe.
+func lookupDiscordCommand(commands map[string]discordCommand, prefix, content string) (string, discordCommand, string, bool) {
+	text, found := strings.CutPrefix(content, prefix)
+	if !found {
+		return "", discordCommand{}, "", false
+	}
+
+	if cmd, found := commands[text]; found {
+		return text, cmd, "", true
+	}
+
+	name, args, _ := strings.Cut(text, " ")
+	if cmd, found := commands[name]; found && cmd.args {
+		return name, cmd, strings.TrimSpace(args), true
+	}
+
+	return "", discordCommand{}, "", false
+}
+
+// channelIDs reports the IDs of the channel and guild message was sent in,
+// for "!id".
+func channelIDs(message discord.Message) string {
+	reply := "channel ID: " + message.ChannelID.String()
+	if message.GuildID != nil {
+		reply += ", guild ID: " + message.GuildID.String()
+	}
+
+	return reply
+}
+
+// hasAdminRole reports whether member holds one of the admin roles. As with
+// IRC admins, nobody is one when no roles are configured. Members are only
+// known for guild messages, so a nil member has none.
+func hasAdminRole(member *discord.Member, roles []snowflake.ID) bool {
+	if member == nil {
+		return false
+	}
+
+	for _, role := range member.RoleIDs {
+		if slices.Contains(roles, role) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bridgePreference handles "!bridge optout" and "!bridge optin" from
+// Discord, recording whether the author's messages are bridged to IRC and
+// confirming in the channel.
+func bridgePreference(logger *slog.Logger, dis_client bot.Client, message discord.Message, opted_out *ignoreList, optout bool) {
+	var changed bool
+	var err error
+	if optout {
+		changed, err = opted_out.add(message.Author.ID.String())
+	} else {
+		changed, err = opted_out.del(message.Author.ID.String())
+	}
+
+	if err != nil {
+		logger.Error("[DISCORD] Unable to save bridge opt-outs", slog.Any("err", err))
+	}
+
+	reply := "your messages are bridged to IRC"
+	switch {
+	case optout && changed:
+		reply = "your messages will no longer be bridged to IRC"
+	case optout:
+		reply = "your messages already aren't bridged to IRC"
+	case changed:
+		reply = "your messages will be bridged to IRC again"
+	}
+
+	replyDiscord(logger, dis_client, message, reply)
+}
+
+// replyDiscord replies to message with content, in the same channel, logging
+// any error to logger.
+func replyDiscord(logger *slog.Logger, dis_client bot.Client, message discord.Message, content string) {
+	create := discordMessage(content, nil, false).SetMessageReferenceByID(message.ID).Build()
+	if _, err := dis_client.Rest().CreateMessage(message.ChannelID, create); err != nil {
+		logger.Error("[DISCORD] Errors while sending message to discord", slog.Any("err", err))
+	}
+}
+
+// mentionsUser reports whether content @-mentions the user id, as "<@id>" or
+// the older "<@!id>". Unlike Message.Mentions, this ignores the implicit
+// mention of replying to one of their messages.
+func mentionsUser(content string, id snowflake.ID) bool {
+	return strings.Contains(content, "<@"+id.String()+">") || strings.Contains(content, "<@!"+id.String()+">")
+}
+
+// reactionNotice describes a reaction on IRC, e.g. "[DISCORD] alice reacted
+// 👍 to bob's message". Custom emoji are shown as ":name:".
+func reactionNotice(reactor string, emoji discord.PartialEmoji, author string) string {
+	var name string
+	switch {
+	case emoji.Name == nil:
+		name = "an emoji"
+	case emoji.ID != nil:
+		name = ":" + *emoji.Name + ":"
+	default:
+		name = *emoji.Name
+	}
+
+	return fmt.Sprintf("[DISCORD] %s reacted %s to %s's message", reactor, name, author)
+}
+
+// discordMaxLen is the maximum length, in characters, of a Discord message.
+const discordMaxLen = 2000
+
+// splitContent splits content into chunks of at most max characters,
+// breaking at the last space in each chunk where there is one.
+func splitContent(content string, max int) []string {
+	var chunks []string
+	runes := []rune(content)
+	for len(runes) > max {
+		cut := max
+		for i := max; i > max/2; i-- {
+			if runes[i] == ' ' {
+				cut = i
+				break
+			}
+		}
+
+		chunks = append(chunks, string(runes[:cut]))
+		runes = []rune(strings.TrimLeft(string(runes[cut:]), " "))
+	}
+
+	return append(chunks, string(runes))
+}
+
+// attachmentLines returns the URLs of attachments to relay, one per line, or
+// if there are more than max of them a single summary such as "3 images + 7
+// files, see Discord".
+func attachmentLines(attachments []discord.Attachment, max int) []string {
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	if len(attachments) <= max {
+		urls := make([]string, len(attachments))
+		for i, attachment := range attachments {
+			urls[i] = attachment.URL
+		}
+
+		return urls
+	}
+
+	var images, files int
+	for _, attachment := range attachments {
+		if attachment.ContentType != nil && strings.HasPrefix(*attachment.ContentType, "image/") {
+			images++
+		} else {
+			files++
+		}
+	}
+
+	var counts []string
+	if images > 0 {
+		counts = append(counts, plural(images, "image"))
+	}
+	if files > 0 {
+		counts = append(counts, plural(files, "file"))
+	}
+
+	return []string{strings.Join(counts, " + ") + ", see Discord"}
+}
+
+// plural formats n of noun, e.g. "1 file" or "7 files".
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// maxPinPreview caps how much of a pinned message is quoted on IRC.
+const maxPinPreview = 100
+
+// pinNotice describes a pinned message on IRC, e.g. "* a message by alice
+// was pinned: see the FAQ", quoting at most the first line of content, up to
+// maxPinPreview characters. Without an author it's just "* a message was
+// pinned".
+func pinNotice(author, content string) string {
+	if author == "" {
+		return "* a message was pinned"
+	}
+
+	preview, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+	if runes := []rune(preview); len(runes) > maxPinPreview {
+		preview = strings.TrimSpace(string(runes[:maxPinPreview])) + "…"
+	}
+
+	if preview == "" {
+		return "* a message by " + author + " was pinned"
+	}
+
+	return "* a message by " + author + " was pinned: " + preview
+}
+
+// maxEmbedDescription caps how much of an embed's description is relayed when
+// it has no title.
+const maxEmbedDescription = 100
+
+// embedSummary condenses an embed into a single line for IRC, e.g.
+// "[embed] Title — https://example.com". The description stands in for a
+// missing title. Embeds with nothing to show return "".
+func embedSummary(embed discord.Embed) string {
+	title := strings.TrimSpace(embed.Title)
+	if title == "" {
+		title, _, _ = strings.Cut(strings.TrimSpace(embed.Description), "\n")
+		if runes := []rune(title); len(runes) > maxEmbedDescription {
+			title = strings.TrimSpace(string(runes[:maxEmbedDescription])) + "…"
+		}
+	}
+
+	switch {
+	case title != "" && embed.URL != "":
+		return "[embed] " + title + " — " + embed.URL
+	case title != "":
+		return "[embed] " + title
+	case embed.URL != "":
+		return "[embed] " + embed.URL
+	default:
+		return ""
+	}
+}
+
+// customEmoji matches Discord custom emoji markup, both static (<:name:id>)
+// and animated (<a:name:id>).
+var customEmoji = regexp.MustCompile(`<a?:(\w+):\d+>`)
+
+// formatDiscordContent converts Discord-specific markup in content into
+// something readable on IRC. Custom emoji become ":name:", while unicode emoji
+// are passed through untouched.
+func formatDiscordContent(content string) string {
+	return customEmoji.ReplaceAllString(content, ":$1:")
+}
+
+// discordIntents maps normalised intent names (see parseIntents) to their
+// disgo gateway intents.
+var discordIntents = map[string]gateway.Intents{
+	"guilds":                      gateway.IntentGuilds,
+	"guildmembers":                gateway.IntentGuildMembers,
+	"guildmoderation":             gateway.IntentGuildModeration,
+	"guildexpressions":            gateway.IntentGuildExpressions,
+	"guildintegrations":           gateway.IntentGuildIntegrations,
+	"guildwebhooks":               gateway.IntentGuildWebhooks,
+	"guildinvites":                gateway.IntentGuildInvites,
+	"guildvoicestates":            gateway.IntentGuildVoiceStates,
+	"guildpresences":              gateway.IntentGuildPresences,
+	"guildmessages":               gateway.IntentGuildMessages,
+	"guildmessagereactions":       gateway.IntentGuildMessageReactions,
+	"guildmessagetyping":          gateway.IntentGuildMessageTyping,
+	"directmessages":              gateway.IntentDirectMessages,
+	"directmessagereactions":      gateway.IntentDirectMessageReactions,
+	"directmessagetyping":         gateway.IntentDirectMessageTyping,
+	"messagecontent":              gateway.IntentMessageContent,
+	"guildscheduledevents":        gateway.IntentGuildScheduledEvents,
+	"automoderationconfiguration": gateway.IntentAutoModerationConfiguration,
+	"automoderationexecution":     gateway.IntentAutoModerationExecution,
+	"guildmessagepolls":           gateway.IntentGuildMessagePolls,
+	"directmessagepolls":          gateway.IntentDirectMessagePolls,
+}
+
+// defaultIntents are used when SPAWNBOT_DISCORD_INTENTS is unset.
+var defaultIntents = []gateway.Intents{gateway.IntentGuildMessages, gateway.IntentMessageContent}
+
+// parseIntents maps intent names to disgo gateway intents. Names are matched
+// case-insensitively, ignoring underscores and an "Intent" prefix, so
+// "GUILD_MESSAGES", "GuildMessages" and "IntentGuildMessages" are all
+// equivalent. An empty list returns defaultIntents.
+func parseIntents(names []string) ([]gateway.Intents, error) {
+	if len(names) == 0 {
+		return defaultIntents, nil
+	}
+
+	intents := make([]gateway.Intents, 0, len(names))
+	for _, name := range names {
+		key := strings.ToLower(strings.ReplaceAll(name, "_", ""))
+		key = strings.TrimPrefix(key, "intent")
+
+		intent, ok := discordIntents[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown discord intent: %q", name)
+		}
+
+		intents = append(intents, intent)
+	}
+
+	return intents, nil
+}
+
+// gatewayIntents returns the intents named in SPAWNBOT_DISCORD_INTENTS (see
+// parseIntents), plus any the configured features rely on, whatever else is
+// configured: forum posts are relayed by thread, learnt of from the thread
+// events IntentGuilds brings, and relaying reactions or voice joins needs
+// their events.
+func gatewayIntents() ([]gateway.Intents, error) {
+	intents, err := parseIntents(envList("SPAWNBOT_DISCORD_INTENTS"))
+	if err != nil {
+		return nil, err
+	}
+
+	if os.Getenv("SPAWNBOT_DISCORD_FORUM_ID") != "" {
+		intents = withIntent(intents, gateway.IntentGuilds)
+	}
+	if envBool("SPAWNBOT_RELAY_REACTIONS", false) {
+		intents = withIntent(intents, gateway.IntentGuildMessageReactions)
+	}
+	if envBool("SPAWNBOT_RELAY_VOICE", false) {
+		intents = withIntent(intents, gateway.IntentGuildVoiceStates)
+	}
+	if envBool("SPAWNBOT_RELAY_PINS", false) {
+		intents = withIntent(intents, gateway.IntentGuilds)
+	}
+
+	return intents, nil
+}
+
+// withIntent returns intents with intent added, if it isn't already there,
+// leaving intents itself untouched.
+func withIntent(intents []gateway.Intents, intent gateway.Intents) []gateway.Intents {
+	if slices.Contains(intents, intent) {
+		return intents
+	}
+
+	return append(slices.Clip(intents), intent)
+}
@@ -0,0 +1,1096 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"spawnbot/cmdhandler"
+	"spawnbot/internal/testutil"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/disgoorg/disgo"
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+	"github.com/disgoorg/disgo/gateway"
+	"github.com/disgoorg/disgo/rest"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/lrstanley/girc"
+)
+
+// posted is a message created through a fakeDiscord client.
+type posted struct {
+	channel snowflake.ID
+	discord.MessageCreate
+}
+
+// failContent makes the fakeDiscord API refuse to create a message which
+// contains it.
+const failContent = "<fail>"
+
+// fakeDiscord returns a client whose REST calls go to a fake API, and the
+// messages created through it. Messages containing failContent are refused
+// with a 403, and those over discordMaxLen characters as Discord does.
+func fakeDiscord(t *testing.T) (bot.Client, <-chan posted) {
+	t.Helper()
+
+	created := make(chan posted, 10)
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var channel snowflake.ID
+		if _, err := fmt.Sscanf(r.URL.Path, "/channels/%d/messages", &channel); err != nil || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+
+		message := posted{channel: channel}
+		if err := json.NewDecoder(r.Body).Decode(&message.MessageCreate); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if strings.Contains(message.Content, failContent) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"code":50013,"message":"Missing Permissions"}`)
+			return
+		}
+		if utf8.RuneCountInString(message.Content) > discordMaxLen {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"code":50035,"errors":{"content":{"_errors":[{"code":"BASE_TYPE_MAX_LENGTH","message":"Must be 2000 or fewer in length."}]}},"message":"Invalid Form Body"}`)
+			return
+		}
+		created <- message
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"1","channel_id":"%d","content":%q}`, channel, message.Content)
+	}))
+	t.Cleanup(api.Close)
+
+	// The token only needs to carry an application ID ("123").
+	dis_client, err := disgo.New("MTIz.fake.token", bot.WithRestClientConfigOpts(rest.WithURL(api.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dis_client, created
+}
+
+// spawnRoutes returns the default bridge of #spawn with SPAWN_CHAN_ID.
+func spawnRoutes() *channelMap {
+	routes := newChannelMap()
+	routes.add("#spawn", SPAWN_CHAN_ID)
+
+	return routes
+}
+
+// dispatchMessage delivers message to dis_client's listeners as if it had
+// arrived over the gateway.
+func dispatchMessage(dis_client bot.Client, message discord.Message) {
+	dis_client.EventManager().DispatchEvent(&events.MessageCreate{GenericMessage: &events.GenericMessage{
+		GenericEvent: events.NewGenericEvent(dis_client, 0, 0),
+		MessageID:    message.ID,
+		Message:      message,
+		ChannelID:    message.ChannelID,
+		GuildID:      message.GuildID,
+	}})
+}
+
+func TestFormatDiscordContent(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"hello <:smile:123456>", "hello :smile:"},
+		{"<a:party_parrot:987654321> yay", ":party_parrot: yay"},
+		{"<:a:1><a:b:2>", ":a::b:"},
+		{"unicode 😀 stays", "unicode 😀 stays"},
+		{"mixed <:smile:1> 😀 <a:dance:2>", "mixed :smile: 😀 :dance:"},
+		// Not emoji markup, so left alone.
+		{"<:smile:> and <@1234>", "<:smile:> and <@1234>"},
+	}
+
+	for _, tt := range tests {
+		if got := formatDiscordContent(tt.in); got != tt.want {
+			t.Errorf("formatDiscordContent(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseIntents(t *testing.T) {
+	tests := []struct {
+		names   []string
+		want    []gateway.Intents
+		wantErr bool
+	}{
+		{nil, defaultIntents, false},
+		{[]string{"GUILD_MESSAGES"}, []gateway.Intents{gateway.IntentGuildMessages}, false},
+		{[]string{"GuildMessages", "IntentMessageContent"}, []gateway.Intents{gateway.IntentGuildMessages, gateway.IntentMessageContent}, false},
+		{[]string{"direct_messages"}, []gateway.Intents{gateway.IntentDirectMessages}, false},
+		{[]string{"guilds", "bogus"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseIntents(tt.names)
+		if (err != nil) != tt.wantErr || !slices.Equal(got, tt.want) {
+			t.Errorf("parseIntents(%q) = %v, %v, want %v (error %v)", tt.names, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestGatewayIntents(t *testing.T) {
+	tests := []struct {
+		name    string
+		intents string
+		forum   string
+		react   string
+		voice   string
+		pins    string
+		want    []gateway.Intents
+	}{
+		{"defaults", "", "", "", "", "", defaultIntents},
+		{"forum", "", "123", "", "", "", []gateway.Intents{gateway.IntentGuildMessages, gateway.IntentMessageContent, gateway.IntentGuilds}},
+		{"forum with guilds", "guilds,guild_messages", "123", "", "", "", []gateway.Intents{gateway.IntentGuilds, gateway.IntentGuildMessages}},
+		{"reactions", "guild_messages", "", "true", "", "", []gateway.Intents{gateway.IntentGuildMessages, gateway.IntentGuildMessageReactions}},
+		{"voice", "guild_messages", "", "", "true", "", []gateway.Intents{gateway.IntentGuildMessages, gateway.IntentGuildVoiceStates}},
+		{"pins", "guild_messages", "", "", "", "true", []gateway.Intents{gateway.IntentGuildMessages, gateway.IntentGuilds}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SPAWNBOT_DISCORD_INTENTS", tt.intents)
+			t.Setenv("SPAWNBOT_DISCORD_FORUM_ID", tt.forum)
+			t.Setenv("SPAWNBOT_RELAY_REACTIONS", tt.react)
+			t.Setenv("SPAWNBOT_RELAY_VOICE", tt.voice)
+			t.Setenv("SPAWNBOT_RELAY_PINS", tt.pins)
+
+			got, err := gatewayIntents()
+			if err != nil || !slices.Equal(got, tt.want) {
+				t.Errorf("gatewayIntents() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+
+	if !slices.Equal(defaultIntents, []gateway.Intents{gateway.IntentGuildMessages, gateway.IntentMessageContent}) {
+		t.Errorf("defaultIntents changed to %v", defaultIntents)
+	}
+}
+
+func TestEmbedSummary(t *testing.T) {
+	long := strings.Repeat("a", maxEmbedDescription+10)
+
+	tests := []struct {
+		embed discord.Embed
+		want  string
+	}{
+		{discord.Embed{Title: "Release", URL: "https://example.com"}, "[embed] Release — https://example.com"},
+		{discord.Embed{Title: " Release "}, "[embed] Release"},
+		{discord.Embed{URL: "https://example.com"}, "[embed] https://example.com"},
+		{discord.Embed{Description: "first\nsecond"}, "[embed] first"},
+		{discord.Embed{Title: "Release", Description: "ignored"}, "[embed] Release"},
+		{discord.Embed{Description: long}, "[embed] " + long[:maxEmbedDescription] + "…"},
+		{discord.Embed{}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := embedSummary(tt.embed); got != tt.want {
+			t.Errorf("embedSummary(%+v) = %q, want %q", tt.embed, got, tt.want)
+		}
+	}
+}
+
+func TestRelaySticker(t *testing.T) {
+	dis_client, _ := fakeDiscord(t)
+	fake := testutil.NewCommander(t)
+	irc_client, sent := fake.Client, fake.Sent()
+	ignored, _ := newIgnoreList("", nil)
+	registerDiscordHandlers(slog.Default(), dis_client, cmdhandler.RealClock{}, irc_client, spawnRoutes(), nil, newOutbox("IRC", 10), nil, ignored, ignored, nil, nil, newMessageCache(10), nil, nil, nil, "", 0, "", func() {})
+
+	dispatchMessage(dis_client, discord.Message{
+		ChannelID:    SPAWN_CHAN_ID,
+		Author:       discord.User{Username: "alice"},
+		StickerItems: []discord.MessageSticker{{ID: 1, Name: "wave", FormatType: discord.StickerFormatTypePNG}},
+	})
+
+	select {
+	case e := <-sent:
+		if e.Command != girc.PRIVMSG || e.Last() != "[DISCORD] alice sent sticker: wave" {
+			t.Errorf("relayed %q, want the sticker name", e.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("sticker not relayed")
+	}
+
+	// A message with neither content nor stickers relays nothing.
+	dispatchMessage(dis_client, discord.Message{ChannelID: SPAWN_CHAN_ID, Author: discord.User{Username: "alice"}})
+
+	select {
+	case e := <-sent:
+		t.Errorf("relayed %q for an empty message", e.String())
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestRelayDirections(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("discord to irc enabled=%v", enabled), func(t *testing.T) {
+			t.Setenv("SPAWNBOT_RELAY_DISCORD_TO_IRC", fmt.Sprint(enabled))
+
+			dis_client, _ := fakeDiscord(t)
+			fake := testutil.NewCommander(t)
+			irc_client, sent := fake.Client, fake.Sent()
+			ignored, _ := newIgnoreList("", nil)
+			registerDiscordHandlers(slog.Default(), dis_client, cmdhandler.RealClock{}, irc_client, spawnRoutes(), nil, newOutbox("IRC", 10), nil, ignored, ignored, nil, nil, newMessageCache(10), nil, nil, nil, "", 0, "", func() {})
+
+			dispatchMessage(dis_client, discord.Message{
+				ChannelID: SPAWN_CHAN_ID,
+				Author:    discord.User{Username: "alice"},
+				Content:   "hello irc",
+			})
+
+			select {
+			case e := <-sent:
+				if !enabled {
+					t.Errorf("relayed %q with the direction disabled", e.String())
+				} else if e.Command != "PRIVMSG" || e.Last() != "[DISCORD] alice: hello irc" {
+					t.Errorf("relayed %q, want the message to #spawn", e.String())
+				}
+			case <-time.After(200 * time.Millisecond):
+				if enabled {
+					t.Error("nothing relayed")
+				}
+			}
+		})
+
+		t.Run(fmt.Sprintf("irc to discord enabled=%v", enabled), func(t *testing.T) {
+			t.Setenv("SPAWNBOT_RELAY_IRC_TO_DISCORD", fmt.Sprint(enabled))
+
+			dis_client, created := fakeDiscord(t)
+			irc_client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "SpawnBot"})
+			cmdHandler, _ := cmdhandler.New("!")
+			ignored, _ := newIgnoreList("", nil)
+			registerIRCHandlers(slog.Default(), irc_client, cmdhandler.RealClock{}, cmdHandler, newDiscordSender(dis_client, cmdhandler.RealClock{}, 0, ""), spawnRoutes(), newOutbox("DISCORD", 10), nil, nil, ignored, nil, nil, newMessageCache(10), nil)
+
+			irc_client.RunHandlers(girc.ParseEvent(":alice!a@example.org PRIVMSG #spawn :hello discord"))
+
+			select {
+			case message := <-created:
+				if !enabled {
+					t.Errorf("relayed %q with the direction disabled", message.Content)
+				} else if message.channel != SPAWN_CHAN_ID || message.Content != "[IRC] alice: hello discord" {
+					t.Errorf("relayed %q to %d, want the message in #spawn", message.Content, message.channel)
+				}
+			case <-time.After(200 * time.Millisecond):
+				if enabled {
+					t.Error("nothing relayed")
+				}
+			}
+		})
+	}
+}
+
+func TestRelayLongUsername(t *testing.T) {
+	dis_client, _ := fakeDiscord(t)
+	fake := testutil.NewCommander(t)
+	ignored, _ := newIgnoreList("", nil)
+	registerDiscordHandlers(slog.Default(), dis_client, cmdhandler.RealClock{}, fake.Client, spawnRoutes(), nil, newOutbox("IRC", 10), nil, ignored, ignored, nil, nil, newMessageCache(10), nil, nil, nil, "", 0, "", func() {})
+
+	author := strings.Repeat("verylongusername", 2)
+	content := strings.Repeat("the quick brown fox jumps over the lazy dog ", 30)
+	dispatchMessage(dis_client, discord.Message{ChannelID: SPAWN_CHAN_ID, Author: discord.User{Username: author}, Content: content})
+
+	var relayed []string
+	for len(relayed) < 2 {
+		select {
+		case e := <-fake.Sent():
+			if e.Len() > fake.Client.MaxEventLength() {
+				t.Errorf("relayed a %d byte line, over %d: %q", e.Len(), fake.Client.MaxEventLength(), e.String())
+			}
+			if !strings.HasPrefix(e.Last(), "[DISCORD] "+author+": ") {
+				t.Errorf("relayed %q without the author prefix", e.Last())
+			}
+			relayed = append(relayed, e.Last())
+		case <-time.After(time.Second):
+			t.Fatalf("relayed %d lines, want the message split over several", len(relayed))
+		}
+	}
+}
+
+func TestPause(t *testing.T) {
+	dis_client, created := fakeDiscord(t)
+	fake := testutil.NewCommander(t)
+	irc_client, sent := fake.Client, fake.Sent()
+	cmdHandler, _ := cmdhandler.New("!")
+	ignored, _ := newIgnoreList("", nil)
+	hooks := &relay{}
+	registerDiscordHandlers(slog.Default(), dis_client, cmdhandler.RealClock{}, irc_client, spawnRoutes(), nil, newOutbox("IRC", 10), nil, ignored, ignored, nil, hooks, newMessageCache(10), nil, nil, nil, "", 0, "", func() {})
+	registerIRCHandlers(slog.Default(), irc_client, cmdhandler.RealClock{}, cmdHandler, newDiscordSender(dis_client, cmdhandler.RealClock{}, 0, ""), spawnRoutes(), newOutbox("DISCORD", 10), nil, nil, ignored, nil, hooks, newMessageCache(10), nil)
+
+	// relayed reports whether a message is relayed each way.
+	relayed := func() (to_irc, to_discord bool) {
+		t.Helper()
+
+		dispatchMessage(dis_client, discord.Message{ChannelID: SPAWN_CHAN_ID, Author: discord.User{Username: "alice"}, Content: "hello irc"})
+		irc_client.RunHandlers(girc.ParseEvent(":bob!b@example.org PRIVMSG #spawn :hello discord"))
+
+		for range 2 {
+			select {
+			case e := <-sent:
+				to_irc = to_irc || e.Last() == "[DISCORD] alice: hello irc"
+			case message := <-created:
+				to_discord = to_discord || message.Content == "[IRC] bob: hello discord"
+			case <-time.After(200 * time.Millisecond):
+			}
+		}
+
+		return to_irc, to_discord
+	}
+
+	hooks.paused.Store(true)
+	if to_irc, to_discord := relayed(); to_irc || to_discord {
+		t.Errorf("relayed while paused: to IRC %v, to Discord %v", to_irc, to_discord)
+	}
+
+	hooks.paused.Store(false)
+	if to_irc, to_discord := relayed(); !to_irc || !to_discord {
+		t.Errorf("not relayed after resuming: to IRC %v, to Discord %v", to_irc, to_discord)
+	}
+}
+
+func TestRelayHooks(t *testing.T) {
+	type call struct {
+		direction, from, content string
+		err                      error
+	}
+	calls := make(chan call, 10)
+	hooks := &relay{
+		OnRelay: func(direction string, from, content string) {
+			calls <- call{direction: direction, from: from, content: content}
+		},
+		OnRelayError: func(direction string, err error) {
+			calls <- call{direction: direction, err: err}
+		},
+	}
+
+	next := func() call {
+		t.Helper()
+		select {
+		case c := <-calls:
+			return c
+		case <-time.After(time.Second):
+			t.Fatal("no callback fired")
+			return call{}
+		}
+	}
+
+	dis_client, _ := fakeDiscord(t)
+	fake := testutil.NewCommander(t)
+	irc_client := fake.Client
+	ignored, _ := newIgnoreList("", nil)
+	registerDiscordHandlers(slog.Default(), dis_client, cmdhandler.RealClock{}, irc_client, spawnRoutes(), nil, newOutbox("IRC", 10), nil, ignored, ignored, nil, hooks, newMessageCache(10), nil, nil, nil, "", 0, "", func() {})
+	cmdHandler, _ := cmdhandler.New("!")
+	registerIRCHandlers(slog.Default(), irc_client, cmdhandler.RealClock{}, cmdHandler, newDiscordSender(dis_client, cmdhandler.RealClock{}, 0, ""), spawnRoutes(), newOutbox("DISCORD", 10), nil, nil, ignored, nil, hooks, newMessageCache(10), nil)
+
+	dispatchMessage(dis_client, discord.Message{ChannelID: SPAWN_CHAN_ID, Author: discord.User{Username: "alice"}, Content: "hello irc"})
+	if got, want := next(), (call{direction: discordToIRC, from: "alice", content: "[DISCORD] alice: hello irc"}); got != want {
+		t.Errorf("OnRelay got %+v, want %+v", got, want)
+	}
+
+	irc_client.RunHandlers(girc.ParseEvent(":bob!b@example.org PRIVMSG #spawn :hello discord"))
+	if got, want := next(), (call{direction: ircToDiscord, from: "bob", content: "hello discord"}); got != want {
+		t.Errorf("OnRelay got %+v, want %+v", got, want)
+	}
+
+	irc_client.RunHandlers(girc.ParseEvent(":bob!b@example.org PRIVMSG #spawn :" + failContent))
+	if got := next(); got.direction != ircToDiscord || got.err == nil || got.from != "" {
+		t.Errorf("OnRelayError got %+v, want an %s error", got, ircToDiscord)
+	}
+}
+
+func TestRelayDeletes(t *testing.T) {
+	t.Setenv("SPAWNBOT_RELAY_DELETES", "true")
+
+	dis_client, _ := fakeDiscord(t)
+	fake := testutil.NewCommander(t)
+	irc_client, sent := fake.Client, fake.Sent()
+	ignored, _ := newIgnoreList("", nil)
+	registerDiscordHandlers(slog.Default(), dis_client, cmdhandler.RealClock{}, irc_client, spawnRoutes(), nil, newOutbox("IRC", 10), nil, ignored, ignored, nil, nil, newMessageCache(10), nil, nil, nil, "", 0, "", func() {})
+
+	next := func() string {
+		t.Helper()
+		select {
+		case e := <-sent:
+			return e.Last()
+		case <-time.After(time.Second):
+			t.Fatal("nothing relayed")
+			return ""
+		}
+	}
+
+	dispatchMessage(dis_client, discord.Message{ID: 42, ChannelID: SPAWN_CHAN_ID, Author: discord.User{Username: "alice"}, Content: "oops"})
+	next()
+
+	deleted := func(id snowflake.ID) {
+		dis_client.EventManager().DispatchEvent(&events.MessageDelete{GenericMessage: &events.GenericMessage{
+			GenericEvent: events.NewGenericEvent(dis_client, 0, 0),
+			MessageID:    id,
+			ChannelID:    SPAWN_CHAN_ID,
+		}})
+	}
+
+	deleted(42)
+	if got, want := next(), "[DISCORD] alice deleted: oops"; got != want {
+		t.Errorf("cached delete relayed %q, want %q", got, want)
+	}
+
+	deleted(43)
+	if got, want := next(), "[DISCORD] a message was deleted"; got != want {
+		t.Errorf("uncached delete relayed %q, want %q", got, want)
+	}
+}
+
+func TestRelayAsNotice(t *testing.T) {
+	tests := []struct {
+		flag string
+		want string
+	}{
+		{"", girc.PRIVMSG},
+		{"false", girc.PRIVMSG},
+		{"true", girc.NOTICE},
+	}
+
+	for _, tt := range tests {
+		t.Run("flag="+tt.flag, func(t *testing.T) {
+			t.Setenv("SPAWNBOT_IRC_RELAY_AS_NOTICE", tt.flag)
+
+			dis_client, _ := fakeDiscord(t)
+			fake := testutil.NewCommander(t)
+			irc_client, sent := fake.Client, fake.Sent()
+			ignored, _ := newIgnoreList("", nil)
+			registerDiscordHandlers(slog.Default(), dis_client, cmdhandler.RealClock{}, irc_client, spawnRoutes(), nil, newOutbox("IRC", 10), nil, ignored, ignored, nil, nil, newMessageCache(10), nil, nil, nil, "", 0, "", func() {})
+
+			dispatchMessage(dis_client, discord.Message{ChannelID: SPAWN_CHAN_ID, Author: discord.User{Username: "alice"}, Content: "hi"})
+
+			select {
+			case e := <-sent:
+				if e.Command != tt.want || e.Params[0] != "#spawn" {
+					t.Errorf("relayed %q, want a %s to #spawn", e.String(), tt.want)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("nothing relayed")
+			}
+		})
+	}
+}
+
+func TestAttachmentLines(t *testing.T) {
+	attachments := func(types ...string) []discord.Attachment {
+		var out []discord.Attachment
+		for i, content_type := range types {
+			attachment := discord.Attachment{URL: fmt.Sprintf("https://cdn.example.com/%d", i)}
+			if content_type != "" {
+				attachment.ContentType = &content_type
+			}
+			out = append(out, attachment)
+		}
+
+		return out
+	}
+
+	tests := []struct {
+		name        string
+		attachments []discord.Attachment
+		max         int
+		want        []string
+	}{
+		{"none", nil, 3, nil},
+		{"under", attachments("image/png", ""), 3, []string{"https://cdn.example.com/0", "https://cdn.example.com/1"}},
+		{"at", attachments("image/png", "image/gif", "text/plain"), 3, []string{"https://cdn.example.com/0", "https://cdn.example.com/1", "https://cdn.example.com/2"}},
+		{"over", attachments("image/png", "image/gif", "image/jpeg", "text/plain"), 3, []string{"3 images + 1 file, see Discord"}},
+		{"only files", attachments("", "application/pdf", "text/plain", "video/mp4"), 3, []string{"4 files, see Discord"}},
+		{"only images", attachments("image/png", "image/png"), 1, []string{"2 images, see Discord"}},
+		{"always summed", attachments("image/png"), 0, []string{"1 image, see Discord"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := attachmentLines(tt.attachments, tt.max); !slices.Equal(got, tt.want) {
+				t.Errorf("attachmentLines(%d attachments, %d) = %q, want %q", len(tt.attachments), tt.max, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelayAttachments(t *testing.T) {
+	t.Setenv("SPAWNBOT_MAX_ATTACHMENT_URLS", "1")
+
+	dis_client, _ := fakeDiscord(t)
+	fake := testutil.NewCommander(t)
+	ignored, _ := newIgnoreList("", nil)
+	registerDiscordHandlers(slog.Default(), dis_client, cmdhandler.RealClock{}, fake.Client, spawnRoutes(), nil, newOutbox("IRC", 10), nil, ignored, ignored, nil, nil, newMessageCache(10), nil, nil, nil, "", 0, "", func() {})
+
+	dispatchMessage(dis_client, discord.Message{ChannelID: SPAWN_CHAN_ID, Author: discord.User{Username: "alice"}, Content: "logs", Attachments: []discord.Attachment{{URL: "https://cdn.example.com/a"}, {URL: "https://cdn.example.com/b"}}})
+
+	for _, want := range []string{"[DISCORD] alice: logs", "[DISCORD] alice: 2 files, see Discord"} {
+		select {
+		case e := <-fake.Sent():
+			if e.Last() != want {
+				t.Errorf("relayed %q, want %q", e.Last(), want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("nothing relayed, want %q", want)
+		}
+	}
+}
+
+func TestMentionsUser(t *testing.T) {
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{"<@123> hi", true},
+		{"hey <@!123>", true},
+		{"<@1234> hi", false},
+		{"<@&123> is a role", false},
+		{"123", false},
+	}
+
+	for _, tt := range tests {
+		if got := mentionsUser(tt.content, 123); got != tt.want {
+			t.Errorf("mentionsUser(%q, 123) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestMentionReply(t *testing.T) {
+	t.Setenv("SPAWNBOT_MENTION_REPLY", "Hi! I bridge this channel to IRC. Try !help.")
+	t.Setenv("SPAWNBOT_MENTION_REPLY_INTERVAL", "1m")
+
+	dis_client, created := fakeDiscord(t)
+	fake := testutil.NewCommander(t)
+	clock := testutil.NewClock()
+	ignored, _ := newIgnoreList("", nil)
+	registerDiscordHandlers(slog.Default(), dis_client, clock, fake.Client, spawnRoutes(), nil, newOutbox("IRC", 10), nil, ignored, ignored, nil, nil, newMessageCache(10), nil, nil, nil, "", 0, "", func() {})
+	// As learnt from the gateway's READY.
+	dis_client.Caches().SetSelfUser(discord.OAuth2User{User: discord.User{ID: 123}})
+
+	mention := func(id snowflake.ID, content string) {
+		dispatchMessage(dis_client, discord.Message{ID: id, ChannelID: SPAWN_CHAN_ID, Author: discord.User{ID: 7, Username: "alice"}, Content: content})
+	}
+
+	replied := func() (posted, bool) {
+		select {
+		case message := <-created:
+			return message, true
+		case <-time.After(100 * time.Millisecond):
+			return posted{}, false
+		}
+	}
+
+	mention(100, "hello <@123>")
+	if message, ok := replied(); !ok || message.Content != "Hi! I bridge this channel to IRC. Try !help." || message.MessageReference == nil {
+		t.Errorf("replied %q, want the mention reply", message.Content)
+	}
+
+	mention(101, "hello <@456>")
+	if message, ok := replied(); ok {
+		t.Errorf("replied %q to a mention of someone else", message.Content)
+	}
+
+	mention(102, "<@123> again")
+	if message, ok := replied(); ok {
+		t.Errorf("replied %q again within the interval", message.Content)
+	}
+
+	clock.Advance(time.Minute)
+	mention(103, "<@!123> later")
+	if _, ok := replied(); !ok {
+		t.Error("no reply once the interval passed")
+	}
+}
+
+func TestSplitContent(t *testing.T) {
+	tests := []struct {
+		content string
+		max     int
+		want    []string
+	}{
+		{"short", 10, []string{"short"}},
+		{"hello world again", 11, []string{"hello world", "again"}},
+		{"hello world again", 10, []string{"hello worl", "d again"}},
+		{"hello world again", 8, []string{"hello", "world", "again"}},
+		// Without a space in the second half of a chunk, it's cut at max.
+		{"abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"héllo wörld", 7, []string{"héllo", "wörld"}},
+	}
+
+	for _, tt := range tests {
+		if got := splitContent(tt.content, tt.max); !slices.Equal(got, tt.want) {
+			t.Errorf("splitContent(%q, %d) = %q, want %q", tt.content, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestDiscordMessage(t *testing.T) {
+	tests := []struct {
+		name           string
+		content        string
+		mentioned      []snowflake.ID
+		allow_everyone bool
+		want           string
+		wantParse      []discord.AllowedMentionType
+	}{
+		{"everyone from IRC", "[IRC] bob: @everyone look", nil, false, "[IRC] bob: @\u200beveryone look", []discord.AllowedMentionType{}},
+		{"here from IRC", "@here", nil, false, "@\u200bhere", []discord.AllowedMentionType{}},
+		{"email address", "mail bob@everyone.example", nil, false, "mail bob@\u200beveryone.example", []discord.AllowedMentionType{}},
+		{"everyone allowed", "@everyone", nil, true, "@everyone", []discord.AllowedMentionType{discord.AllowedMentionTypeEveryone}},
+		{"mapped nick", "<@42> hi", []snowflake.ID{42}, false, "<@42> hi", []discord.AllowedMentionType{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			create := discordMessage(tt.content, tt.mentioned, tt.allow_everyone).Build()
+			if create.Content != tt.want {
+				t.Errorf("content = %q, want %q", create.Content, tt.want)
+			}
+
+			allowed := create.AllowedMentions
+			if allowed == nil {
+				t.Fatal("allowed mentions not set, so Discord would parse every mention")
+			}
+			if !slices.Equal(allowed.Parse, tt.wantParse) {
+				t.Errorf("parse = %v, want %v", allowed.Parse, tt.wantParse)
+			}
+			if len(allowed.Roles) != 0 {
+				t.Errorf("roles = %v, want none", allowed.Roles)
+			}
+			if !slices.Equal(allowed.Users, append([]snowflake.ID{}, tt.mentioned...)) {
+				t.Errorf("users = %v, want %v", allowed.Users, tt.mentioned)
+			}
+		})
+	}
+}
+
+func TestBridgeOptOut(t *testing.T) {
+	dis_client, created := fakeDiscord(t)
+	fake := testutil.NewCommander(t)
+	ignored, _ := newIgnoreList("", nil)
+	path := filepath.Join(t.TempDir(), "optout.json")
+	opted_out, _ := newIgnoreList(path, nil)
+	registerDiscordHandlers(slog.Default(), dis_client, cmdhandler.RealClock{}, fake.Client, spawnRoutes(), nil, newOutbox("IRC", 10), nil, ignored, opted_out, nil, nil, newMessageCache(10), nil, nil, nil, "", 0, "", func() {})
+
+	alice := discord.User{ID: 7, Username: "alice"}
+	say := func(content string) {
+		dispatchMessage(dis_client, discord.Message{ID: 100, ChannelID: SPAWN_CHAN_ID, Author: alice, Content: content})
+	}
+	expectReply := func(want string) {
+		t.Helper()
+
+		select {
+		case message := <-created:
+			if message.Content != want || message.MessageReference == nil || *message.MessageReference.MessageID != 100 {
+				t.Errorf("replied %q, want %q in reply to the command", message.Content, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no reply, want %q", want)
+		}
+	}
+	expectRelayed := func(want bool) {
+		t.Helper()
+
+		select {
+		case e := <-fake.Sent():
+			if !want {
+				t.Errorf("relayed %q while opted out", e.Last())
+			}
+		case <-time.After(200 * time.Millisecond):
+			if want {
+				t.Error("nothing relayed after opting in")
+			}
+		}
+	}
+
+	say("!bridge optout")
+	expectReply("your messages will no longer be bridged to IRC")
+	say("secret")
+	expectRelayed(false)
+
+	say("!bridge optout")
+	expectReply("your messages already aren't bridged to IRC")
+
+	// The preference is persisted by ID.
+	if reloaded, err := newIgnoreList(path, nil); err != nil || !reloaded.has("7") {
+		t.Errorf("opt-out not persisted by user ID: %v", err)
+	}
+
+	say("!bridge optin")
+	expectReply("your messages will be bridged to IRC again")
+	say("hello again")
+	expectRelayed(true)
+}
+
+func TestRelayReaction(t *testing.T) {
+	t.Setenv("SPAWNBOT_RELAY_REACTIONS", "true")
+
+	dis_client, _ := fakeDiscord(t)
+	fake := testutil.NewCommander(t)
+	irc_client, sent := fake.Client, fake.Sent()
+	ignored, _ := newIgnoreList("", nil)
+	cache := newMessageCache(10)
+	registerDiscordHandlers(slog.Default(), dis_client, cmdhandler.RealClock{}, irc_client, spawnRoutes(), nil, newOutbox("IRC", 10), nil, ignored, ignored, nil, nil, cache, nil, nil, nil, "", 0, "", func() {})
+
+	// bob's message was relayed to Discord as message 42.
+	cache.add("42", "bob", "anyone around?")
+
+	react := func(message snowflake.ID, emoji discord.PartialEmoji) {
+		dis_client.EventManager().DispatchEvent(&events.GuildMessageReactionAdd{
+			GenericGuildMessageReaction: &events.GenericGuildMessageReaction{
+				GenericEvent: events.NewGenericEvent(dis_client, 0, 0),
+				UserID:       7,
+				ChannelID:    SPAWN_CHAN_ID,
+				MessageID:    message,
+				Emoji:        emoji,
+			},
+			Member: discord.Member{User: discord.User{ID: 7, Username: "alice"}},
+		})
+	}
+
+	thumbs, wave := "👍", "wave"
+	var custom snowflake.ID = 99
+	tests := []struct {
+		emoji discord.PartialEmoji
+		want  string
+	}{
+		{discord.PartialEmoji{Name: &thumbs}, "[DISCORD] alice reacted 👍 to bob's message"},
+		{discord.PartialEmoji{ID: &custom, Name: &wave}, "[DISCORD] alice reacted :wave: to bob's message"},
+	}
+
+	for _, tt := range tests {
+		react(42, tt.emoji)
+
+		select {
+		case e := <-sent:
+			if e.Command != girc.PRIVMSG || e.Params[0] != "#spawn" || e.Last() != tt.want {
+				t.Errorf("relayed %q, want %q", e.String(), tt.want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("reaction not relayed, want %q", tt.want)
+		}
+	}
+
+	// Reactions to messages which weren't bridged aren't relayed.
+	react(43, discord.PartialEmoji{Name: &thumbs})
+
+	select {
+	case e := <-sent:
+		t.Errorf("relayed %q for a message not in the cache", e.String())
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestHasAdminRole(t *testing.T) {
+	admins := []snowflake.ID{10, 20}
+
+	tests := []struct {
+		name   string
+		member *discord.Member
+		roles  []snowflake.ID
+		want   bool
+	}{
+		{"holds a role", &discord.Member{RoleIDs: []snowflake.ID{5, 20}}, admins, true},
+		{"other roles", &discord.Member{RoleIDs: []snowflake.ID{5}}, admins, false},
+		{"no roles", &discord.Member{}, admins, false},
+		{"no member", nil, admins, false},
+		{"none configured", &discord.Member{RoleIDs: []snowflake.ID{10}}, nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := hasAdminRole(tt.member, tt.roles); got != tt.want {
+			t.Errorf("%s: hasAdminRole() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDieAdminRoles(t *testing.T) {
+	tests := []struct {
+		name   string
+		roles  []snowflake.ID
+		member *discord.Member
+		want   bool
+	}{
+		{"admin", []snowflake.ID{10}, &discord.Member{RoleIDs: []snowflake.ID{10}}, true},
+		{"not admin", []snowflake.ID{10}, &discord.Member{RoleIDs: []snowflake.ID{5}}, false},
+		{"none configured", nil, &discord.Member{RoleIDs: []snowflake.ID{10}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dis_client, _ := fakeDiscord(t)
+			fake := testutil.NewCommander(t)
+			ignored, _ := newIgnoreList("", nil)
+
+			var died bool
+			registerDiscordHandlers(slog.Default(), dis_client, cmdhandler.RealClock{}, fake.Client, spawnRoutes(), nil, newOutbox("IRC", 10), nil, ignored, ignored, nil, nil, newMessageCache(10), tt.roles, nil, nil, "", 0, "", func() { died = true })
+
+			dispatchMessage(dis_client, discord.Message{ChannelID: SPAWN_CHAN_ID, Author: discord.User{Username: "alice"}, Member: tt.member, Content: "!die"})
+
+			if died != tt.want {
+				t.Errorf("died = %v, want %v", died, tt.want)
+			}
+		})
+	}
+}
+
+// countingClient counts the listeners added to the client it wraps.
+type countingClient struct {
+	bot.Client
+	listeners int
+}
+
+func (c *countingClient) AddEventListeners(listeners ...bot.EventListener) {
+	c.listeners += len(listeners)
+	c.Client.AddEventListeners(listeners...)
+}
+
+func TestResumeListeners(t *testing.T) {
+	t.Setenv("QNET_AUTH", "")
+	t.Setenv("SPAWNBOT_IRC_CHANNELS", "")
+
+	dis_client, _ := fakeDiscord(t)
+	counting := &countingClient{Client: dis_client}
+	fake := testutil.NewCommander(t)
+	ignored, _ := newIgnoreList("", nil)
+	registerDiscordHandlers(slog.Default(), counting, cmdhandler.RealClock{}, fake.Client, spawnRoutes(), nil, newOutbox("IRC", 10), nil, ignored, ignored, nil, nil, newMessageCache(10), nil, nil, nil, "", 0, "", func() {})
+
+	irc_client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "SpawnBot"})
+	cmdHandler, _ := cmdhandler.New("!")
+	registerIRCHandlers(slog.Default(), irc_client, cmdhandler.RealClock{}, cmdHandler, newDiscordSender(dis_client, cmdhandler.RealClock{}, 0, ""), spawnRoutes(), newOutbox("DISCORD", 10), nil, nil, ignored, nil, nil, newMessageCache(10), nil)
+
+	listeners, handlers := counting.listeners, irc_client.Handlers.Len()
+
+	message := discord.Message{ID: 100, ChannelID: SPAWN_CHAN_ID, Author: discord.User{Username: "alice"}, Content: "hello irc"}
+	dispatchMessage(dis_client, message)
+
+	// A blip on both sides: Discord resumes, replaying the message, and IRC
+	// reconnects.
+	dis_client.EventManager().DispatchEvent(&events.Resumed{GenericEvent: events.NewGenericEvent(dis_client, 0, 0)})
+	dispatchMessage(dis_client, message)
+	irc_client.RunHandlers(&girc.Event{Command: girc.DISCONNECTED})
+	irc_client.RunHandlers(&girc.Event{Command: girc.CONNECTED})
+
+	if counting.listeners != listeners || irc_client.Handlers.Len() != handlers {
+		t.Errorf("listeners went from %d Discord, %d IRC to %d, %d across a reconnect", listeners, handlers, counting.listeners, irc_client.Handlers.Len())
+	}
+
+	select {
+	case <-fake.Sent():
+	case <-time.After(time.Second):
+		t.Fatal("message not relayed")
+	}
+
+	select {
+	case e := <-fake.Sent():
+		t.Errorf("relayed %q again after resuming", e.Last())
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestLookupDiscordCommand(t *testing.T) {
+	commands := map[string]discordCommand{
+		"bridge optout": {},
+		"whois":         {args: true},
+		"source":        {},
+	}
+
+	tests := []struct {
+		prefix  string
+		content string
+		name    string
+		args    string
+		found   bool
+	}{
+		{"!", "!bridge optout", "bridge optout", "", true},
+		{"!", "!whois alice", "whois", "alice", true},
+		{"!", "!whois  alice ", "whois", "alice", true},
+		{"!", "!whois", "whois", "", true},
+		{"!", "!source please", "", "", false},
+		{"!", "source", "", "", false},
+		{"!", "!bridge", "", "", false},
+		{"!", "!", "", "", false},
+		// Only the configured prefix runs commands.
+		{".", ".source", "source", "", true},
+		{".", ".whois alice", "whois", "alice", true},
+		{".", "!source", "", "", false},
+		{"!", ".source", "", "", false},
+		{"//", "//source", "source", "", true},
+		{"//", "/source", "", "", false},
+	}
+
+	for _, tt := range tests {
+		name, _, args, found := lookupDiscordCommand(commands, tt.prefix, tt.content)
+		if name != tt.name || args != tt.args || found != tt.found {
+			t.Errorf("lookupDiscordCommand(%q, %q) = %q, %q, %v, want %q, %q, %v", tt.prefix, tt.content, name, args, found, tt.name, tt.args, tt.found)
+		}
+	}
+}
+
+func TestDiscordPrefix(t *testing.T) {
+	t.Setenv("SPAWNBOT_DISCORD_PREFIX", ".")
+
+	dis_client, created := fakeDiscord(t)
+	fake := testutil.NewCommander(t)
+	ignored, _ := newIgnoreList("", nil)
+	registerDiscordHandlers(slog.Default(), dis_client, cmdhandler.RealClock{}, fake.Client, spawnRoutes(), nil, newOutbox("IRC", 10), nil, ignored, ignored, nil, nil, newMessageCache(10), nil, nil, nil, "", 0, "", func() {})
+
+	// IRC's prefix is just text on Discord, so relayed like any other.
+	dispatchMessage(dis_client, discord.Message{ID: 1, ChannelID: SPAWN_CHAN_ID, Author: discord.User{Username: "alice"}, Content: "!source"})
+	select {
+	case e := <-fake.Sent():
+		if e.Last() != "[DISCORD] alice: !source" {
+			t.Errorf("relayed %q, want !source as is", e.Last())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("!source wasn't relayed")
+	}
+
+	dispatchMessage(dis_client, discord.Message{ID: 2, ChannelID: SPAWN_CHAN_ID, Author: discord.User{Username: "alice"}, Content: ".source"})
+	select {
+	case <-created:
+	case <-time.After(time.Second):
+		t.Fatal("no reply to .source")
+	}
+}
+
+func TestDiscordCommandChannels(t *testing.T) {
+	const other = snowflake.ID(1234)
+
+	tests := []struct {
+		name    string
+		channel snowflake.ID
+		want    bool
+	}{
+		{"allowed", SPAWN_CHAN_ID, true},
+		{"denied", other, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dis_client, _ := fakeDiscord(t)
+			fake := testutil.NewCommander(t)
+			ignored, _ := newIgnoreList("", nil)
+			routes := spawnRoutes()
+			routes.add("#other", other)
+
+			var died bool
+			registerDiscordHandlers(slog.Default(), dis_client, cmdhandler.RealClock{}, fake.Client, routes, nil, newOutbox("IRC", 10), nil, ignored, ignored, nil, nil, newMessageCache(10), []snowflake.ID{10}, nil, nil, "", 0, "", func() { died = true })
+
+			admin := &discord.Member{RoleIDs: []snowflake.ID{10}}
+			dispatchMessage(dis_client, discord.Message{ID: 100, ChannelID: tt.channel, Author: discord.User{Username: "alice"}, Member: admin, Content: "!die"})
+
+			if died != tt.want {
+				t.Errorf("died = %v, want %v", died, tt.want)
+			}
+
+			// Where the command isn't allowed, it's relayed like any message.
+			select {
+			case e := <-fake.Sent():
+				if tt.want || e.Last() != "[DISCORD] alice: !die" {
+					t.Errorf("relayed %q", e.String())
+				}
+			case <-time.After(200 * time.Millisecond):
+				if !tt.want {
+					t.Error("denied command not relayed")
+				}
+			}
+		})
+	}
+}
+
+func TestDiscordCommandAllowedIn(t *testing.T) {
+	const bridged, other = 1, 2
+	commands := map[string]discordCommand{
+		"die":    {channels: []snowflake.ID{bridged}},
+		"source": {},
+		"id":     {anywhere: true},
+	}
+
+	tests := []struct {
+		content string
+		channel snowflake.ID
+		bridged bool
+		want    bool
+	}{
+		{"!id", other, false, true},
+		{"id", other, false, false},
+		{"id", bridged, true, false},
+		{"!source", bridged, true, true},
+		{"!source", other, false, false},
+		{"!die", bridged, true, true},
+		{"!die", other, true, false},
+	}
+
+	for _, tt := range tests {
+		_, cmd, _, found := lookupDiscordCommand(commands, "!", tt.content)
+		if got := found && cmd.allowedIn(tt.channel, tt.bridged); got != tt.want {
+			t.Errorf("%q in %d (bridged %v) runs = %v, want %v", tt.content, tt.channel, tt.bridged, got, tt.want)
+		}
+	}
+}
+
+func TestChannelIDs(t *testing.T) {
+	dis_client, created := fakeDiscord(t)
+	fake := testutil.NewCommander(t)
+	ignored, _ := newIgnoreList("", nil)
+	registerDiscordHandlers(slog.Default(), dis_client, cmdhandler.RealClock{}, fake.Client, spawnRoutes(), nil, newOutbox("IRC", 10), nil, ignored, ignored, nil, nil, newMessageCache(10), nil, nil, nil, "", 0, "", func() {})
+
+	// "!id" works in channels which aren't bridged yet.
+	guild := snowflake.ID(42)
+	dispatchMessage(dis_client, discord.Message{ID: 100, ChannelID: 1234, GuildID: &guild, Author: discord.User{Username: "alice"}, Content: "!id"})
+
+	select {
+	case message := <-created:
+		if want := "channel ID: 1234, guild ID: 42"; message.channel != 1234 || message.Content != want {
+			t.Errorf("replied %q in %d, want %q in 1234", message.Content, message.channel, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reply to !id")
+	}
+
+	// Without the prefix, it's just chat.
+	dispatchMessage(dis_client, discord.Message{ID: 101, ChannelID: 1234, GuildID: &guild, Author: discord.User{Username: "alice"}, Content: "id"})
+
+	select {
+	case message := <-created:
+		t.Errorf("replied %q to the bare word", message.Content)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPinNotice(t *testing.T) {
+	long := strings.Repeat("a", maxPinPreview+10)
+
+	tests := []struct {
+		author, content string
+		want            string
+	}{
+		{"alice", "see the FAQ", "* a message by alice was pinned: see the FAQ"},
+		{"alice", "  first line\nsecond line", "* a message by alice was pinned: first line"},
+		{"alice", "", "* a message by alice was pinned"},
+		{"alice", long, "* a message by alice was pinned: " + long[:maxPinPreview] + "…"},
+		// Opted-out and ignored authors are passed as empty.
+		{"", "secret", "* a message was pinned"},
+	}
+
+	for _, tt := range tests {
+		if got := pinNotice(tt.author, tt.content); got != tt.want {
+			t.Errorf("pinNotice(%q, %q) = %q, want %q", tt.author, tt.content, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"spawnbot/cmdhandler"
+	"sync/atomic"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// discordSender creates messages on Discord for everything relayed from IRC,
+// retrying transient REST failures and marking each as relayed (see
+// relayMarker) so it isn't relayed back. It's disabled once the gateway closes for
+// good, turning the relays to Discord off.
+type discordSender struct {
+	dis_client bot.Client
+	clock      cmdhandler.Clock
+	retries    int
+	marker     string
+	disabled   atomic.Bool
+}
+
+// errDiscordDisabled is returned by send once Discord is disabled.
+var errDiscordDisabled = errors.New("discord is disabled")
+
+// newDiscordSender returns a sender which retries each message up to retries
+// times, waiting by clock between attempts, and appends marker to each.
+func newDiscordSender(dis_client bot.Client, clock cmdhandler.Clock, retries int, marker string) *discordSender {
+	return &discordSender{dis_client: dis_client, clock: clock, retries: retries, marker: marker}
+}
+
+// disable stops s sending anything more, for when the gateway has closed for
+// good and the bot carries on IRC-only.
+func (s *discordSender) disable() {
+	s.disabled.Store(true)
+}
+
+// enabled reports whether anything should be relayed through s, which may be
+// nil when running IRC-only.
+func (s *discordSender) enabled() bool {
+	return s != nil && !s.disabled.Load()
+}
+
+// send creates create in channel, marked as relayed, returning the message
+// sent.
+func (s *discordSender) send(channel snowflake.ID, create discord.MessageCreate) (sent *discord.Message, err error) {
+	if !s.enabled() {
+		return nil, errDiscordDisabled
+	}
+
+	create.Content = markRelayed(create.Content, s.marker)
+	err = withRetry(s.clock, s.retries, time.Second, func() error {
+		sent, err = s.dis_client.Rest().CreateMessage(channel, create)
+		return err
+	})
+
+	return sent, err
+}
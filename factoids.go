@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/lrstanley/girc"
+)
+
+// factoidKey is the format of a factoid key, as for a command name.
+var factoidKey = regexp.MustCompile(`^[a-z0-9-_]{1,20}$`)
+
+// factoidList is the key/value store behind !learn and !forget, whose values
+// are recalled by any message containing the command prefix and key, e.g.
+// "!<key>". It is optionally persisted to a JSON file so factoids survive a
+// restart.
+type factoidList struct {
+	path   string // empty disables persistence
+	prefix string
+
+	mu    sync.Mutex
+	facts map[string]string
+}
+
+// newFactoidList returns a factoid list recalled with prefix, loading any
+// factoids previously saved to path.
+func newFactoidList(path, prefix string) (*factoidList, error) {
+	l := &factoidList{path: path, prefix: prefix, facts: make(map[string]string)}
+	if path == "" {
+		return l, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return l, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &l.facts); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// learn stores value under key, replacing any existing value.
+func (l *factoidList) learn(key, value string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.facts[strings.ToLower(key)] = value
+	return l.save()
+}
+
+// forget removes key, returning false if it wasn't known.
+func (l *factoidList) forget(key string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key = strings.ToLower(key)
+	if _, ok := l.facts[key]; !ok {
+		return false, nil
+	}
+
+	delete(l.facts, key)
+	return true, l.save()
+}
+
+// recall returns the value of the first "<prefix><key>" in text which is a
+// known factoid.
+func (l *factoidList) recall(text string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, word := range strings.Fields(text) {
+		key, ok := strings.CutPrefix(word, l.prefix)
+		if !ok {
+			continue
+		}
+
+		if value, ok := l.facts[strings.ToLower(key)]; ok {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// responder returns a PRIVMSG handler replying with the factoid recalled by
+// each message, as often as limiter allows.
+func (l *factoidList) responder(limiter *relayLimiter) func(*girc.Client, girc.Event) {
+	return func(c *girc.Client, e girc.Event) {
+		value, ok := l.recall(e.Last())
+		if !ok {
+			return
+		}
+
+		if ok, _ := limiter.allow(); ok {
+			c.Cmd.Reply(e, sanitizeIRC(value))
+		}
+	}
+}
+
+// save persists the factoids to l.path, if set. l.mu must be held by the
+// caller.
+func (l *factoidList) save() error {
+	if l.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(l.facts, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(l.path, data, 0o644)
+}
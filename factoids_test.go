@@ -0,0 +1,119 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"spawnbot/internal/testutil"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+)
+
+func TestFactoids(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "factoids.json")
+	l, err := newFactoidList(path, "!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = l.learn("FAQ", "read the FAQ"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text string
+		want string
+		ok   bool
+	}{
+		{"see !faq", "read the FAQ", true},
+		{"!FAQ please", "read the FAQ", true},
+		{"faq", "", false},
+		{"!unknown", "", false},
+	}
+
+	for _, tt := range tests {
+		if got, ok := l.recall(tt.text); got != tt.want || ok != tt.ok {
+			t.Errorf("recall(%q) = %q, %v, want %q, %v", tt.text, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	// Factoids survive a restart, and no temporary files are left behind.
+	reloaded, err := newFactoidList(path, "!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := reloaded.recall("!faq"); !ok || got != "read the FAQ" {
+		t.Errorf("reloaded recall(!faq) = %q, %v, want the FAQ", got, ok)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("factoid directory holds %d files, want just the database", len(entries))
+	}
+
+	if forgot, err := l.forget("faq"); !forgot || err != nil {
+		t.Errorf("forget(faq) = %v, %v, want true", forgot, err)
+	}
+	if forgot, err := l.forget("faq"); forgot || err != nil {
+		t.Errorf("second forget(faq) = %v, %v, want false", forgot, err)
+	}
+	if got, ok := l.recall("!faq"); ok {
+		t.Errorf("recall(!faq) after forget = %q", got)
+	}
+}
+
+func TestFactoidPrefix(t *testing.T) {
+	l, err := newFactoidList("", ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.learn("faq", "read the FAQ"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recalled with the configured prefix only.
+	if got, ok := l.recall("see .faq"); !ok || got != "read the FAQ" {
+		t.Errorf("recall(.faq) = %q, %v, want the FAQ", got, ok)
+	}
+	if got, ok := l.recall("see !faq"); ok {
+		t.Errorf("recall(!faq) with prefix . = %q, want nothing", got)
+	}
+}
+
+func TestFactoidRateLimit(t *testing.T) {
+	l, err := newFactoidList("", "!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.learn("faq", "read the FAQ"); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := testutil.NewCommander(t)
+	clk := testutil.NewClock()
+	respond := l.responder(newRelayLimiter(clk, 3, 30*time.Second))
+	for range 5 {
+		respond(fake.Client, *girc.ParseEvent(":alice!a@example.org PRIVMSG #spawn :!faq"))
+	}
+	// Messages without a factoid are ignored.
+	respond(fake.Client, *girc.ParseEvent(":alice!a@example.org PRIVMSG #spawn :hello"))
+
+	// Once the window has passed, factoids are answered again.
+	clk.Advance(30 * time.Second)
+	respond(fake.Client, *girc.ParseEvent(":alice!a@example.org PRIVMSG #spawn :!faq"))
+
+	var replies int
+	for {
+		select {
+		case e := <-fake.Sent():
+			if e.Params[0] != "#spawn" || e.Last() != "read the FAQ" {
+				t.Errorf("replied %q, want the FAQ in #spawn", e.String())
+			}
+			replies++
+		case <-time.After(200 * time.Millisecond):
+			if replies != 4 {
+				t.Errorf("6 triggers got %d replies, want 4", replies)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// forumThreads tracks the threads (posts) of a Discord forum channel, all of
+// which are relayed into a single IRC channel, prefixed with the thread name.
+type forumThreads struct {
+	dis_client bot.Client
+	forum      snowflake.ID
+	channel    string // IRC channel the forum's threads relay into
+
+	mu    sync.Mutex
+	names map[snowflake.ID]string // thread -> name, or "" if not in the forum
+}
+
+func newForumThreads(dis_client bot.Client, forum snowflake.ID, channel string) *forumThreads {
+	return &forumThreads{dis_client: dis_client, forum: forum, channel: channel, names: make(map[snowflake.ID]string)}
+}
+
+// add records thread if it belongs to the forum, e.g. on ThreadCreate or
+// ThreadUpdate, so a renamed thread is relayed under its new name.
+func (f *forumThreads) add(thread discord.GuildThread) {
+	if parent := thread.ParentID(); parent == nil || *parent != f.forum {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.names[thread.ID()] = thread.Name()
+}
+
+// thread returns the name of the forum thread with id, and whether id is a
+// thread of the forum at all. Threads created before the bot started are
+// looked up over REST the first time they're seen.
+func (f *forumThreads) thread(id snowflake.ID) (string, bool) {
+	f.mu.Lock()
+	name, ok := f.names[id]
+	f.mu.Unlock()
+
+	if ok {
+		return name, name != ""
+	}
+
+	channel, err := f.dis_client.Rest().GetChannel(id)
+	if err != nil {
+		slog.Error("[DISCORD] Unable to look up channel", slog.String("channel", id.String()), slog.Any("err", err))
+		return "", false
+	}
+
+	if thread, ok := channel.(discord.GuildThread); ok {
+		if parent := thread.ParentID(); parent != nil && *parent == f.forum {
+			name = thread.Name()
+		}
+	}
+
+	f.mu.Lock()
+	f.names[id] = name
+	f.mu.Unlock()
+
+	return name, name != ""
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"spawnbot/cmdhandler"
+	"spawnbot/internal/testutil"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+	"github.com/lrstanley/girc"
+)
+
+func TestForumRelay(t *testing.T) {
+	dis_client, _ := fakeDiscord(t)
+	fake := testutil.NewCommander(t)
+	irc_client, sent := fake.Client, fake.Sent()
+	ignored, _ := newIgnoreList("", nil)
+	forum := newForumThreads(dis_client, 500, "#support")
+	registerDiscordHandlers(slog.Default(), dis_client, cmdhandler.RealClock{}, irc_client, spawnRoutes(), forum, newOutbox("IRC", 10), nil, ignored, ignored, nil, nil, newMessageCache(10), nil, nil, nil, "", 0, "", func() {})
+
+	var thread discord.GuildThread
+	if err := json.Unmarshal([]byte(`{"id":"600","type":11,"guild_id":"1","name":"Install fails","parent_id":"500"}`), &thread); err != nil {
+		t.Fatal(err)
+	}
+	dis_client.EventManager().DispatchEvent(&events.ThreadCreate{GenericThread: &events.GenericThread{
+		GenericEvent: events.NewGenericEvent(dis_client, 0, 0),
+		Thread:       thread,
+		ThreadID:     thread.ID(),
+		ParentID:     500,
+	}})
+
+	dispatchMessage(dis_client, discord.Message{ChannelID: 600, Author: discord.User{Username: "alice"}, Content: "it says no"})
+
+	select {
+	case e := <-sent:
+		if e.Command != girc.PRIVMSG || e.Params[0] != "#support" || e.Last() != "[DISCORD] [Install fails] alice: it says no" {
+			t.Errorf("relayed %q, want the thread-prefixed post in #support", e.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("forum post not relayed")
+	}
+
+	// Channels which aren't threads of the forum (here, unknown to the API)
+	// aren't relayed.
+	dispatchMessage(dis_client, discord.Message{ChannelID: 700, Author: discord.User{Username: "alice"}, Content: "elsewhere"})
+
+	select {
+	case e := <-sent:
+		t.Errorf("relayed %q from outside the forum", e.String())
+	case <-time.After(200 * time.Millisecond):
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/disgoorg/disgo/gateway"
+	"github.com/gorilla/websocket"
+)
+
+// gatewayCloseHints say what to do about each fatal gateway close code, as
+// retrying won't help with any of them.
+var gatewayCloseHints = map[int]string{
+	gateway.CloseEventCodeAuthenticationFailed.Code: "check SPAWNBOT_TOKEN, Discord rejected the bot token",
+	gateway.CloseEventCodeInvalidShard.Code:         "check the shard configuration",
+	gateway.CloseEventCodeShardingRequired.Code:     "the bot is in too many guilds to run without sharding",
+	gateway.CloseEventCodeInvalidAPIVersion.Code:    "update disgo, the gateway API version it uses is no longer supported",
+	gateway.CloseEventCodeInvalidIntent.Code:        "check SPAWNBOT_DISCORD_INTENTS for an invalid intent",
+	gateway.CloseEventCodeDisallowedIntent.Code:     "enable the privileged intents the bot uses (e.g. MESSAGE CONTENT) in the Discord developer portal",
+}
+
+// classifyGatewayClose reports the close code err carries, and whether it is
+// fatal, i.e. the gateway won't (and shouldn't) reconnect. Errors without a
+// close code, such as our own Close, are never fatal.
+func classifyGatewayClose(err error) (code gateway.CloseEventCode, fatal bool) {
+	var closed *websocket.CloseError
+	if !errors.As(err, &closed) {
+		return gateway.CloseEventCodeUnknown, false
+	}
+
+	code = gateway.CloseEventCodeByCode(closed.Code)
+	return code, !code.Reconnect
+}
+
+// gatewayCloseHint returns what to do about the fatal close code.
+func gatewayCloseHint(code gateway.CloseEventCode) string {
+	if hint, ok := gatewayCloseHints[code.Code]; ok {
+		return hint
+	}
+
+	return code.Explanation
+}
+
+// gatewayClosed handles a fatal gateway close. With Discord optional, dis_send
+// is disabled, turning off the relays to Discord, and the bot carries on
+// IRC-only; otherwise stop is called.
+func gatewayClosed(optional bool, dis_send *discordSender, stop func()) {
+	if !optional {
+		stop()
+		return
+	}
+
+	slog.Warn("[DISCORD] Gateway closed, running IRC-only")
+	if dis_send != nil {
+		dis_send.disable()
+	}
+}
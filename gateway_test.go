@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"spawnbot/cmdhandler"
+	"testing"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/gateway"
+	"github.com/gorilla/websocket"
+)
+
+func TestClassifyGatewayClose(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		code  int
+		fatal bool
+	}{
+		{"authentication failed", &websocket.CloseError{Code: 4004}, 4004, true},
+		{"disallowed intent", fmt.Errorf("read: %w", &websocket.CloseError{Code: 4014}), 4014, true},
+		{"invalid intent", &websocket.CloseError{Code: 4013}, 4013, true},
+		{"session timed out", &websocket.CloseError{Code: 4009}, 4009, false},
+		{"rate limited", &websocket.CloseError{Code: 4008}, 4008, false},
+		{"abnormal closure", &websocket.CloseError{Code: websocket.CloseAbnormalClosure}, gateway.CloseEventCodeUnknown.Code, false},
+		{"not a close", errors.New("connection reset"), gateway.CloseEventCodeUnknown.Code, false},
+		{"our own close", nil, gateway.CloseEventCodeUnknown.Code, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, fatal := classifyGatewayClose(tt.err)
+			if code.Code != tt.code || fatal != tt.fatal {
+				t.Errorf("classifyGatewayClose(%v) = %d, %v, want %d, %v", tt.err, code.Code, fatal, tt.code, tt.fatal)
+			}
+		})
+	}
+
+	if hint := gatewayCloseHint(gateway.CloseEventCodeDisallowedIntent); hint != gatewayCloseHints[4014] || hint == "" {
+		t.Errorf("gatewayCloseHint(4014) = %q, want the developer portal hint", hint)
+	}
+}
+
+func TestGatewayClosed(t *testing.T) {
+	t.Run("optional", func(t *testing.T) {
+		dis_send := newDiscordSender(nil, cmdhandler.RealClock{}, 0, "")
+		gatewayClosed(true, dis_send, func() {
+			t.Error("stop called with Discord optional")
+		})
+
+		if dis_send.enabled() {
+			t.Fatal("relays still enabled after a fatal close")
+		}
+
+		// Nothing reaches the (here missing) client.
+		if _, err := dis_send.send(1, discord.MessageCreate{Content: "hi"}); !errors.Is(err, errDiscordDisabled) {
+			t.Errorf("send() error = %v, want %v", err, errDiscordDisabled)
+		}
+	})
+
+	t.Run("required", func(t *testing.T) {
+		dis_send := newDiscordSender(nil, cmdhandler.RealClock{}, 0, "")
+		stopped := false
+		gatewayClosed(false, dis_send, func() {
+			stopped = true
+		})
+
+		if !stopped {
+			t.Error("stop not called with Discord required")
+		}
+	})
+
+	t.Run("irc only", func(t *testing.T) {
+		var dis_send *discordSender
+		gatewayClosed(true, dis_send, func() {})
+
+		if dis_send.enabled() {
+			t.Error("nil sender reported as enabled")
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/lrstanley/girc"
+)
+
+// ignoreList is the set of nicks (IRC) and usernames (Discord) whose messages
+// are not relayed. It is shared between the relay handlers and the !ignore
+// commands, and is optionally persisted to a JSON file so runtime changes
+// survive a restart.
+type ignoreList struct {
+	path string // empty disables persistence
+
+	mu    sync.Mutex
+	nicks map[string]struct{}
+}
+
+// newIgnoreList returns an ignore list seeded with static, plus any nicks
+// previously persisted to path.
+func newIgnoreList(path string, static []string) (*ignoreList, error) {
+	l := &ignoreList{path: path, nicks: make(map[string]struct{})}
+	for _, nick := range static {
+		l.nicks[girc.ToRFC1459(nick)] = struct{}{}
+	}
+
+	if path == "" {
+		return l, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return l, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var saved []string
+	if err = json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+
+	for _, nick := range saved {
+		l.nicks[girc.ToRFC1459(nick)] = struct{}{}
+	}
+
+	return l, nil
+}
+
+// has reports whether nick is ignored.
+func (l *ignoreList) has(nick string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, ok := l.nicks[girc.ToRFC1459(nick)]
+	return ok
+}
+
+// add ignores nick, returning false if it was already ignored.
+func (l *ignoreList) add(nick string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	nick = girc.ToRFC1459(nick)
+	if _, ok := l.nicks[nick]; ok {
+		return false, nil
+	}
+
+	l.nicks[nick] = struct{}{}
+	return true, l.save()
+}
+
+// del stops ignoring nick, returning false if it wasn't ignored.
+func (l *ignoreList) del(nick string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	nick = girc.ToRFC1459(nick)
+	if _, ok := l.nicks[nick]; !ok {
+		return false, nil
+	}
+
+	delete(l.nicks, nick)
+	return true, l.save()
+}
+
+// list returns the ignored nicks, sorted.
+func (l *ignoreList) list() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.sorted()
+}
+
+// sorted returns the ignored nicks, sorted. l.mu must be held by the caller.
+func (l *ignoreList) sorted() []string {
+	nicks := make([]string, 0, len(l.nicks))
+	for nick := range l.nicks {
+		nicks = append(nicks, nick)
+	}
+	sort.Strings(nicks)
+
+	return nicks
+}
+
+// save persists the list to l.path, if set. l.mu must be held by the caller.
+func (l *ignoreList) save() error {
+	if l.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(l.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(l.path, data, 0o644)
+}
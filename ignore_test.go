@@ -0,0 +1,52 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestIgnoreList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ignore.json")
+	l, err := newIgnoreList(path, []string{"Spammer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !l.has("spammer") {
+		t.Error("static nick isn't ignored")
+	}
+
+	if added, err := l.add("Troll[1]"); !added || err != nil {
+		t.Errorf("add(Troll[1]) = %v, %v, want true, nil", added, err)
+	}
+	if added, _ := l.add("troll{1}"); added {
+		t.Error("add() of an ignored nick in a different case reported it added")
+	}
+	if !l.has("TROLL[1]") {
+		t.Error("added nick isn't ignored")
+	}
+
+	if got, want := l.list(), []string{"spammer", "troll{1}"}; !slices.Equal(got, want) {
+		t.Errorf("list() = %q, want %q", got, want)
+	}
+
+	if removed, err := l.del("Spammer"); !removed || err != nil {
+		t.Errorf("del(Spammer) = %v, %v, want true, nil", removed, err)
+	}
+	if removed, _ := l.del("nobody"); removed {
+		t.Error("del() of a nick that wasn't ignored reported it removed")
+	}
+	if l.has("spammer") {
+		t.Error("deleted nick is still ignored")
+	}
+
+	// Runtime changes survive a restart.
+	reloaded, err := newIgnoreList(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := reloaded.list(), []string{"troll{1}"}; !slices.Equal(got, want) {
+		t.Errorf("reloaded list() = %q, want %q", got, want)
+	}
+}
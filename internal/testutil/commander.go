@@ -0,0 +1,74 @@
+// Package testutil holds helpers shared by the spawnbot and cmdhandler tests.
+package testutil
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+)
+
+// Commander is a girc client connected to an in-memory IRC server, which
+// records everything the client sends once registered. Commands use it like
+// any other client; their Reply, Replyf, Message and Notice calls arrive on
+// Sent as the PRIVMSG or NOTICE events the server would see.
+type Commander struct {
+	*girc.Client
+
+	t      testing.TB
+	server net.Conn
+	sent   chan *girc.Event
+}
+
+// NewCommander returns a Commander for nick "SpawnBot", registered with the
+// fake server and closed when t ends.
+func NewCommander(t testing.TB) *Commander {
+	t.Helper()
+
+	client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "SpawnBot", AllowFlood: true})
+	conn, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	c := &Commander{Client: client, t: t, server: server, sent: make(chan *girc.Event, 20)}
+
+	registered := make(chan struct{})
+	go func() {
+		lines := bufio.NewScanner(server)
+		for lines.Scan() {
+			switch e := girc.ParseEvent(lines.Text()); {
+			case e == nil, e.Command == girc.CAP, e.Command == girc.NICK:
+			case e.Command == girc.USER:
+				close(registered)
+			default:
+				c.sent <- e
+			}
+		}
+	}()
+
+	go client.MockConnect(conn)
+
+	select {
+	case <-registered:
+	case <-time.After(time.Second):
+		t.Fatal("client never connected")
+	}
+
+	return c
+}
+
+// Send writes line to the client as if from the server.
+func (c *Commander) Send(line string) {
+	if _, err := c.server.Write([]byte(line + "\r\n")); err != nil {
+		c.t.Errorf("writing %q: %v", line, err)
+	}
+}
+
+// Sent returns the events sent by the client, in order.
+func (c *Commander) Sent() <-chan *girc.Event {
+	return c.sent
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log/slog"
+	"spawnbot/cmdhandler"
+
+	"github.com/lrstanley/girc"
+)
+
+// registerInviteHandler joins channels the bot is INVITEd to, when the
+// inviter is an admin (see CmdHandler.SetAdmins) or the channel matches one
+// of the allow globs. Joined channels are bridged through discovery, if set,
+// when they have a Discord counterpart. Other invites are logged to logger and
+// declined with a notice to the inviter. This must only be called once per
+// client.
+func registerInviteHandler(logger *slog.Logger, irc_client *girc.Client, cmdHandler *cmdhandler.CmdHandler, allow []string, discovery *channelDiscovery) {
+	irc_client.Handlers.Add(girc.INVITE, func(c *girc.Client, e girc.Event) {
+		if e.Source == nil || len(e.Params) < 2 || !girc.IsValidChannel(e.Params[1]) {
+			return
+		}
+
+		channel := e.Params[1]
+		if !cmdHandler.IsAdmin(e) && !inviteAllowed(channel, allow) {
+			logger.Warn("[IRC] Declined invite", slog.String("channel", channel), slog.String("from", e.Source.String()))
+			c.Cmd.Notice(e.Source.Name, "sorry, I can't join "+channel+" without an admin's invite")
+			return
+		}
+
+		logger.Info("[IRC] Invited to "+channel, slog.String("from", e.Source.String()))
+		c.Cmd.Join(channel)
+
+		if discovery != nil {
+			go discovery.pair(channel)
+		}
+	})
+}
+
+// inviteAllowed reports whether channel matches one of the allow globs,
+// case-insensitively.
+func inviteAllowed(channel string, allow []string) bool {
+	channel = girc.ToRFC1459(channel)
+	for _, pattern := range allow {
+		if girc.Glob(channel, girc.ToRFC1459(pattern)) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log/slog"
+	"spawnbot/cmdhandler"
+	"spawnbot/internal/testutil"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+)
+
+func TestInvite(t *testing.T) {
+	fake := testutil.NewCommander(t)
+	irc_client, send, sent := fake.Client, fake.Send, fake.Sent()
+
+	cmdHandler, err := cmdhandler.New("!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmdHandler.SetAdmins("admin!*@example.org")
+	registerInviteHandler(slog.Default(), irc_client, cmdHandler, []string{"#Spawn-*"}, nil)
+
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"admin", ":admin!a@example.org INVITE SpawnBot #anywhere", "JOIN #anywhere"},
+		{"allowed channel", ":alice!a@example.org INVITE SpawnBot #spawn-dev", "JOIN #spawn-dev"},
+		{"declined", ":alice!a@example.org INVITE SpawnBot #elsewhere", "NOTICE alice :sorry, I can't join #elsewhere without an admin's invite"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			send(tt.line)
+
+			for {
+				select {
+				case e := <-sent:
+					if e.Command == girc.PONG {
+						continue
+					}
+					if e.String() != tt.want {
+						t.Errorf("sent %q, want %q", e.String(), tt.want)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("nothing sent, want %q", tt.want)
+				}
+				return
+			}
+		})
+	}
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"spawnbot/cmdhandler"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/lrstanley/girc"
+)
+
+// registerIRCHandlers wires up every girc handler the bot relies on: QuakeNet
+// auth and channel joins on connect, command dispatch (bridging the replies of
+// BridgeResponses commands), and the IRC->Discord relay between the channels
+// paired in routes, sent through dis. dis may be nil when running IRC-only, or
+// disabled later if Discord goes away, in which case relaying is a no-op.
+// Relayed messages are queued on dis_out and throttled by limiter, with nicks
+// found in nicks turned into Discord mentions. Messages from nicks on ignored
+// aren't relayed, nor are (or masked, depending on its mode) those with words
+// on blocked, and hooks is notified of each relay attempt, while lines marked
+// as relayed with dis's marker came from a bridge and aren't. Messages are
+// remembered in cache by their IRCv3 msgid, if any, and by the ID of the
+// Discord message they were relayed as. MODE changes are described using the
+// server's options in support. Join delays are timed by clk, and everything is
+// logged to logger. This must only be called once per client.
+func registerIRCHandlers(logger *slog.Logger, irc_client *girc.Client, clk cmdhandler.Clock, cmdHandler *cmdhandler.CmdHandler, dis *discordSender, routes *channelMap, dis_out *outbox, limiter *relayLimiter, nicks map[string]snowflake.ID, ignored *ignoreList, blocked *blocklist, hooks *relay, cache *messageCache, support *serverSupport) {
+	// Channels to join, spaced SPAWNBOT_JOIN_DELAY apart, once Q confirms the
+	// AUTH (or SPAWNBOT_AUTH_TIMEOUT passes without it).
+	channels := envList("SPAWNBOT_IRC_CHANNELS")
+	if len(channels) == 0 {
+		channels = []string{"#spawn"}
+	}
+	join_delay := envDuration("SPAWNBOT_JOIN_DELAY", time.Second)
+	auth_timeout := envDuration("SPAWNBOT_AUTH_TIMEOUT", 30*time.Second)
+
+	irc_client.Handlers.Add(girc.CONNECTED, func(c *girc.Client, e girc.Event) {
+		logger.Info("[IRC] Connected to " + c.Server())
+
+		auth := os.Getenv("QNET_AUTH")
+		if auth == "" {
+			go joinChannels(logger, c, clk, channels, join_delay)
+			return
+		}
+
+		var authed atomic.Bool
+		_, done := c.Handlers.AddTmp(girc.NOTICE, auth_timeout, func(c *girc.Client, e girc.Event) bool {
+			if isQAuthNotice(e) {
+				authed.Store(true)
+				return true
+			}
+
+			return false
+		})
+
+		c.Cmd.Message("q@CServe.quakenet.org", fmt.Sprintf("AUTH SpawnBot %s", sanitizeIRC(auth)))
+
+		go func() {
+			<-done
+			if authed.Load() {
+				logger.Info("[IRC] Authenticated with Q")
+				// Only ask for the hidden host once authed, as +x without an
+				// account does nothing and the real host would be exposed.
+				if hideHost(c, auth_timeout) {
+					logger.Info("[IRC] Host hidden")
+				} else {
+					logger.Warn("[IRC] Hidden host (+x) not confirmed, real host may be visible", slog.Duration("timeout", auth_timeout))
+				}
+			} else {
+				logger.Warn("[IRC] No AUTH confirmation from Q, joining anyway without +x", slog.Duration("timeout", auth_timeout))
+			}
+
+			joinChannels(logger, c, clk, channels, join_delay)
+		}()
+	})
+
+	irc_client.Handlers.Add(girc.PRIVMSG, cmdHandler.Execute)
+
+	//   /##                           /##                /## /##
+	//  |__/                          |  ##              | ##|__/
+	//   /##  /######   /#######       \  ##         /####### /##  /#######
+	//  | ## /##__  ## /##_____/        \  ##       /##__  ##| ## /##_____/
+	//  | ##| ##  \__/| ##               /##/      | ##  | ##| ##|  ######
+	//  | ##| ##      | ##              /##/       | ##  | ##| ## \____  ##
+	//  | ##| ##      |  #######       /##/        |  #######| ## /#######/
+	//  |__/|__/       \_______/      |__/          \_______/|__/|_______/
+	// SPAWNBOT_RELAY_IRC_TO_DISCORD=false makes the bridge a one-way mirror.
+	relay := envBool("SPAWNBOT_RELAY_IRC_TO_DISCORD", true)
+
+	// Replies of commands with BridgeResponses are echoed to Discord too, as
+	// relays are, but without pinging anyone.
+	if relay && dis != nil {
+		cmdHandler.SetBridge(func(input *cmdhandler.Input, msg string) {
+			dis_channel, ok := routes.discord(input.Origin.Params[0])
+			if !ok || hooks.isPaused() || !dis.enabled() {
+				return
+			}
+
+			message := fmt.Sprintf("[IRC] %s: %s", irc_client.GetNick(), msg)
+			create := discordMessage(message, nil, false).Build()
+			dis_out.push(func() {
+				if _, err := dis.send(dis_channel, create); err != nil {
+					logger.Error("[DISCORD] Errors while sending message to discord", slog.Any("err", err))
+				}
+			})
+		})
+	}
+
+	// SPAWNBOT_IRC_ADDRESSED controls lines addressing the bot by nick (e.g.
+	// "SpawnBot: hi"): "relay" them as-is (the default), "strip" the nick, or
+	// "drop" them.
+	addressed := strings.ToLower(os.Getenv("SPAWNBOT_IRC_ADDRESSED"))
+	// Nick globs of other bridge bots (SPAWNBOT_BRIDGE_NICKS), whose messages
+	// are dropped to prevent loops, or with SPAWNBOT_BRIDGE_RELAY=true relayed
+	// under the original "[Platform] user:" attribution.
+	bridge_nicks := envList("SPAWNBOT_BRIDGE_NICKS")
+	relay_bridged := envBool("SPAWNBOT_BRIDGE_RELAY", false)
+	// @everyone/@here from IRC are defused unless SPAWNBOT_ALLOW_EVERYONE=true.
+	allow_everyone := envBool("SPAWNBOT_ALLOW_EVERYONE", false)
+	// SPAWNBOT_RELAY_MODES=true relays ops/voice changes in bridged channels.
+	relay_modes := envBool("SPAWNBOT_RELAY_MODES", false)
+	// SPAWNBOT_RELAY_NOTICES=true relays NOTICEs sent to bridged channels,
+	// e.g. from ChanServ.
+	relay_notices := envBool("SPAWNBOT_RELAY_NOTICES", false)
+
+	// relayLines relays lines, all from the same user, as one Discord message.
+	relayLines := func(dis_channel snowflake.ID, lines []ircLine) {
+		platform, username := lines[0].platform, lines[0].username
+		contents := make([]string, 0, len(lines))
+		var mentioned []snowflake.ID
+		for _, line := range lines {
+			contents = append(contents, line.content)
+			mentioned = append(mentioned, line.mentioned...)
+		}
+
+		content := strings.Join(contents, "\n")
+		message := fmt.Sprintf("[%s] %s: %s", platform, username, content)
+
+		builder := discordMessage(message, mentioned, allow_everyone)
+
+		dis_out.push(func() {
+			sent, err := dis.send(dis_channel, builder.Build())
+			if tooLong(err) {
+				// A safety net should the message get past the length limit:
+				// send it in pieces rather than dropping it.
+				logger.Warn("[DISCORD] Message too long, sending in parts", slog.Int("length", len(message)))
+				for _, chunk := range splitContent(message, discordMaxLen-len(dis.marker)) {
+					if sent, err = dis.send(dis_channel, discordMessage(chunk, mentioned, allow_everyone).Build()); err != nil {
+						break
+					}
+				}
+			}
+
+			if err != nil {
+				logger.Error("[DISCORD] Errors while sending message to discord", slog.Any("err", err))
+				hooks.failed(ircToDiscord, err)
+			} else {
+				logger.Info(message)
+				// Remembered by Discord ID too, so reactions can name the author.
+				cache.add(sent.ID.String(), username, content)
+				hooks.relayed(ircToDiscord, username, content)
+			}
+		})
+	}
+
+	// With SPAWNBOT_IRC_COALESCE_WINDOW set (e.g. "2s"), consecutive lines
+	// from the same user are relayed as one message once they pause for that
+	// long. Whatever's still buffered is relayed before dis_out drains.
+	var coalesce *coalescer
+	if window := envDuration("SPAWNBOT_IRC_COALESCE_WINDOW", 0); window > 0 {
+		coalesce = newCoalescer(clk, window, relayLines)
+		dis_out.onDrain(coalesce.flushAll)
+	}
+
+	irc_client.Handlers.Add(girc.PRIVMSG, func(c *girc.Client, e girc.Event) {
+		// Private messages to the bot (e.g. commands sent by PM) are never
+		// bridged; girc's Reply already answers those back to the sender.
+		if !relay || !dis.enabled() || hooks.isPaused() || !e.IsFromChannel() || ignored.has(e.Source.Name) {
+			return
+		}
+
+		// Nor are the bot's own messages, which the server echoes back when
+		// echo-message is negotiated (see SPAWNBOT_IRC_CAPS).
+		if e.Source.Name == c.GetNick() {
+			return
+		}
+
+		dis_channel, ok := routes.discord(e.Params[0])
+		if !ok || isRelayed(e.Last(), dis.marker) {
+			return
+		}
+
+		text := e.Last()
+		if rest, ok := cmdhandler.StripMention(c.GetNick(), text); ok {
+			switch addressed {
+			case "drop":
+				return
+			case "strip":
+				text = rest
+			}
+		}
+
+		ok, dropped := limiter.allow()
+		if !ok {
+			return
+		}
+
+		if dropped > 0 {
+			notice := throttleNotice("IRC", dropped)
+			logger.Warn(notice)
+			dis_out.push(func() {
+				if _, err := dis.send(dis_channel, discordMessage(notice, nil, false).Build()); err != nil {
+					logger.Error("[DISCORD] Errors while sending message to discord", slog.Any("err", err))
+				}
+			})
+		}
+
+		platform, username := "IRC", e.Source.Name
+		if isBridgeNick(username, bridge_nicks) {
+			var ok bool
+			if platform, username, text, ok = parseBridged(text); !ok || !relay_bridged {
+				return
+			}
+		}
+
+		if id, ok := e.Tags.Get("msgid"); ok {
+			cache.add(id, username, e.Last())
+		}
+
+		if text, ok = blocked.filter(text); !ok {
+			return
+		}
+
+		content, mentioned := mentionNicks(text, nicks)
+
+		line := ircLine{platform: platform, username: username, content: content, mentioned: mentioned}
+		if coalesce != nil {
+			coalesce.add(dis_channel, line)
+		} else {
+			relayLines(dis_channel, []ircLine{line})
+		}
+	})
+
+	irc_client.Handlers.Add(girc.NOTICE, func(c *girc.Client, e girc.Event) {
+		// Server notices and those sent to the bot alone aren't relayed, nor
+		// are the bot's own, which echo-message would otherwise send back.
+		if !relay || !relay_notices || !dis.enabled() || hooks.isPaused() || !e.IsFromChannel() || e.Source == nil || e.Source.Host == "" {
+			return
+		}
+
+		if e.Source.Name == c.GetNick() || ignored.has(e.Source.Name) {
+			return
+		}
+
+		dis_channel, ok := routes.discord(e.Params[0])
+		if !ok || isRelayed(e.Last(), dis.marker) {
+			return
+		}
+
+		text, ok := blocked.filter(e.Last())
+		if !ok {
+			return
+		}
+
+		message := fmt.Sprintf("[IRC notice] %s: %s", e.Source.Name, text)
+		create := discordMessage(message, nil, allow_everyone).Build()
+		dis_out.push(func() {
+			if _, err := dis.send(dis_channel, create); err != nil {
+				logger.Error("[DISCORD] Errors while sending message to discord", slog.Any("err", err))
+				hooks.failed(ircToDiscord, err)
+			} else {
+				hooks.relayed(ircToDiscord, e.Source.Name, text)
+			}
+		})
+	})
+
+	irc_client.Handlers.Add(girc.MODE, func(c *girc.Client, e girc.Event) {
+		if !relay || !relay_modes || !dis.enabled() || hooks.isPaused() || e.Source == nil || len(e.Params) < 3 {
+			return
+		}
+
+		dis_channel, ok := routes.discord(e.Params[0])
+		if !ok {
+			return
+		}
+
+		chanmodes, _ := support.get("CHANMODES")
+		prefix, _ := support.get("PREFIX")
+		for _, line := range describeModes(e.Source.Name, e.Params[1], e.Params[2:], chanmodes, prefix) {
+			create := discordMessage("[IRC] "+line, nil, false).Build()
+			dis_out.push(func() {
+				if _, err := dis.send(dis_channel, create); err != nil {
+					logger.Error("[DISCORD] Errors while sending message to discord", slog.Any("err", err))
+				}
+			})
+		}
+	})
+}
+
+// everyoneMention matches Discord's mass mentions.
+var everyoneMention = regexp.MustCompile(`@(everyone|here)\b`)
+
+// neutralizeEveryone inserts a zero-width space after the "@" of @everyone
+// and @here, so they show as text rather than pinging the channel.
+func neutralizeEveryone(content string) string {
+	return everyoneMention.ReplaceAllString(content, "@\u200b$1")
+}
+
+// allowedMentions returns the allowed mentions for a relayed message: exactly
+// the users in mentioned, plus @everyone/@here only if allow_everyone is set.
+// Anything else, including roles, never pings.
+func allowedMentions(mentioned []snowflake.ID, allow_everyone bool) *discord.AllowedMentions {
+	allowed := &discord.AllowedMentions{
+		Parse: []discord.AllowedMentionType{},
+		Roles: []snowflake.ID{},
+		Users: append([]snowflake.ID{}, mentioned...),
+	}
+	if allow_everyone {
+		allowed.Parse = append(allowed.Parse, discord.AllowedMentionTypeEveryone)
+	}
+
+	return allowed
+}
+
+// discordMessage starts a message with content which pings only the users in
+// mentioned, plus @everyone/@here if allow_everyone is set; otherwise they're
+// defused in the text too. Everything sent to Discord is built with it, so
+// user-controlled text never gets Discord's default mention parsing.
+func discordMessage(content string, mentioned []snowflake.ID, allow_everyone bool) *discord.MessageCreateBuilder {
+	if !allow_everyone {
+		content = neutralizeEveryone(content)
+	}
+
+	return discord.NewMessageCreateBuilder().SetContent(content).SetAllowedMentions(allowedMentions(mentioned, allow_everyone))
+}
+
+// isBridgeNick reports whether nick matches one of the bridge nick globs.
+func isBridgeNick(nick string, patterns []string) bool {
+	nick = girc.ToRFC1459(nick)
+	for _, pattern := range patterns {
+		if girc.Glob(nick, girc.ToRFC1459(pattern)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// bridgedLine matches a message relayed by another bridge, e.g.
+// "[DISCORD] user: hello".
+var bridgedLine = regexp.MustCompile(`^\[(\w+)\] ([^\s:]+): (.*)$`)
+
+// parseBridged splits a message relayed by another bridge into its platform,
+// original author and content.
+func parseBridged(text string) (platform, user, content string, ok bool) {
+	m := bridgedLine.FindStringSubmatch(text)
+	if m == nil {
+		return "", "", "", false
+	}
+
+	return m[1], m[2], m[3], true
+}
+
+// isQAuthNotice reports whether e is QuakeNet Q's notice confirming a
+// successful AUTH.
+func isQAuthNotice(e girc.Event) bool {
+	return e.Source != nil && girc.ToRFC1459(e.Source.Name) == "q" && strings.HasPrefix(e.Last(), "You are now logged in as")
+}
+
+// rplHostHidden is the numeric the server sends once a hidden host is set.
+const rplHostHidden = "396"
+
+// hideHost sets user mode +x and waits up to timeout for the server to
+// confirm the hidden host, reporting whether it did.
+func hideHost(c *girc.Client, timeout time.Duration) bool {
+	var hidden atomic.Bool
+	_, done := c.Handlers.AddTmp(girc.ALL_EVENTS, timeout, func(c *girc.Client, e girc.Event) bool {
+		switch {
+		case e.Command == rplHostHidden:
+		case e.Command == girc.MODE && len(e.Params) >= 2 && girc.ToRFC1459(e.Params[0]) == girc.ToRFC1459(c.GetNick()) && strings.HasPrefix(e.Params[1], "+") && strings.Contains(e.Params[1], "x"):
+		default:
+			return false
+		}
+
+		hidden.Store(true)
+		return true
+	})
+
+	c.Cmd.Mode(c.GetNick(), "+x")
+	<-done
+
+	return hidden.Load()
+}
+
+// joinChannels joins each of channels in turn, waiting delay by clk between
+// joins so the server doesn't throttle a multi-channel setup. Failures are
+// logged to logger.
+func joinChannels(logger *slog.Logger, c *girc.Client, clk cmdhandler.Clock, channels []string, delay time.Duration) {
+	for i, channel := range channels {
+		if i > 0 {
+			<-clk.After(delay)
+		}
+
+		logger.Info("[IRC] Joining " + channel)
+		c.Cmd.Join(channel)
+	}
+}
+
+// ircCaps are the IRCv3 capabilities girc knows how to handle, and so may be
+// requested through SPAWNBOT_IRC_CAPS. Most are already negotiated by girc,
+// but echo-message is opt-in.
+var ircCaps = map[string]bool{
+	"account-notify":    true,
+	"account-tag":       true,
+	"away-notify":       true,
+	"batch":             true,
+	"cap-notify":        true,
+	"chghost":           true,
+	"echo-message":      true,
+	"extended-join":     true,
+	"invite-notify":     true,
+	"message-tags":      true,
+	"msgid":             true,
+	"multi-prefix":      true,
+	"server-time":       true,
+	"userhost-in-names": true,
+}
+
+// parseCaps returns the girc SupportedCaps for the capability names (from
+// SPAWNBOT_IRC_CAPS), matched case-insensitively. account-tag is always
+// included, as command admin checks rely on it for Input.Account.
+func parseCaps(names []string) (map[string][]string, error) {
+	caps := map[string][]string{"account-tag": nil}
+	for _, name := range names {
+		name = strings.ToLower(name)
+		if !ircCaps[name] {
+			return nil, fmt.Errorf("unsupported irc capability: %q", name)
+		}
+
+		caps[name] = nil
+	}
+
+	return caps, nil
+}
+
+// parseNickMap parses "ircnick=discordid" entries (from SPAWNBOT_NICK_MAP)
+// into a map keyed by the RFC1459-lowered nick.
+func parseNickMap(entries []string) (map[string]snowflake.ID, error) {
+	nicks := make(map[string]snowflake.ID, len(entries))
+	for _, entry := range entries {
+		nick, id, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(nick) == "" {
+			return nil, fmt.Errorf("invalid nick map entry: %q (req: ircnick=discordid)", entry)
+		}
+
+		parsed, err := snowflake.Parse(strings.TrimSpace(id))
+		if err != nil {
+			return nil, fmt.Errorf("invalid discord id in nick map entry %q: %w", entry, err)
+		}
+
+		nicks[girc.ToRFC1459(strings.TrimSpace(nick))] = parsed
+	}
+
+	return nicks, nil
+}
+
+// nickToken matches a nick-like word in IRC content, optionally addressed with
+// a leading "@".
+var nickToken = regexp.MustCompile("@?[A-Za-z0-9_\\-\\[\\]\\\\`^{|}]+")
+
+// mentionNicks replaces mapped nicks in content with Discord "<@id>" mentions,
+// returning the rewritten content and the users mentioned so the message's
+// allowed mentions can be set to ping exactly those users.
+func mentionNicks(content string, nicks map[string]snowflake.ID) (string, []snowflake.ID) {
+	if len(nicks) == 0 {
+		return content, nil
+	}
+
+	var mentioned []snowflake.ID
+	content = nickToken.ReplaceAllStringFunc(content, func(word string) string {
+		id, ok := nicks[girc.ToRFC1459(strings.TrimPrefix(word, "@"))]
+		if !ok {
+			return word
+		}
+
+		if !slices.Contains(mentioned, id) {
+			mentioned = append(mentioned, id)
+		}
+
+		return "<@" + id.String() + ">"
+	})
+
+	return content, mentioned
+}
+
+// splitIRC splits text into lines starting with prefix, each short enough
+// that "PRIVMSG target :line" fits within max bytes (see
+// girc.Client.MaxEventLength), breaking at the last space where there is one.
+// Without this, girc would split overlong lines itself, but without repeating
+// the prefix.
+func splitIRC(target, prefix, text string, max int) []string {
+	budget := max - len("PRIVMSG ") - len(target) - len(" :") - len(prefix)
+	if budget <= 0 || len(text) <= budget {
+		return []string{prefix + text}
+	}
+
+	var lines []string
+	for len(text) > budget {
+		cut := budget
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		if space := strings.LastIndexByte(text[:cut], ' '); space > budget/2 {
+			cut = space
+		}
+		if cut == 0 {
+			_, cut = utf8.DecodeRuneInString(text)
+		}
+
+		lines = append(lines, prefix+text[:cut])
+		text = strings.TrimLeft(text[cut:], " ")
+	}
+
+	if text != "" {
+		lines = append(lines, prefix+text)
+	}
+
+	return lines
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"slices"
+	"spawnbot/cmdhandler"
+	"spawnbot/internal/testutil"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/lrstanley/girc"
+)
+
+func TestRegisterIRCHandlers(t *testing.T) {
+	irc_client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "SpawnBot"})
+	cmdHandler, err := cmdhandler.New("!")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	connected, privmsg := irc_client.Handlers.Count(girc.CONNECTED), irc_client.Handlers.Count(girc.PRIVMSG)
+	registerIRCHandlers(slog.Default(), irc_client, cmdhandler.RealClock{}, cmdHandler, nil, spawnRoutes(), newOutbox("DISCORD", 1), nil, nil, nil, nil, nil, nil, nil)
+
+	// One CONNECTED handler for auth and joins; PRIVMSG gets command
+	// dispatch and the Discord relay.
+	if n := irc_client.Handlers.Count(girc.CONNECTED) - connected; n != 1 {
+		t.Errorf("registered %d CONNECTED handlers, want 1", n)
+	}
+	if n := irc_client.Handlers.Count(girc.PRIVMSG) - privmsg; n != 2 {
+		t.Errorf("registered %d PRIVMSG handlers, want 2", n)
+	}
+}
+
+func TestRelayAddressed(t *testing.T) {
+	tests := []struct {
+		mode string
+		want string // empty if nothing should be relayed
+	}{
+		{"", "[IRC] alice: SpawnBot: relay this"},
+		{"relay", "[IRC] alice: SpawnBot: relay this"},
+		{"strip", "[IRC] alice: relay this"},
+		{"drop", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			t.Setenv("SPAWNBOT_IRC_ADDRESSED", tt.mode)
+
+			dis_client, created := fakeDiscord(t)
+			irc_client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "SpawnBot"})
+			cmdHandler, _ := cmdhandler.New("!")
+			ignored, _ := newIgnoreList("", nil)
+			registerIRCHandlers(slog.Default(), irc_client, cmdhandler.RealClock{}, cmdHandler, newDiscordSender(dis_client, cmdhandler.RealClock{}, 0, ""), spawnRoutes(), newOutbox("DISCORD", 10), nil, nil, ignored, nil, nil, newMessageCache(10), nil)
+
+			irc_client.RunHandlers(girc.ParseEvent(":alice!a@example.org PRIVMSG #spawn :SpawnBot: relay this"))
+
+			select {
+			case message := <-created:
+				if message.Content != tt.want {
+					t.Errorf("relayed %q, want %q", message.Content, tt.want)
+				}
+			case <-time.After(200 * time.Millisecond):
+				if tt.want != "" {
+					t.Errorf("nothing relayed, want %q", tt.want)
+				}
+			}
+
+			// Lines not addressing the bot are always relayed unchanged.
+			irc_client.RunHandlers(girc.ParseEvent(":alice!a@example.org PRIVMSG #spawn :SpawnBotFan: hi"))
+			if message := <-created; message.Content != "[IRC] alice: SpawnBotFan: hi" {
+				t.Errorf("relayed %q, want the line unchanged", message.Content)
+			}
+		})
+	}
+}
+
+func TestPrivateCommand(t *testing.T) {
+	dis_client, created := fakeDiscord(t)
+	fake := testutil.NewCommander(t)
+	irc_client, send, sent := fake.Client, fake.Send, fake.Sent()
+	cmdHandler, _ := cmdhandler.New("!")
+	if err := cmdHandler.Add(&cmdhandler.Command{Name: "ping", Fn: func(c *girc.Client, in *cmdhandler.Input) {
+		c.Cmd.Reply(*in.Origin, "pong")
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	ignored, _ := newIgnoreList("", nil)
+	registerIRCHandlers(slog.Default(), irc_client, cmdhandler.RealClock{}, cmdHandler, newDiscordSender(dis_client, cmdhandler.RealClock{}, 0, ""), spawnRoutes(), newOutbox("DISCORD", 10), nil, nil, ignored, nil, nil, newMessageCache(10), nil)
+
+	send(":alice!a@example.org PRIVMSG SpawnBot :!ping")
+
+	select {
+	case e := <-sent:
+		if e.Command != girc.PRIVMSG || e.Params[0] != "alice" || e.Last() != "pong" {
+			t.Errorf("replied %q, want a PM to alice", e.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no reply to the PM")
+	}
+
+	select {
+	case message := <-created:
+		t.Errorf("relayed the PM to Discord as %q", message.Content)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestRelayIgnoresEcho(t *testing.T) {
+	dis_client, created := fakeDiscord(t)
+	irc_client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "SpawnBot"})
+	cmdHandler, _ := cmdhandler.New("!")
+	ignored, _ := newIgnoreList("", nil)
+	registerIRCHandlers(slog.Default(), irc_client, cmdhandler.RealClock{}, cmdHandler, newDiscordSender(dis_client, cmdhandler.RealClock{}, 0, ""), spawnRoutes(), newOutbox("DISCORD", 10), nil, nil, ignored, nil, nil, newMessageCache(10), nil)
+
+	// With echo-message, the bot's own relays come back from the server.
+	irc_client.RunHandlers(girc.ParseEvent(":SpawnBot!s@example.org PRIVMSG #spawn :[DISCORD] bob: hello"))
+
+	select {
+	case message := <-created:
+		t.Errorf("relayed the bot's own message back as %q", message.Content)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestRelayNotices(t *testing.T) {
+	t.Setenv("SPAWNBOT_RELAY_NOTICES", "true")
+
+	dis_client, created := fakeDiscord(t)
+	irc_client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "SpawnBot"})
+	cmdHandler, _ := cmdhandler.New("!")
+	ignored, _ := newIgnoreList("", nil)
+	registerIRCHandlers(slog.Default(), irc_client, cmdhandler.RealClock{}, cmdHandler, newDiscordSender(dis_client, cmdhandler.RealClock{}, 0, ""), spawnRoutes(), newOutbox("DISCORD", 10), nil, nil, ignored, nil, nil, newMessageCache(10), nil)
+
+	// Server notices, private ones and the bot's own aren't relayed.
+	irc_client.RunHandlers(girc.ParseEvent(":irc.example.org NOTICE #spawn :server maintenance"))
+	irc_client.RunHandlers(girc.ParseEvent(":ChanServ!cs@services. NOTICE SpawnBot :you are now identified"))
+	irc_client.RunHandlers(girc.ParseEvent(":SpawnBot!s@example.org NOTICE #spawn :[DISCORD] bob: hello"))
+	irc_client.RunHandlers(girc.ParseEvent(":ChanServ!cs@services. NOTICE #spawn :welcome to #spawn"))
+
+	select {
+	case message := <-created:
+		if want := "[IRC notice] ChanServ: welcome to #spawn"; message.channel != SPAWN_CHAN_ID || message.Content != want {
+			t.Errorf("relayed %q to %d, want %q in #spawn", message.Content, message.channel, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel notice not relayed")
+	}
+
+	select {
+	case message := <-created:
+		t.Errorf("also relayed %q", message.Content)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRelayBridged(t *testing.T) {
+	tests := []struct {
+		name  string
+		relay bool
+		line  string
+		want  string // empty if nothing should be relayed
+	}{
+		{"dropped", false, ":OtherBridge!b@example.org PRIVMSG #spawn :[DISCORD] bob: hello", ""},
+		{"reattributed", true, ":OtherBridge!b@example.org PRIVMSG #spawn :[DISCORD] bob: hello", "[DISCORD] bob: hello"},
+		{"glob", true, ":matrix-relay!m@example.org PRIVMSG #spawn :[Matrix] carol: hi: there", "[Matrix] carol: hi: there"},
+		{"unattributed", true, ":OtherBridge!b@example.org PRIVMSG #spawn :bridge restarting", ""},
+		{"not a bridge", false, ":alice!a@example.org PRIVMSG #spawn :[DISCORD] bob: hello", "[IRC] alice: [DISCORD] bob: hello"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SPAWNBOT_BRIDGE_NICKS", "otherbridge,matrix-*")
+			t.Setenv("SPAWNBOT_BRIDGE_RELAY", fmt.Sprint(tt.relay))
+
+			dis_client, created := fakeDiscord(t)
+			irc_client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "SpawnBot"})
+			cmdHandler, _ := cmdhandler.New("!")
+			ignored, _ := newIgnoreList("", nil)
+			registerIRCHandlers(slog.Default(), irc_client, cmdhandler.RealClock{}, cmdHandler, newDiscordSender(dis_client, cmdhandler.RealClock{}, 0, ""), spawnRoutes(), newOutbox("DISCORD", 10), nil, nil, ignored, nil, nil, newMessageCache(10), nil)
+
+			irc_client.RunHandlers(girc.ParseEvent(tt.line))
+
+			select {
+			case message := <-created:
+				if message.Content != tt.want {
+					t.Errorf("relayed %q, want %q", message.Content, tt.want)
+				}
+			case <-time.After(200 * time.Millisecond):
+				if tt.want != "" {
+					t.Errorf("nothing relayed, want %q", tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBridgeResponses(t *testing.T) {
+	dis_client, created := fakeDiscord(t)
+	fake := testutil.NewCommander(t)
+	cmdHandler, _ := cmdhandler.New("!")
+	for _, cmd := range []*cmdhandler.Command{
+		{Name: "ping", BridgeResponses: true, Fn: func(_ *girc.Client, in *cmdhandler.Input) { in.Reply("pong @everyone") }},
+		{Name: "quiet", Fn: func(_ *girc.Client, in *cmdhandler.Input) { in.Reply("shh") }},
+	} {
+		if err := cmdHandler.Add(cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ignored, _ := newIgnoreList("", nil)
+	registerIRCHandlers(slog.Default(), fake.Client, cmdhandler.RealClock{}, cmdHandler, newDiscordSender(dis_client, cmdhandler.RealClock{}, 0, ""), spawnRoutes(), newOutbox("DISCORD", 10), nil, nil, ignored, nil, nil, newMessageCache(10), nil)
+
+	// Both commands reply on IRC, but only ping's reply is bridged.
+	for _, line := range []string{"!quiet", "!ping"} {
+		fake.Send(":alice!a@example.org PRIVMSG #spawn :" + line)
+		select {
+		case <-fake.Sent():
+		case <-time.After(time.Second):
+			t.Fatalf("%s didn't reply on IRC", line)
+		}
+	}
+
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case message := <-created:
+			switch message.Content {
+			case "[IRC] alice: !quiet", "[IRC] alice: !ping":
+				continue
+			case "[IRC] SpawnBot: pong @\u200beveryone":
+			default:
+				t.Fatalf("relayed %q, want ping's reply", message.Content)
+			}
+
+			if mentions := message.AllowedMentions; mentions == nil || len(mentions.Parse) > 0 {
+				t.Errorf("bridged reply allows mentions %+v, want none", mentions)
+			}
+			return
+		case <-deadline:
+			t.Fatal("ping's reply never reached Discord")
+		}
+	}
+}
+
+func TestRelaySplitsTooLong(t *testing.T) {
+	dis_client, created := fakeDiscord(t)
+	irc_client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "SpawnBot"})
+	cmdHandler, _ := cmdhandler.New("!")
+	ignored, _ := newIgnoreList("", nil)
+	registerIRCHandlers(slog.Default(), irc_client, cmdhandler.RealClock{}, cmdHandler, newDiscordSender(dis_client, cmdhandler.RealClock{}, 0, ""), spawnRoutes(), newOutbox("DISCORD", 10), nil, nil, ignored, nil, nil, newMessageCache(10), nil)
+
+	// Discord rejects the whole message, so it's resent in parts.
+	text := strings.Repeat("spam ", 499) + "spam"
+	irc_client.RunHandlers(girc.ParseEvent(":alice!a@example.org PRIVMSG #spawn :" + text))
+
+	var parts []string
+	for len(parts) < 2 {
+		select {
+		case message := <-created:
+			parts = append(parts, message.Content)
+		case <-time.After(time.Second):
+			t.Fatalf("relayed %d parts, want 2", len(parts))
+		}
+	}
+
+	if got := strings.Join(parts, " "); got != "[IRC] alice: "+text {
+		t.Errorf("parts don't add up to the message: %q", got)
+	}
+}
+
+func TestRelayEveryone(t *testing.T) {
+	for _, allow := range []bool{false, true} {
+		t.Run(fmt.Sprintf("allowed=%v", allow), func(t *testing.T) {
+			t.Setenv("SPAWNBOT_ALLOW_EVERYONE", fmt.Sprint(allow))
+
+			dis_client, created := fakeDiscord(t)
+			irc_client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "SpawnBot"})
+			cmdHandler, _ := cmdhandler.New("!")
+			ignored, _ := newIgnoreList("", nil)
+			registerIRCHandlers(slog.Default(), irc_client, cmdhandler.RealClock{}, cmdHandler, newDiscordSender(dis_client, cmdhandler.RealClock{}, 0, ""), spawnRoutes(), newOutbox("DISCORD", 10), nil, nil, ignored, nil, nil, newMessageCache(10), nil)
+
+			irc_client.RunHandlers(girc.ParseEvent(":alice!a@example.org PRIVMSG #spawn :@everyone look"))
+
+			var message posted
+			select {
+			case message = <-created:
+			case <-time.After(time.Second):
+				t.Fatal("nothing relayed")
+			}
+
+			want, parse := "[IRC] alice: @\u200beveryone look", []discord.AllowedMentionType{}
+			if allow {
+				want, parse = "[IRC] alice: @everyone look", []discord.AllowedMentionType{discord.AllowedMentionTypeEveryone}
+			}
+			if message.Content != want {
+				t.Errorf("relayed %q, want %q", message.Content, want)
+			}
+			if message.AllowedMentions == nil || !slices.Equal(message.AllowedMentions.Parse, parse) {
+				t.Errorf("allowed mentions %+v, want parse %q", message.AllowedMentions, parse)
+			}
+		})
+	}
+}
+
+func TestMentionNicks(t *testing.T) {
+	nicks, err := parseNickMap([]string{"alice=111", " Bob[m] = 222 "})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		content   string
+		want      string
+		mentioned []snowflake.ID
+	}{
+		{"hi alice", "hi <@111>", []snowflake.ID{111}},
+		{"@ALICE: ping", "<@111>: ping", []snowflake.ID{111}},
+		{"bob{m} and alice, alice", "<@222> and <@111>, <@111>", []snowflake.ID{222, 111}},
+		{"alicey isn't mapped", "alicey isn't mapped", nil},
+	}
+
+	for _, tt := range tests {
+		got, mentioned := mentionNicks(tt.content, nicks)
+		if got != tt.want || !slices.Equal(mentioned, tt.mentioned) {
+			t.Errorf("mentionNicks(%q) = %q, %v, want %q, %v", tt.content, got, mentioned, tt.want, tt.mentioned)
+		}
+	}
+
+	for _, entry := range []string{"alice", "=111", "alice=notanid"} {
+		if _, err := parseNickMap([]string{entry}); err == nil {
+			t.Errorf("parseNickMap(%q) didn't fail", entry)
+		}
+	}
+}
+
+func TestSplitIRC(t *testing.T) {
+	const max = 100
+	prefix := "[DISCORD] " + strings.Repeat("verylongusername", 3) + ": "
+	long := strings.Repeat("lorem ipsum dolor sit amet ", 10) + strings.Repeat("é", 60)
+
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"short", "hello"},
+		{"words", long},
+		{"no spaces", strings.Repeat("x", 300)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := splitIRC("#spawn", prefix, tt.text, max)
+
+			var rejoined []string
+			for _, line := range lines {
+				if n := len("PRIVMSG #spawn :" + line); n > max {
+					t.Errorf("line %q is %d bytes, over %d", line, n, max)
+				}
+				if !strings.HasPrefix(line, prefix) || !utf8.ValidString(line) {
+					t.Errorf("line %q lost its prefix or split a rune", line)
+				}
+				rejoined = append(rejoined, strings.TrimPrefix(line, prefix))
+			}
+
+			if got := strings.ReplaceAll(strings.Join(rejoined, ""), " ", ""); got != strings.ReplaceAll(tt.text, " ", "") {
+				t.Errorf("lines %q don't add back up to %q", lines, tt.text)
+			}
+		})
+	}
+}
+
+func TestAuthBeforeJoinAndHideHost(t *testing.T) {
+	t.Setenv("QNET_AUTH", "hunter2")
+	t.Setenv("SPAWNBOT_IRC_CHANNELS", "#spawn,#dev")
+	t.Setenv("SPAWNBOT_JOIN_DELAY", "10ms")
+
+	fake := testutil.NewCommander(t)
+	irc_client, send, sent := fake.Client, fake.Send, fake.Sent()
+	cmdHandler, err := cmdhandler.New("!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registerIRCHandlers(slog.Default(), irc_client, cmdhandler.RealClock{}, cmdHandler, nil, spawnRoutes(), newOutbox("DISCORD", 1), nil, nil, nil, nil, nil, nil, nil)
+
+	irc_client.RunHandlers(&girc.Event{Command: girc.CONNECTED})
+
+	// Nothing is joined, and the host isn't hidden, while the AUTH is
+	// unconfirmed.
+	deadline := time.After(200 * time.Millisecond)
+wait:
+	for {
+		select {
+		case e := <-sent:
+			switch e.Command {
+			case girc.JOIN:
+				t.Fatalf("joined %s before the AUTH was confirmed", e.Params[0])
+			case girc.MODE:
+				t.Fatalf("sent %q before the AUTH was confirmed", e.String())
+			}
+		case <-deadline:
+			break wait
+		}
+	}
+
+	send(":Q!TheQBot@CServe.quakenet.org NOTICE SpawnBot :You are now logged in as SpawnBot.")
+
+	select {
+	case e := <-sent:
+		if e.String() != "MODE SpawnBot +x" {
+			t.Fatalf("sent %q after the AUTH notice, want MODE +x", e.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no MODE +x after the AUTH notice")
+	}
+	send(":irc.example.org 396 SpawnBot SpawnBot.users.quakenet.org :is now your hidden host")
+
+	var joined []string
+	for len(joined) < 2 {
+		select {
+		case e := <-sent:
+			if e.Command == girc.JOIN {
+				joined = append(joined, e.Params[0])
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("joined %q after the AUTH notice, want #spawn and #dev", joined)
+		}
+	}
+
+	if !slices.Equal(joined, []string{"#spawn", "#dev"}) {
+		t.Errorf("joined %q, want #spawn then #dev", joined)
+	}
+}
+
+func TestParseCaps(t *testing.T) {
+	tests := []struct {
+		names   []string
+		want    []string
+		wantErr bool
+	}{
+		{nil, []string{"account-tag"}, false},
+		{[]string{"echo-message", "Server-Time"}, []string{"account-tag", "echo-message", "server-time"}, false},
+		{[]string{"account-tag"}, []string{"account-tag"}, false},
+		{[]string{"echo-message", "sasl-magic"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		caps, err := parseCaps(tt.names)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCaps(%q) error = %v, wantErr %v", tt.names, err, tt.wantErr)
+			continue
+		}
+
+		got := slices.Sorted(maps.Keys(caps))
+		if !tt.wantErr && !slices.Equal(got, tt.want) {
+			t.Errorf("parseCaps(%q) = %q, want %q", tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestCapsRequested(t *testing.T) {
+	caps, err := parseCaps([]string{"echo-message"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "SpawnBot", SupportedCaps: caps})
+	conn, server := net.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	go client.MockConnect(conn)
+
+	requested := make(chan string, 1)
+	go func() {
+		lines := bufio.NewScanner(server)
+		for lines.Scan() {
+			e := girc.ParseEvent(lines.Text())
+			if e == nil || e.Command != girc.CAP || len(e.Params) < 2 {
+				continue
+			}
+
+			switch e.Params[len(e.Params)-2] {
+			case "LS":
+				server.Write([]byte(":irc.example.org CAP * LS :account-tag echo-message chghost\r\n"))
+			case "REQ":
+				requested <- e.Last()
+				return
+			}
+		}
+	}()
+
+	select {
+	case req := <-requested:
+		if got := strings.Fields(req); !slices.Contains(got, "echo-message") || !slices.Contains(got, "account-tag") {
+			t.Errorf("requested %q, want echo-message and account-tag", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no CAP REQ sent")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lrstanley/girc"
+)
+
+// Fallbacks for limits a server doesn't advertise: the RFC 1459 line length
+// and the same generous nick, user and host lengths girc assumes.
+const (
+	defaultLineLength = 512
+	defaultNickLength = 30
+	defaultUserLength = 18
+	defaultHostLength = 63
+)
+
+// serverSupport holds the options the server advertised in RPL_ISUPPORT
+// (005), e.g. LINELEN or CHANMODES. girc tracks these too, but sizes lines
+// wrongly when LINELEN is set, so the relay reads its limits from here. A nil
+// serverSupport has nothing advertised, so the defaults apply.
+type serverSupport struct {
+	mu      sync.RWMutex
+	options map[string]string
+}
+
+// registerServerSupport records the options in each RPL_ISUPPORT, starting
+// afresh on each connection. This must only be called once per client.
+func registerServerSupport(irc_client *girc.Client) *serverSupport {
+	s := &serverSupport{options: map[string]string{}}
+
+	irc_client.Handlers.Add(girc.CONNECTED, func(c *girc.Client, e girc.Event) {
+		s.mu.Lock()
+		s.options = map[string]string{}
+		s.mu.Unlock()
+	})
+
+	irc_client.Handlers.Add(girc.RPL_ISUPPORT, func(c *girc.Client, e girc.Event) {
+		s.parse(e.Params)
+	})
+
+	return s
+}
+
+// parse records the options in the params of an RPL_ISUPPORT: our nick, then
+// tokens like "LINELEN=1024", "WHOX" or "-EXCEPTS" (withdrawing EXCEPTS),
+// then "are supported by this server". Values may have \xHH escapes.
+func (s *serverSupport) parse(params []string) {
+	if len(params) < 3 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range params[1 : len(params)-1] {
+		if name, ok := strings.CutPrefix(token, "-"); ok {
+			delete(s.options, name)
+			continue
+		}
+
+		name, value, _ := strings.Cut(token, "=")
+		if name != "" {
+			s.options[name] = unescapeISupport(value)
+		}
+	}
+}
+
+// get returns the value of the option key, "" for options without one, and
+// whether the server advertised it at all.
+func (s *serverSupport) get(key string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	value, ok := s.options[key]
+	return value, ok
+}
+
+// getInt returns the option key as a positive integer, or fallback if it
+// wasn't advertised or isn't one.
+func (s *serverSupport) getInt(key string, fallback int) int {
+	value, _ := s.get(key)
+	if n, err := strconv.Atoi(value); err == nil && n > 0 {
+		return n
+	}
+
+	return fallback
+}
+
+// maxEventLength is how long a command and its params may be, within the
+// advertised LINELEN less the CRLF and the longest ":nick!user@host " the
+// server might prefix it with when passing it on.
+func (s *serverSupport) maxEventLength() int {
+	nick := max(s.getInt("NICKLEN", 0), s.getInt("MAXNICKLEN", 0), defaultNickLength)
+	user := max(s.getInt("USERLEN", 0), defaultUserLength)
+	host := max(s.getInt("HOSTLEN", 0), defaultHostLength)
+
+	return s.getInt("LINELEN", defaultLineLength) - len("\r\n") - len(":!@ ") - nick - user - host
+}
+
+// unescapeISupport decodes the \xHH escapes allowed in ISUPPORT values,
+// leaving malformed ones as they are.
+func unescapeISupport(value string) string {
+	if !strings.Contains(value, `\x`) {
+		return value
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+3 < len(value) && value[i+1] == 'x' {
+			if n, err := strconv.ParseUint(value[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+
+		b.WriteByte(value[i])
+	}
+
+	return b.String()
+}
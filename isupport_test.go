@@ -0,0 +1,80 @@
+package main
+
+import (
+	"maps"
+	"spawnbot/internal/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerSupport(t *testing.T) {
+	s := &serverSupport{options: map[string]string{"EXCEPTS": ""}}
+	s.parse(strings.Fields(`SpawnBot CHANMODES=beI,k,l,imnpst PREFIX=(qaohv)~&@%+ LINELEN=1024 NETWORK=Spawn\x20Net WHOX -EXCEPTS NICKLEN=16`))
+
+	want := map[string]string{
+		"CHANMODES": "beI,k,l,imnpst",
+		"PREFIX":    "(qaohv)~&@%+",
+		"LINELEN":   "1024",
+		"NETWORK":   "Spawn Net",
+		"WHOX":      "",
+	}
+	if !maps.Equal(s.options, want) {
+		t.Errorf("parsed %v, want %v", s.options, want)
+	}
+
+	if value, ok := s.get("WHOX"); value != "" || !ok {
+		t.Errorf(`get("WHOX") = %q, %v, want "", true`, value, ok)
+	}
+	if _, ok := s.get("EXCEPTS"); ok {
+		t.Error(`get("EXCEPTS") found a withdrawn option`)
+	}
+
+	// NICKLEN=16 is shorter than the default assumed, so doesn't shrink it.
+	if got, want := s.maxEventLength(), 1024-len("\r\n:!@ ")-defaultNickLength-defaultUserLength-defaultHostLength; got != want {
+		t.Errorf("maxEventLength() = %d, want %d", got, want)
+	}
+
+	var none *serverSupport
+	if got, want := none.maxEventLength(), defaultLineLength-len("\r\n:!@ ")-defaultNickLength-defaultUserLength-defaultHostLength; got != want {
+		t.Errorf("nil maxEventLength() = %d, want %d", got, want)
+	}
+}
+
+func TestUnescapeISupport(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"plain", "plain"},
+		{`a\x20b`, "a b"},
+		{`\x3Dx`, "=x"},
+		{`bad\xZZ`, `bad\xZZ`},
+		{`short\x2`, `short\x2`},
+	}
+
+	for _, tt := range tests {
+		if got := unescapeISupport(tt.in); got != tt.want {
+			t.Errorf("unescapeISupport(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRegisterServerSupport(t *testing.T) {
+	fake := testutil.NewCommander(t)
+	s := registerServerSupport(fake.Client)
+
+	fake.Send(":irc.example.org 005 SpawnBot LINELEN=2048 CHANTYPES=# :are supported by this server")
+
+	deadline := time.Now().Add(time.Second)
+	for s.getInt("LINELEN", 0) != 2048 {
+		if time.Now().After(deadline) {
+			t.Fatalf("LINELEN = %d after RPL_ISUPPORT, want 2048", s.getInt("LINELEN", 0))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if value, _ := s.get("CHANTYPES"); value != "#" {
+		t.Errorf("CHANTYPES = %q, want #", value)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"spawnbot/cmdhandler"
+	"sync"
+	"time"
+)
+
+// relayLimiter caps how many messages are relayed in one direction over a
+// sliding window, so a flood on one side is dropped rather than queued up
+// and replayed on the other.
+type relayLimiter struct {
+	max    int
+	window time.Duration
+	clock  cmdhandler.Clock
+
+	mu      sync.Mutex
+	sent    []time.Time
+	dropped int
+}
+
+// newRelayLimiter returns a limiter allowing max messages per window, timed by
+// clock. A max of 0 or less disables limiting.
+func newRelayLimiter(clock cmdhandler.Clock, max int, window time.Duration) *relayLimiter {
+	return &relayLimiter{max: max, window: window, clock: clock}
+}
+
+// allow reports whether a message seen now may be relayed. When a message is
+// allowed after a run of dropped ones, dropped is the size of that run, so
+// the caller can post a single notice for it.
+func (l *relayLimiter) allow() (ok bool, dropped int) {
+	if l == nil || l.max <= 0 {
+		return true, 0
+	}
+
+	now := l.clock.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cutoff := now.Add(-l.window)
+	i := 0
+	for i < len(l.sent) && !l.sent[i].After(cutoff) {
+		i++
+	}
+	l.sent = l.sent[i:]
+
+	if len(l.sent) >= l.max {
+		l.dropped++
+		return false, 0
+	}
+
+	l.sent = append(l.sent, now)
+	dropped, l.dropped = l.dropped, 0
+
+	return true, dropped
+}
+
+// throttleNotice is the notice posted by side (e.g. "IRC") once a run of
+// dropped messages ends.
+func throttleNotice(side string, dropped int) string {
+	return fmt.Sprintf("[%s] throttled, dropped %d messages", side, dropped)
+}
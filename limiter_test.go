@@ -0,0 +1,54 @@
+package main
+
+import (
+	"spawnbot/internal/testutil"
+	"testing"
+	"time"
+)
+
+func TestRelayLimiterBurst(t *testing.T) {
+	clk := testutil.NewClock()
+	l := newRelayLimiter(clk, 3, 10*time.Second)
+
+	// A burst of 10 messages in one second: only the first 3 get through.
+	relayed := 0
+	for range 10 {
+		if ok, _ := l.allow(); ok {
+			relayed++
+		}
+		clk.Advance(100 * time.Millisecond)
+	}
+	if relayed != 3 {
+		t.Errorf("relayed %d of the burst, want 3", relayed)
+	}
+
+	// Once the window has passed, the next message is let through and
+	// reports the 7 dropped ones for a single notice.
+	clk.Advance(10 * time.Second)
+	ok, dropped := l.allow()
+	if !ok || dropped != 7 {
+		t.Fatalf("allow() after the window = %v, %d, want true, 7", ok, dropped)
+	}
+	if got, want := throttleNotice("IRC", dropped), "[IRC] throttled, dropped 7 messages"; got != want {
+		t.Errorf("throttleNotice() = %q, want %q", got, want)
+	}
+
+	clk.Advance(time.Second)
+	if ok, dropped = l.allow(); !ok || dropped != 0 {
+		t.Errorf("allow() after the notice = %v, %d, want true, 0", ok, dropped)
+	}
+}
+
+func TestRelayLimiterUnlimited(t *testing.T) {
+	var nil_limiter *relayLimiter
+	unlimited := newRelayLimiter(testutil.NewClock(), 0, time.Second)
+
+	for range 100 {
+		if ok, _ := nil_limiter.allow(); !ok {
+			t.Fatal("nil limiter dropped a message")
+		}
+		if ok, _ := unlimited.allow(); !ok {
+			t.Fatal("unlimited limiter dropped a message")
+		}
+	}
+}
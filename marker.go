@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// defaultRelayMarker is a zero-width space, invisible in most clients.
+const defaultRelayMarker = "\u200b"
+
+// relayMarker returns the marker appended to everything the bridge sends, so
+// both sides can recognise the bridge's own output and refuse to relay it
+// back, even where its author can't be matched by nick (e.g. webhooks or
+// other bridges passing it on). SPAWNBOT_RELAY_MARKER overrides the default,
+// e.g. with a visible tag, and setting it empty turns marking off.
+func relayMarker() string {
+	marker, ok := os.LookupEnv("SPAWNBOT_RELAY_MARKER")
+	if !ok {
+		return defaultRelayMarker
+	}
+
+	return marker
+}
+
+// markRelayed appends marker to text.
+func markRelayed(text, marker string) string {
+	return text + marker
+}
+
+// isRelayed reports whether text ends with marker, ignoring trailing
+// whitespace, so was sent by a bridge using the same marker.
+func isRelayed(text, marker string) bool {
+	return marker != "" && strings.HasSuffix(strings.TrimRight(text, " \t\r\n"), marker)
+}
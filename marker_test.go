@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log/slog"
+	"spawnbot/cmdhandler"
+	"spawnbot/internal/testutil"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/lrstanley/girc"
+)
+
+func TestRelayMarker(t *testing.T) {
+	tests := []struct {
+		text   string
+		marker string
+		want   bool
+	}{
+		{markRelayed("[DISCORD] alice: hi", defaultRelayMarker), defaultRelayMarker, true},
+		{markRelayed("[DISCORD] alice: hi", defaultRelayMarker) + " \r\n", defaultRelayMarker, true},
+		{"[DISCORD] alice: hi", defaultRelayMarker, false},
+		{"hi " + defaultRelayMarker + " there", defaultRelayMarker, false},
+		{markRelayed("[IRC] bob: hi", " ~br"), " ~br", true},
+		// Without a marker, nothing is recognised.
+		{"[IRC] bob: hi", "", false},
+	}
+
+	for _, tt := range tests {
+		if got := isRelayed(tt.text, tt.marker); got != tt.want {
+			t.Errorf("isRelayed(%q, %q) = %v, want %v", tt.text, tt.marker, got, tt.want)
+		}
+	}
+
+	t.Setenv("SPAWNBOT_RELAY_MARKER", "")
+	if got := relayMarker(); got != "" {
+		t.Errorf("relayMarker() = %q with SPAWNBOT_RELAY_MARKER empty, want none", got)
+	}
+}
+
+func TestRelayMarked(t *testing.T) {
+	dis_client, created := fakeDiscord(t)
+	fake := testutil.NewCommander(t)
+	cmdHandler, _ := cmdhandler.New("!")
+	ignored, _ := newIgnoreList("", nil)
+	registerIRCHandlers(slog.Default(), fake.Client, cmdhandler.RealClock{}, cmdHandler, newDiscordSender(dis_client, cmdhandler.RealClock{}, 0, defaultRelayMarker), spawnRoutes(), newOutbox("DISCORD", 10), nil, nil, ignored, nil, nil, newMessageCache(10), nil)
+	registerDiscordHandlers(slog.Default(), dis_client, cmdhandler.RealClock{}, fake.Client, spawnRoutes(), nil, newOutbox("IRC", 10), nil, ignored, ignored, nil, nil, newMessageCache(10), nil, nil, nil, defaultRelayMarker, 0, "", func() {})
+
+	t.Run("irc to discord", func(t *testing.T) {
+		// Another bridge passing on one of our relays, which the nick checks
+		// can't tell apart from anyone else.
+		fake.Send(":otherbot!o@example.org PRIVMSG #spawn :" + markRelayed("[DISCORD] alice: hi", defaultRelayMarker))
+		fake.Send(":bob!b@example.org PRIVMSG #spawn :hello")
+
+		select {
+		case message := <-created:
+			if want := markRelayed("[IRC] bob: hello", defaultRelayMarker); message.Content != want {
+				t.Errorf("relayed %q, want only bob's line, marked: %q", message.Content, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("nothing relayed")
+		}
+	})
+
+	t.Run("discord to irc", func(t *testing.T) {
+		// A webhook echoing one of our relays, which isn't flagged as a bot.
+		dispatchMessage(dis_client, discord.Message{ID: 1, ChannelID: SPAWN_CHAN_ID, Author: discord.User{Username: "echo"}, Content: markRelayed("[IRC] bob: hello", defaultRelayMarker)})
+		dispatchMessage(dis_client, discord.Message{ID: 2, ChannelID: SPAWN_CHAN_ID, Author: discord.User{Username: "alice"}, Content: "hi"})
+
+		for {
+			select {
+			case e := <-fake.Sent():
+				if e.Command == girc.PONG {
+					continue
+				}
+				if want := markRelayed("[DISCORD] alice: hi", defaultRelayMarker); e.Command != girc.PRIVMSG || e.Last() != want {
+					t.Errorf("relayed %q, want only alice's message, marked: %q", e.String(), want)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("nothing relayed")
+			}
+			return
+		}
+	})
+}
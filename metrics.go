@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"spawnbot/cmdhandler"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the command duration
+// histogram buckets.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// histogram is a cumulative histogram of observed durations.
+type histogram struct {
+	counts []uint64 // one per durationBuckets entry
+	count  uint64
+	sum    float64
+}
+
+// metrics collects the bot's counters and histograms, and renders them in the
+// Prometheus text exposition format.
+type metrics struct {
+	mu       sync.Mutex
+	commands map[string]*histogram
+	failures map[string]uint64
+	unknown  uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		commands: make(map[string]*histogram),
+		failures: make(map[string]uint64),
+	}
+}
+
+// observeCommand records that the command name ran for took, and whether it
+// failed. Its signature matches CmdHandler.SetObserver.
+func (m *metrics) observeCommand(name string, took time.Duration, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.commands[name]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		m.commands[name] = h
+	}
+
+	seconds := took.Seconds()
+	for i, le := range durationBuckets {
+		if seconds <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+
+	if failed {
+		m.failures[name]++
+	}
+}
+
+// unknownCommand counts an invocation of a command which doesn't exist.
+func (m *metrics) unknownCommand() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.unknown++
+}
+
+// notFound returns a cmdhandler not-found callback which counts every unknown
+// command, but only logs them as often as limiter allows.
+func (m *metrics) notFound(limiter *relayLimiter) func(*cmdhandler.Input, string) {
+	return func(input *cmdhandler.Input, name string) {
+		m.unknownCommand()
+
+		if ok, dropped := limiter.allow(); ok {
+			slog.Info("[CMD] Unknown command", slog.String("command", name), slog.String("source", input.Origin.Source.Name), slog.Int("suppressed", dropped))
+		}
+	}
+}
+
+// writeTo renders all metrics to w.
+func (m *metrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP spawnbot_command_duration_seconds Time taken to run each command.")
+	fmt.Fprintln(w, "# TYPE spawnbot_command_duration_seconds histogram")
+	for _, name := range sortedKeys(m.commands) {
+		h := m.commands[name]
+		for i, le := range durationBuckets {
+			fmt.Fprintf(w, "spawnbot_command_duration_seconds_bucket{command=%q,le=%q} %d\n", name, strconv.FormatFloat(le, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(w, "spawnbot_command_duration_seconds_bucket{command=%q,le=\"+Inf\"} %d\n", name, h.count)
+		fmt.Fprintf(w, "spawnbot_command_duration_seconds_sum{command=%q} %g\n", name, h.sum)
+		fmt.Fprintf(w, "spawnbot_command_duration_seconds_count{command=%q} %d\n", name, h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP spawnbot_command_failures_total Commands which panicked.")
+	fmt.Fprintln(w, "# TYPE spawnbot_command_failures_total counter")
+	for _, name := range sortedKeys(m.failures) {
+		fmt.Fprintf(w, "spawnbot_command_failures_total{command=%q} %d\n", name, m.failures[name])
+	}
+
+	fmt.Fprintln(w, "# HELP spawnbot_unknown_commands_total Invocations of commands which don't exist.")
+	fmt.Fprintln(w, "# TYPE spawnbot_unknown_commands_total counter")
+	fmt.Fprintf(w, "spawnbot_unknown_commands_total %d\n", m.unknown)
+}
+
+// serve exposes the metrics on addr at /metrics until the process exits.
+func (m *metrics) serve(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.writeTo(w)
+	})
+
+	slog.Info("[METRICS] Listening", slog.String("addr", addr))
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		slog.Error("[METRICS] Unable to serve metrics", slog.Any("err", err))
+	}
+}
+
+// sortedKeys returns the keys of m, sorted.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"spawnbot/cmdhandler"
+	"spawnbot/internal/testutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+)
+
+func TestCommandMetrics(t *testing.T) {
+	stats := newMetrics()
+	cmdHandler, err := cmdhandler.New("!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmdHandler.SetObserver(stats.observeCommand)
+
+	done := make(chan struct{})
+	if err = cmdHandler.Add(&cmdhandler.Command{Name: "slow", Fn: func(*girc.Client, *cmdhandler.Input) {
+		time.Sleep(20 * time.Millisecond)
+		close(done)
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	irc_client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "SpawnBot"})
+	cmdHandler.Execute(irc_client, *girc.ParseEvent(":alice!a@example.org PRIVMSG #spawn :!slow"))
+	<-done
+	time.Sleep(10 * time.Millisecond) // the observer runs once Fn returns
+
+	var out strings.Builder
+	stats.writeTo(&out)
+
+	for _, want := range []string{
+		`spawnbot_command_duration_seconds_count{command="slow"} 1`,
+		`spawnbot_command_duration_seconds_bucket{command="slow",le="0.01"} 0`,
+		`spawnbot_command_duration_seconds_bucket{command="slow",le="+Inf"} 1`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics are missing %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), `spawnbot_command_failures_total{command="slow"}`) {
+		t.Errorf("slow counted as failed:\n%s", out.String())
+	}
+
+	stats.observeCommand("broken", time.Millisecond, true)
+	out.Reset()
+	stats.writeTo(&out)
+	if want := `spawnbot_command_failures_total{command="broken"} 1`; !strings.Contains(out.String(), want) {
+		t.Errorf("metrics are missing %q:\n%s", want, out.String())
+	}
+}
+
+func TestUnknownCommands(t *testing.T) {
+	logs := captureLogs(t)
+	stats := newMetrics()
+	notFound := stats.notFound(newRelayLimiter(testutil.NewClock(), 5, time.Minute))
+
+	spam := &cmdhandler.Input{Origin: girc.ParseEvent(":spammer!s@example.org PRIVMSG #spawn :!garbage")}
+	for range 50 {
+		notFound(spam, "garbage")
+	}
+
+	var out strings.Builder
+	stats.writeTo(&out)
+	if !strings.Contains(out.String(), "spawnbot_unknown_commands_total 50\n") {
+		t.Errorf("metrics don't count all 50 unknown commands:\n%s", out.String())
+	}
+
+	if n := strings.Count(logs.String(), "Unknown command"); n != 5 {
+		t.Errorf("50 unknown commands logged %d times, want 5:\n%s", n, logs)
+	}
+}
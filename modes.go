@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lrstanley/girc"
+)
+
+// prefixModeNames name the channel modes which grant a user status, for
+// relaying, e.g. "gave ops to bob". Other modes aren't relayed.
+var prefixModeNames = map[byte]string{
+	'q': "owner",
+	'a': "admin",
+	'o': "ops",
+	'h': "half-ops",
+	'v': "voice",
+}
+
+// describeModes describes the user status changes in a channel MODE from
+// setter, e.g. "* ChanServ gave ops to bob", one per change. chanmodes and
+// prefix are the server's CHANMODES and PREFIX ISUPPORT values, used to tell
+// which modes take an argument, and may be empty to use girc's defaults.
+func describeModes(setter, flags string, args []string, chanmodes, prefix string) []string {
+	if chanmodes == "" {
+		chanmodes = girc.ModeDefaults
+	}
+	if prefix == "" {
+		prefix = girc.DefaultPrefixes
+	}
+
+	// "(ov)@+" -> "ov"
+	prefix_modes, _, _ := strings.Cut(strings.TrimPrefix(prefix, "("), ")")
+	// "beI,k,l,imnpst": lists and keys always take an argument, settings like
+	// l only when set.
+	groups := strings.SplitN(chanmodes, ",", 4)
+	for len(groups) < 4 {
+		groups = append(groups, "")
+	}
+
+	var lines []string
+	add := true
+	for i := 0; i < len(flags); i++ {
+		mode := flags[i]
+		switch {
+		case mode == '+' || mode == '-':
+			add = mode == '+'
+			continue
+		case strings.IndexByte(prefix_modes, mode) >= 0:
+		case strings.IndexByte(groups[0], mode) >= 0, strings.IndexByte(groups[1], mode) >= 0,
+			add && strings.IndexByte(groups[2], mode) >= 0:
+			// Not relayed, but its argument still needs skipping.
+			if len(args) > 0 {
+				args = args[1:]
+			}
+			continue
+		default:
+			continue
+		}
+
+		if len(args) == 0 {
+			break
+		}
+
+		target := args[0]
+		args = args[1:]
+
+		name, ok := prefixModeNames[mode]
+		if !ok {
+			name = "+" + string(mode)
+		}
+
+		if add {
+			lines = append(lines, fmt.Sprintf("* %s gave %s to %s", setter, name, target))
+		} else {
+			lines = append(lines, fmt.Sprintf("* %s took %s from %s", setter, name, target))
+		}
+	}
+
+	return lines
+}
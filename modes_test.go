@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"spawnbot/cmdhandler"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+)
+
+func TestDescribeModes(t *testing.T) {
+	tests := []struct {
+		flags string
+		args  []string
+		want  []string
+	}{
+		{"+o", []string{"bob"}, []string{"* ChanServ gave ops to bob"}},
+		{"-v", []string{"bob"}, []string{"* ChanServ took voice from bob"}},
+		{"+ov-o", []string{"bob", "carol", "dave"}, []string{"* ChanServ gave ops to bob", "* ChanServ gave voice to carol", "* ChanServ took ops from dave"}},
+		// Bans, keys and limits are skipped along with their arguments.
+		{"+bo", []string{"*!*@spam.example", "bob"}, []string{"* ChanServ gave ops to bob"}},
+		{"+lk-l+v", []string{"10", "secret", "bob"}, []string{"* ChanServ gave voice to bob"}},
+		{"+nt", nil, nil},
+	}
+
+	for _, tt := range tests {
+		if got := describeModes("ChanServ", tt.flags, tt.args, "", ""); !slices.Equal(got, tt.want) {
+			t.Errorf("describeModes(%q, %q) = %q, want %q", tt.flags, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestRelayModes(t *testing.T) {
+	t.Setenv("SPAWNBOT_RELAY_MODES", "true")
+
+	dis_client, created := fakeDiscord(t)
+	irc_client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "SpawnBot"})
+	cmdHandler, _ := cmdhandler.New("!")
+	ignored, _ := newIgnoreList("", nil)
+	registerIRCHandlers(slog.Default(), irc_client, cmdhandler.RealClock{}, cmdHandler, newDiscordSender(dis_client, cmdhandler.RealClock{}, 0, ""), spawnRoutes(), newOutbox("DISCORD", 10), nil, nil, ignored, nil, nil, newMessageCache(10), nil)
+
+	irc_client.RunHandlers(girc.ParseEvent(":ChanServ!cs@services. MODE #spawn +o bob"))
+
+	select {
+	case message := <-created:
+		if message.channel != SPAWN_CHAN_ID || message.Content != "[IRC] * ChanServ gave ops to bob" {
+			t.Errorf("relayed %q to %d, want the mode change in #spawn", message.Content, message.channel)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("mode change not relayed")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// cachedMessage is a message remembered by messageCache.
+type cachedMessage struct {
+	id      string
+	author  string
+	content string
+}
+
+// messageCache is a bounded LRU of recently seen messages keyed by message
+// ID, so edit and delete events can refer back to what was said.
+type messageCache struct {
+	size int
+
+	mu    sync.Mutex
+	order *list.List // of *cachedMessage, most recently used first
+	items map[string]*list.Element
+}
+
+// newMessageCache returns a cache holding at most size messages. A size of 0
+// or less disables caching.
+func newMessageCache(size int) *messageCache {
+	return &messageCache{size: size, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+// add remembers a message, evicting the least recently used one if the cache
+// is full.
+func (c *messageCache) add(id, author, content string) {
+	if c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[id]; ok {
+		el.Value = &cachedMessage{id: id, author: author, content: content}
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[id] = c.order.PushFront(&cachedMessage{id: id, author: author, content: content})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cachedMessage).id)
+	}
+}
+
+// get returns the message with id, if it is still cached.
+func (c *messageCache) get(id string) (cachedMessage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[id]
+	if !ok {
+		return cachedMessage{}, false
+	}
+
+	c.order.MoveToFront(el)
+	return *el.Value.(*cachedMessage), true
+}
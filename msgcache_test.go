@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestMessageCache(t *testing.T) {
+	c := newMessageCache(2)
+	c.add("1", "alice", "one")
+	c.add("2", "bob", "two")
+
+	// Looking 1 up makes 2 the least recently used, so it's evicted by 3.
+	if got, ok := c.get("1"); !ok || got.author != "alice" || got.content != "one" {
+		t.Errorf("get(1) = %+v, %v, want alice's message", got, ok)
+	}
+	c.add("3", "carol", "three")
+
+	if _, ok := c.get("2"); ok {
+		t.Error("2 wasn't evicted")
+	}
+	for _, id := range []string{"1", "3"} {
+		if _, ok := c.get(id); !ok {
+			t.Errorf("%s was evicted", id)
+		}
+	}
+
+	// Re-adding an ID updates it in place.
+	c.add("3", "carol", "edited")
+	if got, _ := c.get("3"); got.content != "edited" {
+		t.Errorf("get(3) = %+v, want the edited content", got)
+	}
+	if _, ok := c.get("1"); !ok {
+		t.Error("updating 3 evicted 1")
+	}
+
+	disabled := newMessageCache(0)
+	disabled.add("1", "alice", "one")
+	if _, ok := disabled.get("1"); ok {
+		t.Error("a zero-size cache remembered a message")
+	}
+}
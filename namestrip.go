@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// nameStrip removes decoration from Discord display names before they're
+// relayed, e.g. an "irc_" prefix some users add to match their IRC nick. A
+// nil nameStrip leaves names alone.
+type nameStrip struct {
+	literal string
+	pattern *regexp.Regexp
+}
+
+// newNameStrip parses spec. Like a blocklist entry, a spec wrapped in
+// slashes, e.g. "/^irc[_-]/", is a regular expression whose matches are
+// removed; anything else is a literal trimmed from either end of the name.
+// An empty spec returns nil.
+func newNameStrip(spec string) (*nameStrip, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	if inner, ok := strings.CutPrefix(spec, "/"); ok && len(inner) > 0 && strings.HasSuffix(inner, "/") {
+		re, err := regexp.Compile(strings.TrimSuffix(inner, "/"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid discord name strip %q: %w", spec, err)
+		}
+
+		return &nameStrip{pattern: re}, nil
+	}
+
+	return &nameStrip{literal: spec}, nil
+}
+
+// apply strips name, returning it unchanged if nothing would be left.
+func (s *nameStrip) apply(name string) string {
+	if s == nil {
+		return name
+	}
+
+	var stripped string
+	if s.pattern != nil {
+		stripped = s.pattern.ReplaceAllString(name, "")
+	} else {
+		stripped = strings.TrimSuffix(strings.TrimPrefix(name, s.literal), s.literal)
+	}
+
+	stripped = strings.TrimSpace(stripped)
+	if stripped == "" {
+		return name
+	}
+
+	return stripped
+}
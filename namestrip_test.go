@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestNameStrip(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		in   string
+		want string
+	}{
+		{"none", "", "irc_alice", "irc_alice"},
+		{"prefix", "irc_", "irc_alice", "alice"},
+		{"suffix", "_irc", "alice_irc", "alice"},
+		{"elsewhere", "irc_", "alice_irc_bob", "alice_irc_bob"},
+		{"regexp", "/^irc[_-]/", "irc-alice", "alice"},
+		{"regexp unmatched", "/^irc[_-]/", "alice", "alice"},
+		{"regexp everywhere", "/ ?\\[irc\\]/", "alice [irc] [irc]", "alice"},
+		{"spaces", "/\\|.*$/", "alice | away", "alice"},
+		{"nothing left", "irc_", "irc_", "irc_"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := newNameStrip(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := s.apply(tt.in); got != tt.want {
+				t.Errorf("apply(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := newNameStrip("/(/"); err == nil {
+		t.Error("newNameStrip accepted an invalid regexp")
+	}
+}
@@ -0,0 +1,15 @@
+package main
+
+import "log/slog"
+
+// runIRCOnly reports whether the bot should carry on without Discord after
+// err, which it does (logging what failed) only when Discord is optional.
+// Otherwise the error is the caller's to handle.
+func runIRCOnly(err error, optional bool, what string) bool {
+	if !optional {
+		return false
+	}
+
+	slog.Warn("[DISCORD] "+what+", running IRC-only", slog.Any("err", err))
+	return true
+}
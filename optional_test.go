@@ -0,0 +1,18 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRunIRCOnly(t *testing.T) {
+	err := errors.New("invalid token")
+
+	if !runIRCOnly(err, true, "Unable to create client") {
+		t.Error("optional Discord failure didn't fall back to IRC-only")
+	}
+
+	if runIRCOnly(err, false, "Unable to create client") {
+		t.Error("required Discord failure fell back to IRC-only")
+	}
+}
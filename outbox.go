@@ -0,0 +1,123 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// outbox serialises outbound messages for one side of the bridge through a
+// single worker goroutine, so anything still queued can be flushed before the
+// connections are closed on shutdown.
+type outbox struct {
+	name  string
+	queue chan func()
+	done  chan struct{}
+	last  atomic.Int64 // unix nanoseconds of the last send
+
+	mu       sync.Mutex
+	closed   bool
+	on_drain []func()
+}
+
+// newOutbox starts a worker for an outbox holding at most size queued
+// messages.
+func newOutbox(name string, size int) *outbox {
+	o := &outbox{
+		name:  name,
+		queue: make(chan func(), size),
+		done:  make(chan struct{}),
+	}
+
+	go o.run()
+
+	return o
+}
+
+func (o *outbox) run() {
+	for send := range o.queue {
+		send()
+		o.last.Store(time.Now().UnixNano())
+	}
+
+	close(o.done)
+}
+
+// push queues send for delivery, returning false if the queue is full or the
+// outbox is draining.
+func (o *outbox) push(send func()) bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.closed {
+		return false
+	}
+
+	select {
+	case o.queue <- send:
+		return true
+	default:
+		slog.Warn("[" + o.name + "] Outbound queue full, dropping message")
+		return false
+	}
+}
+
+// depth returns how many messages are waiting to be sent.
+func (o *outbox) depth() int {
+	return len(o.queue)
+}
+
+// lastSent returns when the last message was sent, or the zero time if
+// nothing has been sent yet.
+func (o *outbox) lastSent() time.Time {
+	last := o.last.Load()
+	if last == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, last)
+}
+
+// onDrain registers flush to be called when the outbox starts draining, while
+// it still accepts messages, so anything buffered before it can be queued.
+func (o *outbox) onDrain(flush func()) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.on_drain = append(o.on_drain, flush)
+}
+
+// drain stops the outbox accepting new messages and waits up to timeout for
+// the queued ones to be sent, returning how many were sent and how many were
+// still queued when the timeout expired. Functions registered with onDrain
+// are called first.
+func (o *outbox) drain(timeout time.Duration) (drained, dropped int) {
+	o.mu.Lock()
+	on_drain := o.on_drain
+	o.on_drain = nil
+	o.mu.Unlock()
+
+	for _, flush := range on_drain {
+		flush()
+	}
+
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return 0, 0
+	}
+
+	o.closed = true
+	pending := len(o.queue)
+	close(o.queue)
+	o.mu.Unlock()
+
+	select {
+	case <-o.done:
+		return pending, 0
+	case <-time.After(timeout):
+		left := len(o.queue)
+		return pending - left, left
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOutboxDrain(t *testing.T) {
+	t.Run("flushes within the timeout", func(t *testing.T) {
+		o := newOutbox("TEST", 10)
+
+		var sent atomic.Int32
+		for range 5 {
+			if !o.push(func() {
+				time.Sleep(10 * time.Millisecond)
+				sent.Add(1)
+			}) {
+				t.Fatal("push refused a message")
+			}
+		}
+
+		drained, dropped := o.drain(time.Second)
+		if sent.Load() != 5 || dropped != 0 {
+			t.Errorf("sent %d, drain() = %d drained, %d dropped, want all 5 sent", sent.Load(), drained, dropped)
+		}
+		if o.push(func() {}) {
+			t.Error("push accepted a message after drain")
+		}
+	})
+
+	t.Run("drops what's left at the timeout", func(t *testing.T) {
+		o := newOutbox("TEST", 10)
+
+		block := make(chan struct{})
+		defer close(block)
+		o.push(func() { <-block })
+		time.Sleep(10 * time.Millisecond) // let the worker pick it up
+		o.push(func() {})
+		o.push(func() {})
+
+		if drained, dropped := o.drain(50 * time.Millisecond); drained != 0 || dropped != 2 {
+			t.Errorf("drain() = %d drained, %d dropped, want 0, 2", drained, dropped)
+		}
+	})
+	t.Run("flushes buffers registered with onDrain first", func(t *testing.T) {
+		o := newOutbox("TEST", 10)
+
+		var sent atomic.Int32
+		o.onDrain(func() {
+			o.push(func() { sent.Add(1) })
+		})
+
+		if drained, dropped := o.drain(time.Second); drained != 1 || dropped != 0 || sent.Load() != 1 {
+			t.Errorf("drain() = %d drained, %d dropped, want the buffered message sent", drained, dropped)
+		}
+	})
+}
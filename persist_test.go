@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "db.json")
+
+	for _, data := range []string{`["first"]`, `["second"]`} {
+		if err := writeFileAtomic(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err := os.ReadFile(path); err != nil || string(got) != data {
+			t.Errorf("read back %q, %v, want %q", got, err, data)
+		}
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+		t.Errorf("file mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory holds %d files, want just the database", len(entries))
+	}
+
+	// Failures to create the temporary file are reported.
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "db.json"), []byte("x"), 0o600); err == nil {
+		t.Error("writing into a missing directory didn't fail")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// pollEmoji are the reactions voted with on Discord, one per option.
+var pollEmoji = []string{"1️⃣", "2️⃣", "3️⃣", "4️⃣", "5️⃣", "6️⃣", "7️⃣", "8️⃣", "9️⃣", "🔟"}
+
+var (
+	errUnterminatedQuote = errors.New("unterminated quote")
+	errPollOptions       = fmt.Errorf("a poll needs a question and 2 to %d options", len(pollEmoji))
+)
+
+// splitQuoted splits s on spaces, keeping "double quoted" runs together as a
+// single argument without the quotes.
+func splitQuoted(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	var quoted, started bool
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			started = true
+		case r == ' ' && !quoted:
+			if started {
+				args = append(args, arg.String())
+				arg.Reset()
+				started = false
+			}
+		default:
+			arg.WriteRune(r)
+			started = true
+		}
+	}
+
+	if quoted {
+		return nil, errUnterminatedQuote
+	}
+	if started {
+		args = append(args, arg.String())
+	}
+
+	return args, nil
+}
+
+// parsePoll parses `"question" option1 "option 2" ...` into the question and
+// its options.
+func parsePoll(raw string) (question string, options []string, err error) {
+	args, err := splitQuoted(raw)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(args) < 3 || len(args) > len(pollEmoji)+1 || strings.TrimSpace(args[0]) == "" {
+		return "", nil, errPollOptions
+	}
+
+	return args[0], args[1:], nil
+}
+
+// pollOptions numbers options with their voting emoji, e.g. "1️⃣ yes".
+func pollOptions(options []string) []string {
+	lines := make([]string, len(options))
+	for i, option := range options {
+		lines[i] = pollEmoji[i] + " " + option
+	}
+
+	return lines
+}
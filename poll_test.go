@@ -0,0 +1,37 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePoll(t *testing.T) {
+	tests := []struct {
+		raw      string
+		question string
+		options  []string
+		err      error
+	}{
+		{`"best editor?" vim emacs`, "best editor?", []string{"vim", "emacs"}, nil},
+		{`lunch? "fish and chips"  pizza`, "lunch?", []string{"fish and chips", "pizza"}, nil},
+		{`"" yes no`, "", nil, errPollOptions},
+		{`"best editor?" vim`, "", nil, errPollOptions},
+		{`q 1 2 3 4 5 6 7 8 9 10 11`, "", nil, errPollOptions},
+		{`"best editor? vim emacs`, "", nil, errUnterminatedQuote},
+	}
+
+	for _, tt := range tests {
+		question, options, err := parsePoll(tt.raw)
+		if question != tt.question || !slices.Equal(options, tt.options) || err != tt.err {
+			t.Errorf("parsePoll(%q) = %q, %q, %v, want %q, %q, %v", tt.raw, question, options, err, tt.question, tt.options, tt.err)
+		}
+	}
+}
+
+func TestPollOptions(t *testing.T) {
+	got := pollOptions([]string{"vim", "emacs", "fish and chips"})
+	want := []string{"1️⃣ vim", "2️⃣ emacs", "3️⃣ fish and chips"}
+	if !slices.Equal(got, want) {
+		t.Errorf("pollOptions() = %q, want %q", got, want)
+	}
+}
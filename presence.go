@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/gateway"
+)
+
+// presenceOpts builds the Discord presence showing status (from
+// SPAWNBOT_DISCORD_STATUS) while IRC is connected, or an idle "IRC
+// disconnected" while it isn't.
+func presenceOpts(status string, irc_up bool) []gateway.PresenceOpt {
+	if !irc_up {
+		return []gateway.PresenceOpt{
+			gateway.WithCustomActivity("IRC disconnected"),
+			gateway.WithOnlineStatus(discord.OnlineStatusIdle),
+		}
+	}
+
+	return []gateway.PresenceOpt{
+		gateway.WithCustomActivity(status),
+		gateway.WithOnlineStatus(discord.OnlineStatusOnline),
+	}
+}
+
+// setPresence updates the Discord presence to reflect whether IRC is up.
+func setPresence(dis_client bot.Client, status string, irc_up bool) {
+	if err := dis_client.SetPresence(context.TODO(), presenceOpts(status, irc_up)...); err != nil {
+		slog.Error("[DISCORD] Unable to update presence", slog.Any("err", err))
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/gateway"
+)
+
+func TestPresenceOpts(t *testing.T) {
+	tests := []struct {
+		irc_up bool
+		state  string
+		status discord.OnlineStatus
+	}{
+		{true, "Bridging #spawn", discord.OnlineStatusOnline},
+		{false, "IRC disconnected", discord.OnlineStatusIdle},
+	}
+
+	for _, tt := range tests {
+		var presence gateway.MessageDataPresenceUpdate
+		for _, opt := range presenceOpts("Bridging #spawn", tt.irc_up) {
+			opt(&presence)
+		}
+
+		if presence.Status != tt.status {
+			t.Errorf("irc_up=%v: status %q, want %q", tt.irc_up, presence.Status, tt.status)
+		}
+
+		if len(presence.Activities) != 1 {
+			t.Fatalf("irc_up=%v: %d activities, want 1", tt.irc_up, len(presence.Activities))
+		}
+		activity := presence.Activities[0]
+		if activity.Type != discord.ActivityTypeCustom || activity.State == nil || *activity.State != tt.state {
+			t.Errorf("irc_up=%v: activity %+v, want custom status %q", tt.irc_up, activity, tt.state)
+		}
+	}
+}
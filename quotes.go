@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"sync"
+)
+
+// errNoQuotes is returned when a quote is requested from an empty database.
+var errNoQuotes = errors.New("no quotes yet")
+
+// quoteDB is the list of quotes behind !quote and !addquote, optionally
+// persisted to a JSON file which is rewritten on each add.
+type quoteDB struct {
+	path string // empty disables persistence
+
+	mu     sync.Mutex
+	quotes []string
+}
+
+// newQuoteDB returns a quote database, loading any quotes previously saved to
+// path.
+func newQuoteDB(path string) (*quoteDB, error) {
+	db := &quoteDB{path: path}
+	if path == "" {
+		return db, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return db, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &db.quotes); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// add stores quote, returning its 1-based number.
+func (db *quoteDB) add(quote string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.quotes = append(db.quotes, quote)
+	return len(db.quotes), db.save()
+}
+
+// get returns quote number n (1-based).
+func (db *quoteDB) get(n int) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if len(db.quotes) == 0 {
+		return "", errNoQuotes
+	}
+
+	if n < 1 || n > len(db.quotes) {
+		return "", fmt.Errorf("no quote #%d (there are %d)", n, len(db.quotes))
+	}
+
+	return db.quotes[n-1], nil
+}
+
+// random returns a random quote and its number, chosen with r.
+func (db *quoteDB) random(r *rand.Rand) (int, string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if len(db.quotes) == 0 {
+		return 0, "", errNoQuotes
+	}
+
+	n := r.Intn(len(db.quotes))
+	return n + 1, db.quotes[n], nil
+}
+
+// save persists the quotes to db.path, if set. db.mu must be held by the
+// caller.
+func (db *quoteDB) save() error {
+	if db.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(db.quotes, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(db.path, data, 0o644)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestQuoteDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotes.json")
+	db, err := newQuoteDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = db.get(1); !errors.Is(err, errNoQuotes) {
+		t.Errorf("get(1) on an empty database = %v, want errNoQuotes", err)
+	}
+	if _, _, err = db.random(rand.New(rand.NewSource(1))); !errors.Is(err, errNoQuotes) {
+		t.Errorf("random() on an empty database = %v, want errNoQuotes", err)
+	}
+
+	for i, quote := range []string{"<alice> first", "<bob> second", "<carol> third"} {
+		if n, err := db.add(quote); err != nil || n != i+1 {
+			t.Fatalf("add(%q) = %d, %v, want %d", quote, n, err, i+1)
+		}
+	}
+
+	if quote, err := db.get(2); err != nil || quote != "<bob> second" {
+		t.Errorf("get(2) = %q, %v, want bob's quote", quote, err)
+	}
+	for _, n := range []int{0, -1, 4} {
+		if _, err := db.get(n); err == nil {
+			t.Errorf("get(%d) of 3 quotes didn't fail", n)
+		}
+	}
+
+	// The same seed picks the same quotes.
+	pick := func(seed int64) []int {
+		r := rand.New(rand.NewSource(seed))
+		picked := make([]int, 5)
+		for i := range picked {
+			n, quote, err := db.random(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, _ := db.get(n); quote != want {
+				t.Fatalf("random() = #%d %q, want %q", n, quote, want)
+			}
+			picked[i] = n
+		}
+
+		return picked
+	}
+	if first, again := pick(42), pick(42); !slices.Equal(first, again) {
+		t.Errorf("seed 42 picked %v, then %v", first, again)
+	}
+
+	// Quotes survive a restart, and no temporary files are left behind.
+	reloaded, err := newQuoteDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if quote, err := reloaded.get(3); err != nil || quote != "<carol> third" {
+		t.Errorf("reloaded get(3) = %q, %v, want carol's quote", quote, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("quote directory holds %d files, want just the database", len(entries))
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reconnectLog collapses repeated IRC connection failures, so a server that
+// stays down produces a periodic summary rather than a log line per attempt.
+type reconnectLog struct {
+	every time.Duration // how often to summarise while failing
+
+	mu       sync.Mutex
+	attempts int
+	first    time.Time
+	last     time.Time // when the last line was logged
+	lastErr  string
+	reason   string // from the server's last ERROR line
+}
+
+func newReconnectLog(every time.Duration) *reconnectLog {
+	return &reconnectLog{every: every}
+}
+
+// failed records a failed connection attempt at now. The first failure, and
+// any failure with a different error, are logged in full; repeats are only
+// summarised once every r.every.
+func (r *reconnectLog) failed(err error, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	msg := err.Error()
+	if r.attempts == 0 || msg != r.lastErr {
+		r.attempts, r.first, r.last, r.lastErr = 1, now, now, msg
+		slog.Error("[IRC] Unable to connect, will keep retrying", slog.Any("err", err))
+		return
+	}
+
+	r.attempts++
+	if now.Sub(r.last) < r.every {
+		return
+	}
+
+	r.last = now
+	slog.Warn("[IRC] Still unable to connect", slog.String("err", msg), slog.Int("attempts", r.attempts), slog.Duration("over", now.Sub(r.first).Round(time.Second)))
+}
+
+// reset clears the failure run once connected, logging how long it lasted.
+func (r *reconnectLog) reset(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.attempts > 1 {
+		slog.Info("[IRC] Reconnected", slog.Int("attempts", r.attempts), slog.Duration("after", now.Sub(r.first).Round(time.Second)))
+	}
+
+	r.attempts = 0
+}
+
+// closed records the reason from an ERROR line sent by the server before it
+// closed the link, e.g. "Closing Link: SpawnBot (Excess Flood)".
+func (r *reconnectLog) closed(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reason = reason
+	slog.Warn("[IRC] Server closed the link", slog.String("reason", reason))
+}
+
+// backoff returns how long to wait before the next connection attempt, based
+// on the reason recorded by closed, which it then clears.
+func (r *reconnectLog) backoff() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	wait := reconnectBackoff(r.reason)
+	r.reason = ""
+
+	return wait
+}
+
+// reconnectBackoff maps the reason the server gave for closing the link to a
+// reconnect delay. Reconnecting quickly after being throttled or banned only
+// makes things worse.
+func reconnectBackoff(reason string) time.Duration {
+	reason = strings.ToLower(reason)
+	switch {
+	case strings.Contains(reason, "k-line"), strings.Contains(reason, "g-line"), strings.Contains(reason, "banned"):
+		return 15 * time.Minute
+	case strings.Contains(reason, "throttl"), strings.Contains(reason, "too many"), strings.Contains(reason, "flood"):
+		return 2 * time.Minute
+	default:
+		return 30 * time.Second
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLogs sends slog output to a buffer for the rest of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	return &buf
+}
+
+func TestReconnectLog(t *testing.T) {
+	logs := captureLogs(t)
+	refused := errors.New("dial tcp: connection refused")
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	r := newReconnectLog(5 * time.Minute)
+	lines := func() int { return strings.Count(logs.String(), "\n") }
+
+	// Only the first of a run of identical failures is logged straight away.
+	for i := range 10 {
+		r.failed(refused, start.Add(time.Duration(i)*30*time.Second))
+	}
+	if n := lines(); n != 1 {
+		t.Fatalf("10 failures in 5 minutes logged %d lines, want 1:\n%s", n, logs)
+	}
+
+	r.failed(refused, start.Add(5*time.Minute))
+	if n := lines(); n != 2 || !strings.Contains(logs.String(), "attempts=11 over=5m0s") {
+		t.Fatalf("no summary after 5 minutes:\n%s", logs)
+	}
+
+	// A different error is worth logging in full.
+	r.failed(errors.New("no such host"), start.Add(5*time.Minute+30*time.Second))
+	if n := lines(); n != 3 || !strings.Contains(logs.String(), "no such host") {
+		t.Fatalf("a new error wasn't logged:\n%s", logs)
+	}
+
+	r.failed(refused, start.Add(6*time.Minute))
+	r.failed(refused, start.Add(6*time.Minute+30*time.Second))
+	r.reset(start.Add(7 * time.Minute))
+	if !strings.Contains(logs.String(), `Reconnected" attempts=2 after=1m0s`) {
+		t.Fatalf("reset didn't log the reconnection:\n%s", logs)
+	}
+
+	// After a reset the next failure starts a fresh run.
+	logs.Reset()
+	r.failed(refused, start.Add(time.Hour))
+	if !strings.Contains(logs.String(), "Unable to connect") {
+		t.Errorf("first failure after reset wasn't logged in full:\n%s", logs)
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   time.Duration
+	}{
+		{"Closing Link: spawnbot (K-Lined)", 15 * time.Minute},
+		{"You are banned from this server", 15 * time.Minute},
+		{"G-line active", 15 * time.Minute},
+		{"Throttled: Reconnecting too fast", 2 * time.Minute},
+		{"Too many host connections (global)", 2 * time.Minute},
+		{"Excess Flood", 2 * time.Minute},
+		{"Ping timeout: 240 seconds", 30 * time.Second},
+		{"", 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := reconnectBackoff(tt.reason); got != tt.want {
+			t.Errorf("reconnectBackoff(%q) = %v, want %v", tt.reason, got, tt.want)
+		}
+	}
+
+	// The reason only applies to the next attempt.
+	captureLogs(t)
+	r := newReconnectLog(5 * time.Minute)
+	r.closed("Closing Link: SpawnBot (Excess Flood)")
+	if got := r.backoff(); got != 2*time.Minute {
+		t.Errorf("backoff() after a flood = %v, want 2m", got)
+	}
+	if got := r.backoff(); got != 30*time.Second {
+		t.Errorf("second backoff() = %v, want 30s", got)
+	}
+}
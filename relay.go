@@ -0,0 +1,81 @@
+package main
+
+import (
+	"spawnbot/cmdhandler"
+	"sync/atomic"
+	"time"
+)
+
+// Relay directions, as passed to the relay callbacks.
+const (
+	ircToDiscord = "irc->discord"
+	discordToIRC = "discord->irc"
+)
+
+// relay holds optional callbacks fired after each relay attempt in either
+// direction, so relay events can be fed to external logging or analytics
+// without touching the handlers. A nil relay, or nil callbacks, are no-ops.
+// It also carries the paused flag both relay handlers check, and counts
+// relayed messages in each direction for "!relaystats".
+type relay struct {
+	// paused suspends relaying in both directions, set by "!pause".
+	paused atomic.Bool
+
+	// clock times the counts, defaulting to cmdhandler.RealClock.
+	clock     cmdhandler.Clock
+	toDiscord relayCounter
+	toIRC     relayCounter
+
+	// OnRelay is called after a message from from is relayed.
+	OnRelay func(direction string, from, content string)
+	// OnRelayError is called when relaying a message fails.
+	OnRelayError func(direction string, err error)
+}
+
+// relayed counts the message, and fires OnRelay, if set.
+func (r *relay) relayed(direction, from, content string) {
+	if r == nil {
+		return
+	}
+
+	if c := r.counter(direction); c != nil {
+		c.add(r.now())
+	}
+
+	if r.OnRelay != nil {
+		r.OnRelay(direction, from, content)
+	}
+}
+
+// now returns the time by r.clock.
+func (r *relay) now() time.Time {
+	if r.clock == nil {
+		return time.Now()
+	}
+
+	return r.clock.Now()
+}
+
+// counter returns the relayCounter for direction.
+func (r *relay) counter(direction string) *relayCounter {
+	switch direction {
+	case ircToDiscord:
+		return &r.toDiscord
+	case discordToIRC:
+		return &r.toIRC
+	default:
+		return nil
+	}
+}
+
+// failed fires OnRelayError, if set.
+func (r *relay) failed(direction string, err error) {
+	if r != nil && r.OnRelayError != nil {
+		r.OnRelayError(direction, err)
+	}
+}
+
+// isPaused reports whether relaying is paused.
+func (r *relay) isPaused() bool {
+	return r != nil && r.paused.Load()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// relayWindow is how far back a relayCounter remembers, in minutes.
+const relayWindow = 24 * 60
+
+// relayCounter counts relayed messages per minute over the last day, in a
+// ring buffer indexed by minute.
+type relayCounter struct {
+	mu      sync.Mutex
+	counts  [relayWindow]uint64
+	minutes [relayWindow]int64 // the minute each slot counts, to spot stale ones
+}
+
+// add counts a message relayed at now.
+func (c *relayCounter) add(now time.Time) {
+	minute := now.Unix() / 60
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	slot := minute % relayWindow
+	if c.minutes[slot] != minute {
+		c.minutes[slot] = minute
+		c.counts[slot] = 0
+	}
+	c.counts[slot]++
+}
+
+// total returns how many messages were relayed in the window up to now,
+// rounded to whole minutes, and capped at a day.
+func (c *relayCounter) total(now time.Time, window time.Duration) uint64 {
+	minute := now.Unix() / 60
+	span := min(int64(window/time.Minute), relayWindow)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var total uint64
+	for i := range span {
+		m := minute - i
+		if slot := m % relayWindow; c.minutes[slot] == m {
+			total += c.counts[slot]
+		}
+	}
+
+	return total
+}
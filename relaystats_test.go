@@ -0,0 +1,63 @@
+package main
+
+import (
+	"spawnbot/internal/testutil"
+	"testing"
+	"time"
+)
+
+func TestRelayCounter(t *testing.T) {
+	clk := testutil.NewClock()
+	hooks := &relay{clock: clk}
+
+	// 3 messages now, 2 half an hour later, 1 more 5 hours after that.
+	for _, step := range []struct {
+		advance time.Duration
+		n       int
+	}{
+		{0, 3},
+		{30 * time.Minute, 2},
+		{5 * time.Hour, 1},
+	} {
+		clk.Advance(step.advance)
+		for range step.n {
+			hooks.relayed(ircToDiscord, "alice", "hi")
+		}
+	}
+	hooks.relayed(discordToIRC, "bob", "hello")
+
+	tests := []struct {
+		name   string
+		window time.Duration
+		want   uint64
+	}{
+		{"minute", time.Minute, 1},
+		{"hour", time.Hour, 1},
+		{"six hours", 6 * time.Hour, 6},
+		{"day", 24 * time.Hour, 6},
+		{"longer than a day", 48 * time.Hour, 6},
+	}
+
+	counter := hooks.counter(ircToDiscord)
+	for _, tt := range tests {
+		if got := counter.total(clk.Now(), tt.window); got != tt.want {
+			t.Errorf("total over the last %s = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	if got := hooks.counter(discordToIRC).total(clk.Now(), time.Minute); got != 1 {
+		t.Errorf("discord->irc total = %d, want 1", got)
+	}
+
+	// A day on, the ring has wrapped past every slot used.
+	clk.Advance(24 * time.Hour)
+	if got := counter.total(clk.Now(), 24*time.Hour); got != 0 {
+		t.Errorf("total a day later = %d, want 0", got)
+	}
+
+	// Slots are reused for the new day's messages, not added to stale counts.
+	hooks.relayed(ircToDiscord, "alice", "back")
+	if got := counter.total(clk.Now(), 24*time.Hour); got != 1 {
+		t.Errorf("total after reusing a slot = %d, want 1", got)
+	}
+}
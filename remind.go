@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"slices"
+	"spawnbot/cmdhandler"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lrstanley/girc"
+)
+
+// reminder is a message to deliver to nick once due, in target (a channel)
+// or, with an empty target, by private message.
+type reminder struct {
+	Due     time.Time `json:"due"`
+	Nick    string    `json:"nick"`
+	Target  string    `json:"target,omitempty"`
+	Message string    `json:"message"`
+}
+
+// text is the line delivering r, e.g. "bob: reminder: take the bread out".
+func (r reminder) text() string {
+	if r.Target == "" {
+		return "reminder: " + r.Message
+	}
+
+	return r.Nick + ": reminder: " + r.Message
+}
+
+// reminderDB holds the pending reminders behind !remind, optionally persisted
+// to a JSON file which is rewritten on each change so they survive a restart.
+type reminderDB struct {
+	path string // empty disables persistence
+	max  int    // pending reminders allowed per nick, 0 for no limit
+
+	mu      sync.Mutex
+	pending []reminder // sorted by Due
+}
+
+// errTooManyReminders is returned by add when the nick already has the most
+// reminders pending it's allowed.
+var errTooManyReminders = errors.New("too many reminders pending")
+
+// newReminderDB returns a reminder database allowing max pending reminders
+// per nick (0 for no limit), loading any reminders previously saved to path.
+func newReminderDB(path string, max int) (*reminderDB, error) {
+	db := &reminderDB{path: path, max: max}
+	if path == "" {
+		return db, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return db, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(data, &db.pending); err != nil {
+		return nil, err
+	}
+
+	sortReminders(db.pending)
+
+	return db, nil
+}
+
+// sortReminders sorts reminders by when they're due, earliest first.
+func sortReminders(reminders []reminder) {
+	slices.SortStableFunc(reminders, func(a, b reminder) int {
+		return a.Due.Compare(b.Due)
+	})
+}
+
+// add schedules r, unless r.Nick already has db.max reminders pending.
+func (db *reminderDB) add(r reminder) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.max > 0 {
+		nick := girc.ToRFC1459(r.Nick)
+		n := 0
+		for _, p := range db.pending {
+			if girc.ToRFC1459(p.Nick) == nick {
+				n++
+			}
+		}
+
+		if n >= db.max {
+			return errTooManyReminders
+		}
+	}
+
+	db.pending = append(db.pending, r)
+	sortReminders(db.pending)
+
+	return db.save()
+}
+
+// due removes and returns the reminders due at now. Reminders which were due
+// while the bot was down are returned as soon as it's back.
+func (db *reminderDB) due(now time.Time) ([]reminder, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	n := 0
+	for n < len(db.pending) && !db.pending[n].Due.After(now) {
+		n++
+	}
+
+	if n == 0 {
+		return nil, nil
+	}
+
+	due := slices.Clone(db.pending[:n])
+	db.pending = slices.Delete(db.pending, 0, n)
+
+	return due, db.save()
+}
+
+// run delivers reminders on c as they fall due, checking every interval by
+// clk. Reminders wait while c is disconnected. It never returns.
+func (db *reminderDB) run(c *girc.Client, clk cmdhandler.Clock, interval time.Duration) {
+	for {
+		now := <-clk.After(interval)
+		if !c.IsConnected() {
+			continue
+		}
+
+		due, err := db.due(now)
+		if err != nil {
+			slog.Error("[REMIND] Unable to save reminders", slog.Any("err", err))
+		}
+
+		for _, r := range due {
+			target := r.Target
+			if target == "" {
+				target = r.Nick
+			}
+
+			c.Cmd.Message(target, sanitizeIRC(r.text()))
+		}
+	}
+}
+
+// save persists the reminders to db.path, if set. db.mu must be held by the
+// caller.
+func (db *reminderDB) save() error {
+	if db.path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(db.pending, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(db.path, data, 0o644)
+}
+
+// parseReminderDelay parses the delay of a !remind, a Go duration such as
+// "90m" or "1h30m", or a number of days such as "2d", which must be positive
+// and at most max.
+func parseReminderDelay(s string, max time.Duration) (time.Duration, error) {
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid delay %q (req: e.g. 10m, 2h30m or 3d)", s)
+		}
+		if time.Duration(n) > max/(24*time.Hour) {
+			return 0, fmt.Errorf("delay can be at most %s", max)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, fmt.Errorf("invalid delay %q (req: e.g. 10m, 2h30m or 3d)", s)
+		}
+	}
+
+	if d <= 0 {
+		return 0, errors.New("delay must be positive")
+	}
+
+	if d > max {
+		return 0, fmt.Errorf("delay can be at most %s", max)
+	}
+
+	return d, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"spawnbot/internal/testutil"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+)
+
+func TestParseReminderDelay(t *testing.T) {
+	const max = 30 * 24 * time.Hour
+
+	tests := []struct {
+		delay   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"10m", 10 * time.Minute, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"3d", 3 * 24 * time.Hour, false},
+		{"30d", max, false},
+		{"31d", 0, true},
+		{"721h", 0, true},
+		{"0m", 0, true},
+		{"-5m", 0, true},
+		{"-1d", 0, true},
+		{"soon", 0, true},
+		{"d", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseReminderDelay(tt.delay, max)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseReminderDelay(%q) = %v, %v, want %v (error %v)", tt.delay, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestReminderDBMaxPending(t *testing.T) {
+	db, err := newReminderDB("", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	due := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	steps := []struct {
+		nick string
+		want error
+	}{
+		{"alice", nil},
+		{"Alice", nil},
+		// Nicks are compared case-insensitively.
+		{"ALICE", errTooManyReminders},
+		{"bob", nil},
+	}
+
+	for _, step := range steps {
+		if err := db.add(reminder{Due: due, Nick: step.nick, Message: "hi"}); !errors.Is(err, step.want) {
+			t.Errorf("add(%s) = %v, want %v", step.nick, err, step.want)
+		}
+	}
+
+	// Delivered reminders no longer count.
+	if _, err := db.due(due); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.add(reminder{Due: due, Nick: "alice", Message: "hi"}); err != nil {
+		t.Errorf("add(alice) after delivery = %v, want nil", err)
+	}
+}
+
+func TestReminderDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reminders.json")
+	db, err := newReminderDB(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, r := range []reminder{
+		{Due: start.Add(2 * time.Hour), Nick: "carol", Message: "third"},
+		{Due: start.Add(time.Hour), Nick: "alice", Target: "#spawn", Message: "first"},
+		{Due: start.Add(time.Hour), Nick: "bob", Message: "second"},
+	} {
+		if err := db.add(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if due, err := db.due(start); err != nil || len(due) != 0 {
+		t.Errorf("due(start) = %v, %v, want none", due, err)
+	}
+
+	// Reloading keeps the pending reminders in order.
+	if db, err = newReminderDB(path, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	due, err := db.due(start.Add(90 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, r := range due {
+		got = append(got, r.text())
+	}
+
+	want := []string{"alice: reminder: first", "reminder: second"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("due = %q, want %q", got, want)
+	}
+
+	// Delivered reminders are gone after a reload too.
+	if db, err = newReminderDB(path, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if due, err = db.due(start.Add(3 * time.Hour)); err != nil || len(due) != 1 || due[0].Message != "third" {
+		t.Errorf("due after reload = %v, %v, want only third", due, err)
+	}
+}
+
+func TestReminderRun(t *testing.T) {
+	fake := testutil.NewCommander(t)
+	clk := testutil.NewClock()
+	db, err := newReminderDB("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = db.add(reminder{Due: clk.Now().Add(time.Minute), Nick: "bob", Target: "#chan", Message: "take the bread out"}); err != nil {
+		t.Fatal(err)
+	}
+
+	go db.run(fake.Client, clk, time.Minute)
+
+	if !clk.WaitForWaiters(1) {
+		t.Fatal("run isn't waiting on the clock")
+	}
+	clk.Advance(time.Minute)
+
+	for {
+		select {
+		case e := <-fake.Sent():
+			if e.Command == girc.PONG {
+				continue
+			}
+			if e.String() != "PRIVMSG #chan :bob: reminder: take the bread out" {
+				t.Errorf("sent %q, want the reminder", e.String())
+			}
+		case <-time.After(time.Second):
+			t.Fatal("reminder never delivered")
+		}
+		return
+	}
+}
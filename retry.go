@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"spawnbot/cmdhandler"
+	"time"
+
+	"github.com/disgoorg/disgo/rest"
+)
+
+// retryable reports whether a failed Discord REST call is worth retrying.
+// Server errors (5xx) and network failures are transient; client errors (4xx)
+// will fail the same way again.
+func retryable(err error) bool {
+	var rest_err rest.Error
+	if errors.As(err, &rest_err) && rest_err.Response != nil {
+		return rest_err.Response.StatusCode >= 500
+	}
+
+	return true
+}
+
+// errInvalidFormBody is Discord's JSON error code for a request which failed
+// validation, such as content over the length limit.
+const errInvalidFormBody = 50035
+
+// tooLong reports whether err is Discord rejecting a message for exceeding
+// the content length limit.
+func tooLong(err error) bool {
+	var rest_err rest.Error
+	if !errors.As(err, &rest_err) || rest_err.Code != errInvalidFormBody {
+		return false
+	}
+
+	return bytes.Contains(rest_err.Errors, []byte("BASE_TYPE_MAX_LENGTH"))
+}
+
+// withRetry calls send, retrying up to retries more times while it fails with
+// a retryable error. The wait between attempts, timed by clk, starts at
+// backoff and doubles each time.
+func withRetry(clk cmdhandler.Clock, retries int, backoff time.Duration, send func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := send()
+		if err == nil || !retryable(err) || attempt >= retries {
+			return err
+		}
+
+		slog.Warn("[DISCORD] Request failed, retrying", slog.Any("err", err), slog.Int("attempt", attempt+1), slog.Duration("backoff", backoff))
+		<-clk.After(backoff)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"spawnbot/cmdhandler"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/disgo/rest"
+)
+
+func TestWithRetry(t *testing.T) {
+	network := errors.New("connection reset")
+	server := rest.Error{Response: &http.Response{StatusCode: http.StatusBadGateway}}
+	client := rest.Error{Response: &http.Response{StatusCode: http.StatusForbidden}}
+
+	tests := []struct {
+		name     string
+		retries  int
+		errs     []error // returned by each attempt in turn, then nil
+		attempts int
+		wantErr  bool
+	}{
+		{"fails twice then succeeds", 2, []error{network, server}, 3, false},
+		{"gives up", 1, []error{network, server}, 2, true},
+		{"client error isn't retried", 2, []error{client}, 1, true},
+		{"first try", 2, nil, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := withRetry(cmdhandler.RealClock{}, tt.retries, time.Millisecond, func() error {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+
+				return nil
+			})
+
+			if attempts != tt.attempts || (err != nil) != tt.wantErr {
+				t.Errorf("withRetry() made %d attempts, returned %v, want %d attempts (error %v)", attempts, err, tt.attempts, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Bounds on !roll expressions, so a single roll can't flood the channel or
+// tie up the bot.
+const (
+	maxDice  = 20
+	maxSides = 1000
+)
+
+// parseDice parses a dice expression of the form "NdM" (e.g. "2d6"), where N
+// may be omitted to roll a single die ("d20"). An empty expression means 1d6.
+func parseDice(expr string) (count, sides int, err error) {
+	expr = strings.ToLower(strings.TrimSpace(expr))
+	if expr == "" {
+		return 1, 6, nil
+	}
+
+	n, m, ok := strings.Cut(expr, "d")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid dice %q (req: NdM, e.g. 2d6)", expr)
+	}
+
+	count = 1
+	if n != "" {
+		if count, err = strconv.Atoi(n); err != nil {
+			return 0, 0, fmt.Errorf("invalid dice count %q", n)
+		}
+	}
+
+	if sides, err = strconv.Atoi(m); err != nil {
+		return 0, 0, fmt.Errorf("invalid dice sides %q", m)
+	}
+
+	if count < 1 || count > maxDice {
+		return 0, 0, fmt.Errorf("dice count must be between 1 and %d", maxDice)
+	}
+
+	if sides < 2 || sides > maxSides {
+		return 0, 0, fmt.Errorf("dice sides must be between 2 and %d", maxSides)
+	}
+
+	return count, sides, nil
+}
+
+// rollDice rolls count dice with the given number of sides, returning the
+// individual rolls and their total.
+func rollDice(count, sides int) (rolls []int, total int) {
+	rolls = make([]int, count)
+	for i := range rolls {
+		rolls[i] = rand.Intn(sides) + 1
+		total += rolls[i]
+	}
+
+	return rolls, total
+}
@@ -0,0 +1,53 @@
+package main
+
+import "testing"
+
+func TestParseDice(t *testing.T) {
+	tests := []struct {
+		expr         string
+		count, sides int
+		wantErr      bool
+	}{
+		{"", 1, 6, false},
+		{"2d6", 2, 6, false},
+		{"d20", 1, 20, false},
+		{" 3D8 ", 3, 8, false},
+		{"20d1000", 20, 1000, false},
+		{"21d6", 0, 0, true},
+		{"1000d1000", 0, 0, true},
+		{"0d6", 0, 0, true},
+		{"1d1", 0, 0, true},
+		{"1d1001", 0, 0, true},
+		{"2x6", 0, 0, true},
+		{"ad6", 0, 0, true},
+		{"2d", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		count, sides, err := parseDice(tt.expr)
+		if (err != nil) != tt.wantErr || count != tt.count || sides != tt.sides {
+			t.Errorf("parseDice(%q) = %d, %d, %v, want %d, %d (error %v)", tt.expr, count, sides, err, tt.count, tt.sides, tt.wantErr)
+		}
+	}
+}
+
+func TestRollDice(t *testing.T) {
+	for range 100 {
+		rolls, total := rollDice(3, 6)
+		if len(rolls) != 3 {
+			t.Fatalf("rollDice(3, 6) rolled %d dice, want 3", len(rolls))
+		}
+
+		sum := 0
+		for _, roll := range rolls {
+			if roll < 1 || roll > 6 {
+				t.Fatalf("rollDice(3, 6) rolled %d", roll)
+			}
+			sum += roll
+		}
+
+		if sum != total {
+			t.Fatalf("rollDice(3, 6) = %v, total %d, want total %d", rolls, total, sum)
+		}
+	}
+}
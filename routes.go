@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"spawnbot/cmdhandler"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/lrstanley/girc"
+)
+
+// channelMap pairs bridged IRC channels with their Discord channels. It
+// starts with the static #spawn bridge, and grows as channels are discovered
+// at runtime.
+type channelMap struct {
+	mu        sync.RWMutex
+	toDiscord map[string]snowflake.ID // keyed by RFC1459-lowered channel
+	toIRC     map[snowflake.ID]string
+}
+
+func newChannelMap() *channelMap {
+	return &channelMap{toDiscord: make(map[string]snowflake.ID), toIRC: make(map[snowflake.ID]string)}
+}
+
+// add bridges the IRC channel with the Discord channel id.
+func (m *channelMap) add(channel string, id snowflake.ID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.toDiscord[girc.ToRFC1459(channel)] = id
+	m.toIRC[id] = channel
+}
+
+// discord returns the Discord channel bridged with the IRC channel.
+func (m *channelMap) discord(channel string) (snowflake.ID, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	id, ok := m.toDiscord[girc.ToRFC1459(channel)]
+	return id, ok
+}
+
+// irc returns the IRC channel bridged with the Discord channel id.
+func (m *channelMap) irc(id snowflake.ID) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	channel, ok := m.toIRC[id]
+	return channel, ok
+}
+
+// discordNameInvalid matches runs of characters Discord doesn't allow in
+// text channel names.
+var discordNameInvalid = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// discordChannelName derives the name of the Discord channel an IRC channel
+// is bridged to by convention, e.g. "#Spawn.Dev" becomes "spawn-dev".
+func discordChannelName(channel string) string {
+	name := strings.ToLower(strings.TrimLeft(channel, "#&+!"))
+	return strings.Trim(discordNameInvalid.ReplaceAllString(name, "-"), "-")
+}
+
+// channelDiscovery bridges IRC channels matching pattern as they're found,
+// pairing each with the Discord channel named after it by
+// discordChannelName, in the same guild as #spawn.
+type channelDiscovery struct {
+	logger     *slog.Logger
+	irc_client *girc.Client
+	clock      cmdhandler.Clock
+	dis_client bot.Client
+	routes     *channelMap
+	pattern    string
+	join_delay time.Duration
+
+	mu     sync.Mutex
+	listed []string // matching channels seen in the LIST in progress
+}
+
+// registerChannelDiscovery LISTs the server's channels once the bot first
+// joins a channel after connecting (so after any AUTH), then again every
+// interval by clk, bridging those matching pattern. Everything is logged to
+// logger. This must only be called once per client.
+func registerChannelDiscovery(logger *slog.Logger, irc_client *girc.Client, clk cmdhandler.Clock, dis_client bot.Client, routes *channelMap, pattern string, interval, join_delay time.Duration) *channelDiscovery {
+	d := &channelDiscovery{logger: logger, irc_client: irc_client, clock: clk, dis_client: dis_client, routes: routes, pattern: pattern, join_delay: join_delay}
+
+	var listed bool
+	irc_client.Handlers.Add(girc.CONNECTED, func(c *girc.Client, e girc.Event) {
+		d.mu.Lock()
+		listed = false
+		d.mu.Unlock()
+	})
+
+	irc_client.Handlers.Add(girc.JOIN, func(c *girc.Client, e girc.Event) {
+		if e.Source == nil || e.Source.Name != c.GetNick() {
+			return
+		}
+
+		d.mu.Lock()
+		first := !listed
+		listed = true
+		d.mu.Unlock()
+
+		if first {
+			c.Cmd.List()
+		}
+	})
+
+	irc_client.Handlers.Add(girc.RPL_LIST, func(c *girc.Client, e girc.Event) {
+		if len(e.Params) < 2 || !d.matches(e.Params[1]) {
+			return
+		}
+
+		d.mu.Lock()
+		d.listed = append(d.listed, e.Params[1])
+		d.mu.Unlock()
+	})
+
+	irc_client.Handlers.Add(girc.RPL_LISTEND, func(c *girc.Client, e girc.Event) {
+		d.mu.Lock()
+		channels := d.listed
+		d.listed = nil
+		d.mu.Unlock()
+
+		go d.bridge(channels...)
+	})
+
+	go func() {
+		for {
+			<-clk.After(interval)
+			if irc_client.IsConnected() {
+				irc_client.Cmd.List()
+			}
+		}
+	}()
+
+	return d
+}
+
+// matches reports whether channel matches the discovery pattern.
+func (d *channelDiscovery) matches(channel string) bool {
+	return girc.Glob(girc.ToRFC1459(channel), girc.ToRFC1459(d.pattern))
+}
+
+// bridge pairs each channel not yet bridged with its Discord channel and
+// joins it. Channels without a Discord counterpart are skipped.
+func (d *channelDiscovery) bridge(channels ...string) {
+	joinChannels(d.logger, d.irc_client, d.clock, d.pair(channels...), d.join_delay)
+}
+
+// pair pairs each channel not yet bridged with its Discord channel, returning
+// those newly paired. Channels without a Discord counterpart are skipped.
+func (d *channelDiscovery) pair(channels ...string) []string {
+	var pending []string
+	for _, channel := range channels {
+		if _, ok := d.routes.discord(channel); !ok {
+			pending = append(pending, channel)
+		}
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	named, err := d.discordChannels()
+	if err != nil {
+		d.logger.Error("[DISCORD] Unable to list channels for discovery", slog.Any("err", err))
+		return nil
+	}
+
+	var paired []string
+	for _, channel := range pending {
+		id, ok := named[discordChannelName(channel)]
+		if !ok {
+			d.logger.Debug("[IRC] No Discord channel for discovered channel", slog.String("channel", channel), slog.String("name", discordChannelName(channel)))
+			continue
+		}
+
+		d.logger.Info("[IRC] Bridging discovered channel", slog.String("channel", channel), slog.String("discord", id.String()))
+		d.routes.add(channel, id)
+		paired = append(paired, channel)
+	}
+
+	return paired
+}
+
+// discordChannels returns the text channels of the guild #spawn is in, keyed
+// by name.
+func (d *channelDiscovery) discordChannels() (map[string]snowflake.ID, error) {
+	spawn, err := d.dis_client.Rest().GetChannel(SPAWN_CHAN_ID)
+	if err != nil {
+		return nil, err
+	}
+
+	guild_channel, ok := spawn.(discord.GuildChannel)
+	if !ok {
+		return nil, fmt.Errorf("channel %s isn't in a guild", SPAWN_CHAN_ID)
+	}
+
+	channels, err := d.dis_client.Rest().GetGuildChannels(guild_channel.GuildID())
+	if err != nil {
+		return nil, err
+	}
+
+	named := make(map[string]snowflake.ID, len(channels))
+	for _, channel := range channels {
+		if channel.Type() == discord.ChannelTypeGuildText {
+			named[channel.Name()] = channel.ID()
+		}
+	}
+
+	return named, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log/slog"
+	"spawnbot/cmdhandler"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+)
+
+func TestDiscordChannelName(t *testing.T) {
+	tests := []struct {
+		channel string
+		want    string
+	}{
+		{"#spawn", "spawn"},
+		{"#Spawn.Dev", "spawn-dev"},
+		{"##go-nuts", "go-nuts"},
+		{"&local_chat", "local_chat"},
+		{"#c++", "c"},
+		{"#spawn[ops]", "spawn-ops"},
+		{"#--weird--", "weird"},
+	}
+
+	for _, tt := range tests {
+		if got := discordChannelName(tt.channel); got != tt.want {
+			t.Errorf("discordChannelName(%q) = %q, want %q", tt.channel, got, tt.want)
+		}
+	}
+}
+
+func TestChannelDiscoveryMatches(t *testing.T) {
+	d := &channelDiscovery{pattern: "#Spawn-*"}
+
+	tests := []struct {
+		channel string
+		want    bool
+	}{
+		{"#spawn-dev", true},
+		{"#SPAWN-ops", true},
+		{"#spawn-", true},
+		{"#spawn", false},
+		{"#spawnbot", false},
+		{"#other-spawn-dev", false},
+	}
+
+	for _, tt := range tests {
+		if got := d.matches(tt.channel); got != tt.want {
+			t.Errorf("matches(%q) with pattern %q = %v, want %v", tt.channel, d.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestChannelMap(t *testing.T) {
+	routes := spawnRoutes()
+	routes.add("#Spawn-Dev", 42)
+
+	if id, ok := routes.discord("#SPAWN-dev"); !ok || id != 42 {
+		t.Errorf("discord(#SPAWN-dev) = %v, %v, want 42", id, ok)
+	}
+	if channel, ok := routes.irc(42); !ok || channel != "#Spawn-Dev" {
+		t.Errorf("irc(42) = %q, %v, want #Spawn-Dev", channel, ok)
+	}
+	if id, ok := routes.discord("#spawn"); !ok || id != SPAWN_CHAN_ID {
+		t.Errorf("discord(#spawn) = %v, %v, want the #spawn channel", id, ok)
+	}
+	if _, ok := routes.discord("#elsewhere"); ok {
+		t.Error("an unbridged channel has a route")
+	}
+}
+
+func TestRelayRoutes(t *testing.T) {
+	routes := spawnRoutes()
+	routes.add("#spawn-dev", 42)
+
+	dis_client, created := fakeDiscord(t)
+	irc_client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "SpawnBot"})
+	cmdHandler, _ := cmdhandler.New("!")
+	ignored, _ := newIgnoreList("", nil)
+	registerIRCHandlers(slog.Default(), irc_client, cmdhandler.RealClock{}, cmdHandler, newDiscordSender(dis_client, cmdhandler.RealClock{}, 0, ""), routes, newOutbox("DISCORD", 10), nil, nil, ignored, nil, nil, newMessageCache(10), nil)
+
+	irc_client.RunHandlers(girc.ParseEvent(":alice!a@example.org PRIVMSG #elsewhere :not bridged"))
+	irc_client.RunHandlers(girc.ParseEvent(":alice!a@example.org PRIVMSG #spawn-dev :hello dev"))
+
+	select {
+	case message := <-created:
+		if message.channel != 42 || message.Content != "[IRC] alice: hello dev" {
+			t.Errorf("relayed %q to %d, want the message in channel 42", message.Content, message.channel)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nothing relayed")
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// stripControl removes CR, LF and any other control characters from s. This
+// prevents user-supplied text from smuggling extra raw lines (e.g.
+// "\r\nJOIN #evil") into commands sent to the IRC server.
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+
+		return r
+	}, s)
+}
+
+// ircUnsafe replaces the characters which would terminate or corrupt an IRC
+// line: CR and LF become spaces, and NUL is dropped.
+var ircUnsafe = strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ", "\x00", "")
+
+// sanitizeIRC makes s safe to send as the text of a single IRC message, so
+// relayed or user-supplied content can't inject a second raw IRC command.
+// Unlike stripControl, IRC formatting codes (bold, colour etc.) are kept.
+func sanitizeIRC(s string) string {
+	return ircUnsafe.Replace(s)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lrstanley/girc"
+)
+
+func TestStripControl(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"hello world", "hello world"},
+		{"hi\r\nJOIN #evil", "hiJOIN #evil"},
+		{"hi\nPRIVMSG #spawn :pwned", "hiPRIVMSG #spawn :pwned"},
+		{"\x01ACTION waves\x01", "ACTION waves"},
+		{"tab\there", "tabhere"},
+		{"héllo ☃", "héllo ☃"},
+	}
+
+	for _, tt := range tests {
+		got := stripControl(tt.in)
+		if got != tt.want {
+			t.Errorf("stripControl(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if strings.ContainsAny(got, "\r\n") {
+			t.Errorf("stripControl(%q) left a line break in %q", tt.in, got)
+		}
+	}
+}
+
+func TestSanitizeIRC(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"hello", "hello"},
+		{"hi\r\nJOIN #evil", "hi JOIN #evil"},
+		{"hi\nQUIT :bye", "hi QUIT :bye"},
+		{"hi\rMODE #spawn +o mallory", "hi MODE #spawn +o mallory"},
+		{"nul\x00byte", "nulbyte"},
+		// Formatting codes survive.
+		{"\x02bold\x02 \x0304red", "\x02bold\x02 \x0304red"},
+	}
+
+	for _, tt := range tests {
+		if got := sanitizeIRC(tt.in); got != tt.want {
+			t.Errorf("sanitizeIRC(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	// On the wire, the relayed text stays within the one PRIVMSG.
+	wire := "PRIVMSG #spawn :" + sanitizeIRC("[DISCORD] mallory: hi\r\nJOIN #evil\nPRIVMSG NickServ :DROP") + "\r\n"
+	lines := strings.Split(strings.TrimSuffix(wire, "\r\n"), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("wire data holds %d lines, want 1: %q", len(lines), wire)
+	}
+	if e := girc.ParseEvent(lines[0]); e == nil || e.Command != girc.PRIVMSG || e.Params[0] != "#spawn" {
+		t.Errorf("wire data parsed as %v, want a single PRIVMSG to #spawn", e)
+	}
+}
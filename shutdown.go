@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/lrstanley/girc"
+)
+
+// shutdown flushes the outboxes, bounded by SPAWNBOT_SHUTDOWN_TIMEOUT
+// (default 5s) across all of them, then closes both connections and exits.
+// dis_client may be nil when running IRC-only.
+func shutdown(irc_client *girc.Client, dis_client bot.Client, reason string, outboxes ...*outbox) {
+	disconnect(irc_client, dis_client, reason, outboxes...)
+	os.Exit(0)
+}
+
+// restartRequested is set by restart, so that once the IRC connection closes
+// main re-executes the bot instead of returning.
+var restartRequested atomic.Bool
+
+// restart shuts down as shutdown does, but flags main to re-execute the bot
+// with its original arguments and environment (see reexec) rather than exit.
+// It returns an error, still connected, if the executable can't be found.
+func restart(irc_client *girc.Client, dis_client bot.Client, reason string, outboxes ...*outbox) error {
+	if _, _, _, err := restartCommand(os.Args, os.Environ()); err != nil {
+		return err
+	}
+
+	restartRequested.Store(true)
+	disconnect(irc_client, dis_client, reason, outboxes...)
+	return nil
+}
+
+// reexec replaces the process with a fresh copy of the bot. Should that fail,
+// it exits non-zero so a supervisor can start it again instead. It never
+// returns.
+func reexec() {
+	path, args, env, err := restartCommand(os.Args, os.Environ())
+	if err == nil {
+		slog.Info("[SHUTDOWN] Restarting", slog.String("path", path), slog.Any("args", args))
+		err = syscall.Exec(path, args, env)
+	}
+
+	slog.Error("[SHUTDOWN] Unable to restart, exiting", slog.Any("err", err))
+	os.Exit(1)
+}
+
+// restartCommand returns the path, arguments and environment to re-execute
+// the running bot with, given its args and env. The arguments always start
+// with the program name, which exec needs even if args is empty.
+func restartCommand(args, env []string) (string, []string, []string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	if len(args) == 0 {
+		args = []string{path}
+	}
+
+	return path, args, env, nil
+}
+
+// disconnect flushes the outboxes and closes both connections.
+func disconnect(irc_client *girc.Client, dis_client bot.Client, reason string, outboxes ...*outbox) {
+	timeout := envDuration("SPAWNBOT_SHUTDOWN_TIMEOUT", 5*time.Second)
+	deadline := time.Now().Add(timeout)
+
+	slog.Info("[SHUTDOWN] Shutting down", slog.String("reason", reason), slog.Duration("timeout", timeout))
+
+	for _, o := range outboxes {
+		drained, dropped := o.drain(time.Until(deadline))
+		slog.Info("[SHUTDOWN] Drained outbound queue", slog.String("queue", o.name), slog.Int("drained", drained), slog.Int("dropped", dropped))
+	}
+
+	if dis_client != nil {
+		dis_client.Close(context.TODO())
+	}
+
+	irc_client.Quit(reason)
+	time.Sleep(time.Second)
+}
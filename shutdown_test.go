@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestRestartCommand(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		env      []string
+		wantArgs []string
+	}{
+		{"original args", []string{"spawnbot", "--check"}, []string{"A=1"}, []string{"spawnbot", "--check"}},
+		{"no args", nil, []string{"A=1"}, []string{exe}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, args, env, err := restartCommand(tt.args, tt.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if path != exe {
+				t.Errorf("path = %q, want %q", path, exe)
+			}
+			if !slices.Equal(args, tt.wantArgs) {
+				t.Errorf("args = %q, want %q", args, tt.wantArgs)
+			}
+			if !slices.Equal(env, tt.env) {
+				t.Errorf("env = %q, want %q", env, tt.env)
+			}
+		})
+	}
+}
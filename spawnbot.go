@@ -2,18 +2,22 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log/slog"
+	"math/rand"
 	"os"
 	"spawnbot/cmdhandler"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/disgoorg/disgo"
 	"github.com/disgoorg/disgo/bot"
-	"github.com/disgoorg/disgo/discord"
-	"github.com/disgoorg/disgo/events"
 	"github.com/disgoorg/disgo/gateway"
+	"github.com/disgoorg/disgo/handlers"
 	"github.com/disgoorg/snowflake/v2"
 	"github.com/lrstanley/girc"
 )
@@ -25,7 +29,25 @@ const BRINE_CHAN_ID = snowflake.ID(1226973379303706768)
 // The Spawn : #spawn
 const SPAWN_CHAN_ID = snowflake.ID(482513037530497025)
 
+// IRC_SERVER is the IRC network the bridge connects to.
+const IRC_SERVER = "irc.quakenet.org"
+
 func main() {
+	check := flag.Bool("check", false, "validate the configuration and exit, without starting the bridge")
+	flag.Parse()
+
+	// --check (or SPAWNBOT_CHECK=true) is for CI and deploys: exit non-zero if
+	// the bridge would fail to start.
+	if *check || envBool("SPAWNBOT_CHECK", false) {
+		if err := checkConfig(IRC_SERVER); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		fmt.Println("config ok")
+		os.Exit(0)
+	}
+
 	// =============================================================================================
 	//   /###### /#######   /######
 	//  |_  ##_/| ##__  ## /##__  ##
@@ -36,36 +58,496 @@ func main() {
 	//   /######| ##  | ##|  ######/
 	//  |______/|__/  |__/ \______/
 	// =============================================================================================
-	irc_client := girc.New(girc.Config{
-		Server: "irc.quakenet.org",
-		Port:   6667,
-		Nick:   "SpawnBot",
-		User:   "SpawnBot",
-		Name:   "SpawnBot",
-		// Debug:  os.Stdout,
-	})
+	// Extra IRCv3 capabilities to request, e.g. "echo-message,server-time".
+	caps, caps_err := parseCaps(envList("SPAWNBOT_IRC_CAPS"))
 
-	irc_client.Handlers.Add(girc.CONNECTED, func(c *girc.Client, e girc.Event) {
-		c.Cmd.Message("q@CServe.quakenet.org", fmt.Sprintf("AUTH SpawnBot %s", os.Getenv("QNET_AUTH")))
-		c.Cmd.Mode("SpawnBot", "+x")
-		time.Sleep(time.Second)
-		c.Cmd.Join("#spawn")
-		// c.Cmd.Join("#spawnbot")
-		// slog.Info("[IRC] Connected to " + c.Config.Server)
-	})
+	if caps_err != nil {
+		panic(caps_err)
+	}
+
+	irc_config := girc.Config{
+		Server:        IRC_SERVER,
+		Port:          6667,
+		Nick:          "SpawnBot",
+		User:          "SpawnBot",
+		Name:          "SpawnBot",
+		SupportedCaps: caps,
+	}
 
-	cmdHandler, cmd_err := cmdhandler.New("!")
+	// With a client certificate in SPAWNBOT_IRC_CERT and SPAWNBOT_IRC_KEY, the
+	// bot connects over TLS on SPAWNBOT_IRC_TLS_PORT and authenticates with
+	// SASL EXTERNAL.
+	irc_tls, tls_err := loadClientCert(os.Getenv("SPAWNBOT_IRC_CERT"), os.Getenv("SPAWNBOT_IRC_KEY"), IRC_SERVER)
+
+	if tls_err != nil {
+		panic(tls_err)
+	}
+
+	if irc_tls != nil {
+		irc_config.SSL = true
+		irc_config.TLSConfig = irc_tls
+		irc_config.Port = envInt("SPAWNBOT_IRC_TLS_PORT", 6697)
+		irc_config.SASL = &girc.SASLExternal{}
+	}
+
+	// SPAWNBOT_IRC_DEBUG=true logs girc's raw lines through slog at debug level.
+	if envBool("SPAWNBOT_IRC_DEBUG", false) {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+		irc_config.Debug = slogWriter{prefix: "[IRC]"}
+	}
+
+	irc_client := girc.New(irc_config)
+	// The server's RPL_ISUPPORT limits, e.g. LINELEN, for sizing relayed lines.
+	support := registerServerSupport(irc_client)
+
+	// Time source for cooldowns, rate limits, retries, alert debouncing,
+	// away-idle tracking and reconnect backoff.
+	var clk cmdhandler.Clock = cmdhandler.RealClock{}
+
+	// IRC commands start with SPAWNBOT_IRC_PREFIX, "!" by default. Discord
+	// has its own, SPAWNBOT_DISCORD_PREFIX.
+	irc_prefix := envPrefix("SPAWNBOT_IRC_PREFIX")
+	cmdHandler, cmd_err := cmdhandler.NewWithOptions(cmdhandler.WithPrefix(irc_prefix), cmdhandler.WithClock(clk))
 
 	if cmd_err != nil {
 		panic(cmd_err)
 	}
 
-	cmdHandler.Add(&cmdhandler.Command{
-		Name:    "ping",
-		Help:    "Sends a pong reply back to the source.",
+	cmdHandler.SetAdmins(envList("SPAWNBOT_ADMINS")...)
+	// Locked-down deployments can list the only commands which may run.
+	cmdHandler.SetAllowlist(envList("SPAWNBOT_COMMAND_ALLOWLIST"))
+
+	// Command timings are always collected, but only served (at /metrics) when
+	// SPAWNBOT_METRICS_ADDR is set, e.g. ":9090".
+	stats := newMetrics()
+	cmdHandler.SetObserver(stats.observeCommand)
+
+	// Unknown commands are always counted, but only logged 5 times a minute so
+	// someone spamming "!garbage" can't flood the logs.
+	cmdHandler.SetNotFound(stats.notFound(newRelayLimiter(clk, 5, time.Minute)))
+
+	if addr := os.Getenv("SPAWNBOT_METRICS_ADDR"); addr != "" {
+		go stats.serve(addr)
+	}
+
+	addCommand := func(cmd *cmdhandler.Command) {
+		if err := cmdHandler.Add(cmd); err != nil {
+			panic(err)
+		}
+	}
+
+	addCommand(&cmdhandler.Command{
+		Name:            "ping",
+		Help:            "Sends a pong reply back to the source.",
+		MinArgs:         0,
+		BridgeResponses: true,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			input.Reply("pong!")
+		},
+	})
+
+	addCommand(&cmdhandler.Command{
+		Name:    "source",
+		Help:    "Links to the bot's source code.",
+		MinArgs: 0,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			c.Cmd.Reply(*input.Origin, sanitizeIRC(aboutReply()))
+		},
+	})
+
+	addCommand(&cmdhandler.Command{
+		Name:    "id",
+		Help:    "Reports the channel and the bot's hostmask, for setting up the bridge.",
+		MinArgs: 0,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			where := "private message"
+			if input.Origin.IsFromChannel() {
+				where = input.Origin.Params[0]
+			}
+
+			c.Cmd.Replyf(*input.Origin, "channel: %s, my hostmask: %s!%s@%s", where, c.GetNick(), c.GetIdent(), c.GetHost())
+		},
+	})
+
+	addCommand(&cmdhandler.Command{
+		Name:     "roll",
+		Category: "Fun",
+		Help:     "[NdM] -- Rolls N dice with M sides, e.g. 2d6. Defaults to 1d6.",
+		MinArgs:  0,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			count, sides, err := parseDice(input.RawArgs)
+			if err != nil {
+				c.Cmd.Reply(*input.Origin, sanitizeIRC(err.Error()))
+				return
+			}
+
+			rolls, total := rollDice(count, sides)
+			if count == 1 {
+				c.Cmd.Replyf(*input.Origin, "rolled %dd%d: %d", count, sides, total)
+				return
+			}
+
+			parts := make([]string, len(rolls))
+			for i, roll := range rolls {
+				parts[i] = strconv.Itoa(roll)
+			}
+
+			c.Cmd.Replyf(*input.Origin, "rolled %dd%d: %d (%s)", count, sides, total, strings.Join(parts, " + "))
+		},
+	})
+
+	addCommand(&cmdhandler.Command{
+		Name:    "calc",
+		Help:    "<expr> -- Evaluates an arithmetic expression, e.g. (1 + 2) * 3 ^ 2. Supports + - * / % ^ and parentheses.",
+		MinArgs: 1,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			result, err := calc(input.RawArgs)
+			if err != nil {
+				c.Cmd.Reply(*input.Origin, sanitizeIRC(err.Error()))
+				return
+			}
+
+			c.Cmd.Replyf(*input.Origin, "%s = %s", sanitizeIRC(input.RawArgs), strconv.FormatFloat(result, 'g', 12, 64))
+		},
+	})
+
+	// !weather is only available with an OpenWeatherMap API key.
+	if provider := newOpenWeatherMap(os.Getenv("SPAWNBOT_WEATHER_API_KEY")); provider != nil {
+		addCommand(&cmdhandler.Command{
+			Name:            "weather",
+			Aliases:         []string{"w"},
+			Help:            "<location> -- Shows the current weather at location, e.g. \"Berlin\" or \"Paris, FR\".",
+			MinArgs:         1,
+			Cooldown:        5 * time.Second,
+			BridgeResponses: true,
+			Fn: func(c *girc.Client, input *cmdhandler.Input) {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+
+				input.Reply(weatherReply(ctx, provider, input.RawArgs))
+			},
+		})
+	}
+
+	// Quotes for !quote/!addquote, persisted to SPAWNBOT_QUOTES_PATH if set.
+	quotes, quotes_err := newQuoteDB(os.Getenv("SPAWNBOT_QUOTES_PATH"))
+
+	if quotes_err != nil {
+		panic(quotes_err)
+	}
+
+	// *rand.Rand isn't safe for concurrent use, and commands run concurrently.
+	var quote_mu sync.Mutex
+	quote_rand := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	addCommand(&cmdhandler.Command{
+		Name:     "addquote",
+		Category: "Fun",
+		Help:     "<text> -- Adds a quote to the quote database.",
+		MinArgs:  1,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			n, err := quotes.add(input.RawArgs)
+			if err != nil {
+				slog.Error("[QUOTES] Unable to save quotes", slog.Any("err", err))
+			}
+
+			c.Cmd.Replyf(*input.Origin, "added quote #%d", n)
+		},
+	})
+
+	addCommand(&cmdhandler.Command{
+		Name:     "quote",
+		Category: "Fun",
+		Help:     "[n] -- Shows quote number n, or a random quote.",
+		MinArgs:  0,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			var n int
+			var quote string
+			var err error
+			if len(input.Args) > 0 {
+				if n, err = strconv.Atoi(input.Args[0]); err != nil {
+					c.Cmd.Replyf(*input.Origin, "invalid quote number %q", sanitizeIRC(input.Args[0]))
+					return
+				}
+
+				quote, err = quotes.get(n)
+			} else {
+				quote_mu.Lock()
+				n, quote, err = quotes.random(quote_rand)
+				quote_mu.Unlock()
+			}
+
+			if err != nil {
+				c.Cmd.Reply(*input.Origin, err.Error())
+				return
+			}
+
+			c.Cmd.Replyf(*input.Origin, "#%d: %s", n, sanitizeIRC(quote))
+		},
+	})
+
+	// Factoids for !learn/!forget, persisted to SPAWNBOT_FACTOIDS_PATH if set.
+	factoids, factoids_err := newFactoidList(os.Getenv("SPAWNBOT_FACTOIDS_PATH"), irc_prefix)
+
+	if factoids_err != nil {
+		panic(factoids_err)
+	}
+
+	addCommand(&cmdhandler.Command{
+		Name:     "learn",
+		Category: "Admin",
+		Help:     "<key> <value> -- Teaches the bot to reply with value to any message containing " + irc_prefix + "key.",
+		MinArgs:  2,
+		MaxArgs:  2,
+		Admin:    true,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			key := strings.ToLower(input.Args[0])
+			if !factoidKey.MatchString(key) || key == "help" || cmdHandler.Has(key) {
+				c.Cmd.Replyf(*input.Origin, "%q can't be used as a factoid", sanitizeIRC(key))
+				return
+			}
+
+			if err := factoids.learn(key, input.Args[1]); err != nil {
+				slog.Error("[FACTOIDS] Unable to save factoids", slog.Any("err", err))
+			}
+
+			c.Cmd.Replyf(*input.Origin, "learned %s%s", irc_prefix, key)
+		},
+	})
+
+	addCommand(&cmdhandler.Command{
+		Name:     "forget",
+		Category: "Admin",
+		Help:     "<key> -- Forgets a factoid taught with " + irc_prefix + "learn.",
+		MinArgs:  1,
+		Admin:    true,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			forgot, err := factoids.forget(input.Args[0])
+			if err != nil {
+				slog.Error("[FACTOIDS] Unable to save factoids", slog.Any("err", err))
+			}
+
+			if forgot {
+				c.Cmd.Replyf(*input.Origin, "forgot %s%s", irc_prefix, sanitizeIRC(input.Args[0]))
+			} else {
+				c.Cmd.Replyf(*input.Origin, "don't know %s%s", irc_prefix, sanitizeIRC(input.Args[0]))
+			}
+		},
+	})
+
+	// Factoid replies are limited to 3 per 30 seconds so they can't be used
+	// to flood the channel.
+	irc_client.Handlers.Add(girc.PRIVMSG, factoids.responder(newRelayLimiter(clk, 3, 30*time.Second)))
+
+	// Reminders for !remind, persisted to SPAWNBOT_REMINDERS_PATH if set so
+	// they survive a restart. They can be set at most SPAWNBOT_REMIND_MAX
+	// ahead, and each nick may have at most SPAWNBOT_REMIND_MAX_PENDING
+	// pending, so one user can't fill the database.
+	remind_pending := envInt("SPAWNBOT_REMIND_MAX_PENDING", 5)
+	reminders, reminders_err := newReminderDB(os.Getenv("SPAWNBOT_REMINDERS_PATH"), remind_pending)
+
+	if reminders_err != nil {
+		panic(reminders_err)
+	}
+
+	remind_max := envDuration("SPAWNBOT_REMIND_MAX", 7*24*time.Hour)
+
+	addCommand(&cmdhandler.Command{
+		Name:     "remind",
+		Help:     "<delay> <message> -- Reminds you of message after delay, e.g. 10m, 2h30m or 3d, here or by PM if asked by PM.",
+		MinArgs:  2,
+		MaxArgs:  2,
+		Cooldown: 10 * time.Second,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			delay, err := parseReminderDelay(input.Args[0], remind_max)
+			if err != nil {
+				c.Cmd.Reply(*input.Origin, sanitizeIRC(err.Error()))
+				return
+			}
+
+			r := reminder{Due: clk.Now().Add(delay), Nick: input.Origin.Source.Name, Message: input.Args[1]}
+			if input.Origin.IsFromChannel() {
+				r.Target = input.Origin.Params[0]
+			}
+
+			if err := reminders.add(r); errors.Is(err, errTooManyReminders) {
+				c.Cmd.Replyf(*input.Origin, "you already have %d reminders pending", remind_pending)
+				return
+			} else if err != nil {
+				slog.Error("[REMIND] Unable to save reminders", slog.Any("err", err))
+			}
+
+			c.Cmd.Replyf(*input.Origin, "okay, I'll remind you in %s", delay)
+		},
+	})
+
+	go reminders.run(irc_client, clk, time.Second)
+
+	addCommand(&cmdhandler.Command{
+		Name:    "time",
+		Help:    "[timezone] -- Shows the current time in an IANA timezone, e.g. Europe/London. Defaults to UTC.",
 		MinArgs: 0,
 		Fn: func(c *girc.Client, input *cmdhandler.Input) {
-			c.Cmd.Reply(*input.Origin, "pong!")
+			var name string
+			if len(input.Args) > 0 {
+				name = input.Args[0]
+			}
+
+			reply, err := timeIn(name, clk.Now())
+			if err != nil {
+				c.Cmd.Reply(*input.Origin, err.Error())
+				return
+			}
+
+			c.Cmd.Reply(*input.Origin, reply)
+		},
+	})
+
+	addCommand(&cmdhandler.Command{
+		Name:     "whois",
+		Help:     "<nick> -- Shows the hostmask, real name and channels of an IRC user.",
+		MinArgs:  1,
+		Cooldown: 10 * time.Second,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			nick := input.Args[0]
+			if !girc.IsValidNick(nick) {
+				c.Cmd.Replyf(*input.Origin, "invalid nick %q", sanitizeIRC(nick))
+				return
+			}
+
+			reply, err := queryWhois(c, nick, 10*time.Second)
+			if err != nil {
+				c.Cmd.Reply(*input.Origin, sanitizeIRC(err.Error()))
+				return
+			}
+
+			c.Cmd.Reply(*input.Origin, sanitizeIRC(reply.String()))
+		},
+	})
+
+	addCommand(&cmdhandler.Command{
+		Name:     "say",
+		Category: "Admin",
+		Help:     "<message> -- Makes the bot say something in the channel.",
+		MinArgs:  1,
+		Admin:    true,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			message := strings.TrimSpace(stripControl(input.RawArgs))
+			if message == "" {
+				return
+			}
+
+			c.Cmd.Reply(*input.Origin, message)
+		},
+	})
+
+	addCommand(&cmdhandler.Command{
+		Name:     "ban",
+		Category: "Admin",
+		Help:     "<nick|mask> [#channel] -- Bans a user from the channel. Requires the bot to be opped.",
+		MinArgs:  1,
+		Admin:    true,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			channel, mask, err := banTarget(c, input.Origin, input.Args)
+			if err != nil {
+				c.Cmd.Reply(*input.Origin, sanitizeIRC(err.Error()))
+				return
+			}
+
+			c.Cmd.Ban(channel, mask)
+			c.Cmd.Replyf(*input.Origin, "banned %s from %s", mask, channel)
+		},
+	})
+
+	addCommand(&cmdhandler.Command{
+		Name:     "unban",
+		Category: "Admin",
+		Help:     "<nick|mask> [#channel] -- Lifts a ban set with " + irc_prefix + "ban. Requires the bot to be opped.",
+		MinArgs:  1,
+		Admin:    true,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			channel, mask, err := banTarget(c, input.Origin, input.Args)
+			if err != nil {
+				c.Cmd.Reply(*input.Origin, sanitizeIRC(err.Error()))
+				return
+			}
+
+			c.Cmd.Unban(channel, mask)
+			c.Cmd.Replyf(*input.Origin, "unbanned %s from %s", mask, channel)
+		},
+	})
+
+	// Nicks/usernames whose messages aren't relayed, seeded from SPAWNBOT_IGNORE
+	// and persisted to SPAWNBOT_IGNORE_PATH (if set) when changed at runtime.
+	ignored, ignore_err := newIgnoreList(os.Getenv("SPAWNBOT_IGNORE_PATH"), envList("SPAWNBOT_IGNORE"))
+
+	if ignore_err != nil {
+		panic(ignore_err)
+	}
+
+	// Words in SPAWNBOT_BLOCKLIST (whole words, or /regexes/ without commas)
+	// are masked with asterisks in both directions, or with
+	// SPAWNBOT_BLOCKLIST_MODE=drop, matching messages aren't relayed at all.
+	blocked, blocklist_err := newBlocklist(envList("SPAWNBOT_BLOCKLIST"), os.Getenv("SPAWNBOT_BLOCKLIST_MODE") == "drop")
+
+	if blocklist_err != nil {
+		panic(blocklist_err)
+	}
+
+	addCommand(&cmdhandler.Command{
+		Name:     "ignore add",
+		Category: "Admin",
+		Help:     "<nick> -- Stops relaying messages from an IRC nick or Discord username.",
+		MinArgs:  1,
+		Admin:    true,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			added, err := ignored.add(input.Args[0])
+			if err != nil {
+				slog.Error("[IGNORE] Unable to save ignore list", slog.Any("err", err))
+			}
+
+			if added {
+				c.Cmd.Replyf(*input.Origin, "now ignoring %s", sanitizeIRC(input.Args[0]))
+			} else {
+				c.Cmd.Replyf(*input.Origin, "already ignoring %s", sanitizeIRC(input.Args[0]))
+			}
+		},
+	})
+
+	addCommand(&cmdhandler.Command{
+		Name:     "ignore del",
+		Category: "Admin",
+		Help:     "<nick> -- Resumes relaying messages from an ignored nick or username.",
+		MinArgs:  1,
+		Admin:    true,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			removed, err := ignored.del(input.Args[0])
+			if err != nil {
+				slog.Error("[IGNORE] Unable to save ignore list", slog.Any("err", err))
+			}
+
+			if removed {
+				c.Cmd.Replyf(*input.Origin, "no longer ignoring %s", sanitizeIRC(input.Args[0]))
+			} else {
+				c.Cmd.Replyf(*input.Origin, "%s is not ignored", sanitizeIRC(input.Args[0]))
+			}
+		},
+	})
+
+	addCommand(&cmdhandler.Command{
+		Name:     "ignore list",
+		Category: "Admin",
+		Help:     "Lists the ignored nicks and usernames.",
+		MinArgs:  0,
+		Admin:    true,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			nicks := ignored.list()
+			if len(nicks) == 0 {
+				c.Cmd.Reply(*input.Origin, "nobody is ignored")
+				return
+			}
+
+			c.Cmd.Reply(*input.Origin, sanitizeIRC("ignored: "+strings.Join(nicks, ", ")))
 		},
 	})
 
@@ -80,112 +562,345 @@ func main() {
 	//  |_______/ |______/ \______/  \______/  \______/ |__/  |__/|_______/
 	// =============================================================================================
 	// slog.Info("[DISCORD] Connecting to gateway...")
-	dis_client, dis_err := disgo.New(os.Getenv("SPAWNBOT_TOKEN"),
-		bot.WithGatewayConfigOpts(
-			gateway.WithIntents(
-				gateway.IntentGuildMessages,
-				gateway.IntentMessageContent,
-			),
-		),
+	// With SPAWNBOT_DISCORD_OPTIONAL set, a Discord failure leaves dis_client nil
+	// and the bot runs IRC-only, with the relays becoming no-ops.
+	discord_optional := envBool("SPAWNBOT_DISCORD_OPTIONAL", false)
+
+	app_config, config_err := loadAppConfig()
+
+	if config_err != nil {
+		panic(config_err)
+	}
+
+	// With SPAWNBOT_DISCORD_STATUS set (e.g. "Bridging #spawn"), it is shown as
+	// the bot's activity while IRC is up.
+	dis_status := app_config.status
+
+	// disgo reconnects with backoff after recoverable gateway closes by itself,
+	// but gives up on fatal ones (bad token, disallowed intents), which are
+	// passed on to gateway_fatal.
+	gateway_fatal := make(chan error, 1)
+	on_close := func(_ gateway.Gateway, err error) {
+		code, fatal := classifyGatewayClose(err)
+		if !fatal {
+			return
+		}
+
+		slog.Error("[DISCORD] Gateway closed and won't reconnect: "+gatewayCloseHint(code), slog.Int("code", code.Code), slog.String("reason", code.Description))
+		select {
+		case gateway_fatal <- err:
+		default:
+		}
+	}
+
+	// The gateway is built here rather than by disgo only to set on_close,
+	// so its events are handed on to the client once that exists. Resuming
+	// after recoverable closes is left on (gateway.WithEnableResumeURL), and
+	// listeners are only ever added once, below, so reconnects don't
+	// duplicate relays.
+	var dis_client bot.Client
+	dis_gateway := gateway.New(os.Getenv("SPAWNBOT_TOKEN"), func(event_type gateway.EventType, seq int, shard int, event gateway.EventData) {
+		handlers.DefaultGatewayEventHandlerFunc(dis_client)(event_type, seq, shard, event)
+	}, on_close, app_config.gatewayOpts()...)
+
+	var dis_err error
+	dis_client, dis_err = disgo.New(os.Getenv("SPAWNBOT_TOKEN"),
+		bot.WithGateway(dis_gateway),
 	)
 
 	if dis_err != nil {
-		panic(dis_err)
+		if !runIRCOnly(dis_err, discord_optional, "Unable to create client") {
+			panic(dis_err)
+		}
+
+		dis_client = nil
 	}
-	defer dis_client.Close(context.TODO())
+	defer func() {
+		if dis_client != nil {
+			dis_client.Close(context.TODO())
+		}
+	}()
 
 	// slog.Info("[DISCORD] Connected")
 
+	// Outbound relay queues, flushed by shutdown before the connections close.
+	irc_out := newOutbox("IRC", 100)
+	dis_out := newOutbox("DISCORD", 100)
+
+	// Flood protection: at most SPAWNBOT_MAX_RELAY_RATE messages per
+	// SPAWNBOT_RELAY_RATE_WINDOW are relayed in each direction (0 = unlimited).
+	relay_rate := envInt("SPAWNBOT_MAX_RELAY_RATE", 0)
+	relay_window := envDuration("SPAWNBOT_RELAY_RATE_WINDOW", 10*time.Second)
+
+	// The relay handlers log with SPAWNBOT_BRIDGE_ID (for telling several
+	// instances apart in one log stream) and their component attached.
+	bridge_id := os.Getenv("SPAWNBOT_BRIDGE_ID")
+	if bridge_id == "" {
+		bridge_id = "spawn"
+	}
+	irc_log := componentLogger(slog.Default(), bridge_id, "irc")
+	dis_log := componentLogger(slog.Default(), bridge_id, "discord")
+
+	// IRC channels and the Discord channels they're bridged with.
+	routes := newChannelMap()
+	routes.add("#spawn", SPAWN_CHAN_ID)
+
+	// Everything relayed either way is marked (see relayMarker), and marked
+	// messages aren't relayed back.
+	marker := relayMarker()
+
+	// Everything relayed to Discord is sent through dis_send, retrying
+	// transient REST failures SPAWNBOT_DISCORD_RETRIES times. It's nil when
+	// running IRC-only.
+	var dis_send *discordSender
+	if dis_client != nil {
+		dis_send = newDiscordSender(dis_client, clk, envInt("SPAWNBOT_DISCORD_RETRIES", 2), marker)
+	}
+
 	// Sneaky command handler in discord section because we need access to dis_client
-	cmdHandler.Add(&cmdhandler.Command{
-		Name:    "die",
-		Help:    "Forces the bot to quit.",
-		MinArgs: 0,
+	addCommand(&cmdhandler.Command{
+		Name:     "die",
+		Category: "Admin",
+		Help:     "Forces the bot to quit.",
+		MinArgs:  0,
+		Admin:    true,
 		Fn: func(c *girc.Client, input *cmdhandler.Input) {
-			dis_client.Close(context.TODO())
-			c.Quit("as you wish")
-			time.Sleep(time.Second)
-			os.Exit(0)
+			shutdown(c, dis_client, "as you wish", irc_out, dis_out)
 		},
 	})
 
-	irc_client.Handlers.Add(girc.PRIVMSG, cmdHandler.Execute)
+	addCommand(&cmdhandler.Command{
+		Name:     "restart",
+		Category: "Admin",
+		Help:     "Restarts the bot, reconnecting to both sides.",
+		MinArgs:  0,
+		Admin:    true,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			slog.Warn("[SHUTDOWN] Restart requested", slog.String("by", input.Origin.Source.String()))
+			if err := restart(c, dis_client, "restarting", irc_out, dis_out); err != nil {
+				slog.Error("[SHUTDOWN] Unable to restart", slog.Any("err", err))
+				c.Cmd.Reply(*input.Origin, "unable to restart, see the log")
+			}
+		},
+	})
 
-	dis_client.AddEventListeners(bot.NewListenerFunc(func(event *events.MessageCreate) {
-		if event.Message.Author.Bot {
-			return
+	addCommand(&cmdhandler.Command{
+		Name:     "poll",
+		Category: "Fun",
+		Help:     "\"question\" option1 option2 ... -- Starts a poll, voted on with reactions on Discord.",
+		MinArgs:  1,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			question, options, err := parsePoll(input.RawArgs)
+			if err != nil {
+				c.Cmd.ReplyTof(*input.Origin, "%s, e.g. %spoll \"best editor?\" vim emacs", err, irc_prefix)
+				return
+			}
+
+			c.Cmd.Replyf(*input.Origin, "poll: %s %s", sanitizeIRC(question), sanitizeIRC(strings.Join(pollOptions(options), " ")))
+			if !dis_send.enabled() {
+				return
+			}
+
+			channel, ok := routes.discord(input.Origin.Params[0])
+			if !ok {
+				channel = SPAWN_CHAN_ID
+			}
+
+			content := fmt.Sprintf("**Poll** from %s: %s\n%s", input.Origin.Source.Name, question, strings.Join(pollOptions(options), "\n"))
+			create := discordMessage(content, nil, false).Build()
+			dis_out.push(func() {
+				sent, err := dis_send.send(channel, create)
+				if err != nil {
+					slog.Error("[DISCORD] Errors while sending message to discord", slog.Any("err", err))
+					return
+				}
+
+				for _, emoji := range pollEmoji[:len(options)] {
+					if err := dis_client.Rest().AddReaction(channel, sent.ID, emoji); err != nil {
+						slog.Error("[DISCORD] Unable to add poll reaction", slog.Any("err", err))
+						return
+					}
+				}
+			})
+		},
+	})
+
+	addCommand(&cmdhandler.Command{
+		Name:     "bridgestatus",
+		Category: "Admin",
+		Help:     "Reports the health of both sides of the bridge.",
+		MinArgs:  0,
+		Admin:    true,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			for _, line := range bridgeStatus(c, dis_client, irc_out, dis_out, clk.Now()) {
+				c.Cmd.Reply(*input.Origin, sanitizeIRC(line))
+			}
+		},
+	})
+
+	// Discord users (by ID) who opted out of the bridge with "!bridge optout",
+	// persisted to SPAWNBOT_OPTOUT_PATH if set.
+	opted_out, optout_err := newIgnoreList(os.Getenv("SPAWNBOT_OPTOUT_PATH"), nil)
+
+	if optout_err != nil {
+		panic(optout_err)
+	}
+
+	// Relay callbacks for external logging/analytics; set OnRelay and
+	// OnRelayError to hook in.
+	hooks := &relay{clock: clk}
+
+	addCommand(&cmdhandler.Command{
+		Name:     "pause",
+		Category: "Admin",
+		Help:     "Stops bridging in both directions, leaving both connections up.",
+		Admin:    true,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			if hooks.paused.Swap(true) {
+				c.Cmd.Reply(*input.Origin, "bridge is already paused")
+				return
+			}
+
+			slog.Warn("[BRIDGE] Paused", slog.String("by", input.Origin.Source.String()))
+			c.Cmd.Reply(*input.Origin, "bridge paused, use "+irc_prefix+"resume to restart it")
+		},
+	})
+
+	addCommand(&cmdhandler.Command{
+		Name:     "resume",
+		Category: "Admin",
+		Help:     "Restarts bridging after " + irc_prefix + "pause.",
+		Admin:    true,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			if !hooks.paused.Swap(false) {
+				c.Cmd.Reply(*input.Origin, "bridge isn't paused")
+				return
+			}
+
+			slog.Info("[BRIDGE] Resumed", slog.String("by", input.Origin.Source.String()))
+			c.Cmd.Reply(*input.Origin, "bridge resumed")
+		},
+	})
+
+	addCommand(&cmdhandler.Command{
+		Name:     "relaystats",
+		Category: "Admin",
+		Help:     "Reports how many messages were relayed in the last minute, hour and day.",
+		Admin:    true,
+		Fn: func(c *girc.Client, input *cmdhandler.Input) {
+			now := clk.Now()
+			for _, direction := range []string{ircToDiscord, discordToIRC} {
+				counter := hooks.counter(direction)
+				c.Cmd.Replyf(*input.Origin, "%s: %d in the last minute, %d in the last hour, %d in the last day",
+					direction, counter.total(now, time.Minute), counter.total(now, time.Hour), counter.total(now, 24*time.Hour))
+			}
+		},
+	})
+
+	// With SPAWNBOT_IRC_AWAY_MSG set, the bot goes AWAY on IRC after
+	// SPAWNBOT_IRC_AWAY_AFTER without anything relayed from Discord.
+	if away_msg := os.Getenv("SPAWNBOT_IRC_AWAY_MSG"); away_msg != "" {
+		away := newAwayTracker(sanitizeIRC(away_msg), envDuration("SPAWNBOT_IRC_AWAY_AFTER", 30*time.Minute), clk.Now())
+		hooks.OnRelay = func(direction, from, content string) {
+			if direction == discordToIRC && away.activity(clk.Now()) {
+				irc_client.Cmd.Back()
+			}
 		}
 
-		// if event.Message.ChannelID == BRINE_CHAN_ID {
-		if event.Message.ChannelID == SPAWN_CHAN_ID {
-			unprefixed, _ := strings.CutPrefix(event.Message.Content, "!")
-			if unprefixed == "die" {
-				irc_client.Quit("as you wish")
-				dis_client.Close(context.TODO())
-				time.Sleep(time.Second)
-				os.Exit(0)
-			}
-
-			var author string = event.Message.Author.Username
-			var content string = event.Message.Content
-
-			// if len(event.Message.Attachments) > 0 {
-			// 	var atts_string string
-			// 	for _, att := range event.Message.Attachments {
-			// 		atts_string = fmt.Sprintf("%s %s", atts_string, att.URL)
-			// 	}
-
-			// 	content += " " + atts_string
-			// }
-
-			// for _, mention := range event.Message.Mentions {
-			// 	if strings.Contains(content, mention.ID.String()) {
-			// 		content = strings.Replace(content, mention.ID.String(), mention.Username, 1)
-			// 	}
-			// }
-
-			//         /## /##                 /##          /##
-			//        | ##|__/                |  ##        |__/
-			//    /####### /##  /#######       \  ##        /##  /######   /#######
-			//   /##__  ##| ## /##_____/        \  ##      | ## /##__  ## /##_____/
-			//  | ##  | ##| ##|  ######          /##/      | ##| ##  \__/| ##
-			//  | ##  | ##| ## \____  ##        /##/       | ##| ##      | ##
-			//  |  #######| ## /#######/       /##/        | ##| ##      |  #######
-			//   \_______/|__/|_______/       |__/         |__/|__/       \_______/
-			message := fmt.Sprintf("[DISCORD] %s: %s", author, content)
-
-			irc_client.Cmd.Message("#spawn", message)
-			// irc_client.Cmd.Message("#spawnbot", message)
-			// slog.Info(message)
+		// The server forgets AWAY on reconnect.
+		irc_client.Handlers.Add(girc.CONNECTED, func(c *girc.Client, e girc.Event) {
+			away.activity(clk.Now())
+		})
+
+		go away.run(irc_client, clk, time.Minute)
+	}
+
+	// Recently seen messages from both sides, for edit/delete context.
+	cache := newMessageCache(envInt("SPAWNBOT_MSG_CACHE_SIZE", 500))
+
+	// Posts in the threads of the forum channel SPAWNBOT_DISCORD_FORUM_ID are
+	// relayed into SPAWNBOT_FORUM_IRC_CHANNEL, prefixed with the thread name.
+	var forum *forumThreads
+	if forum_id := os.Getenv("SPAWNBOT_DISCORD_FORUM_ID"); forum_id != "" && dis_client != nil {
+		id, err := snowflake.Parse(forum_id)
+		if err != nil {
+			panic(fmt.Errorf("invalid SPAWNBOT_DISCORD_FORUM_ID: %w", err))
 		}
-	}))
-
-	//   /##                           /##                /## /##
-	//  |__/                          |  ##              | ##|__/
-	//   /##  /######   /#######       \  ##         /####### /##  /#######
-	//  | ## /##__  ## /##_____/        \  ##       /##__  ##| ## /##_____/
-	//  | ##| ##  \__/| ##               /##/      | ##  | ##| ##|  ######
-	//  | ##| ##      | ##              /##/       | ##  | ##| ## \____  ##
-	//  | ##| ##      |  #######       /##/        |  #######| ## /#######/
-	//  |__/|__/       \_______/      |__/          \_______/|__/|_______/
-	irc_client.Handlers.Add(girc.PRIVMSG, func(c *girc.Client, e girc.Event) {
-		username := e.Source.Name
-		content := e.Last()
-		message := fmt.Sprintf("[IRC] %s: %s", username, content)
-
-		// _, dis_err = dis_client.Rest().CreateMessage(BRINE_CHAN_ID, discord.NewMessageCreateBuilder().SetContent(message).Build())
-		_, dis_err = dis_client.Rest().CreateMessage(SPAWN_CHAN_ID, discord.NewMessageCreateBuilder().SetContent(message).Build())
-
-		if dis_err != nil {
-			slog.Error("[DISCORD] Errors while sending message to discord", slog.Any("err", dis_err))
-		} else {
-			slog.Info(message)
+
+		forum_channel := os.Getenv("SPAWNBOT_FORUM_IRC_CHANNEL")
+		if forum_channel == "" {
+			forum_channel = "#spawn"
 		}
-	})
 
-	if dis_err = dis_client.OpenGateway(context.TODO()); dis_err != nil {
-		slog.Error("[DISCORD] Errors while connecting to gateway", slog.Any("err", dis_err))
-		return
+		forum = newForumThreads(dis_client, id, forum_channel)
+	}
+
+	// SPAWNBOT_VOICE_IRC_CHANNEL is where voice joins and leaves are posted.
+	voice_channel := os.Getenv("SPAWNBOT_VOICE_IRC_CHANNEL")
+	if voice_channel == "" {
+		voice_channel = "#spawn"
+	}
+
+	if dis_client != nil {
+		registerDiscordHandlers(dis_log, dis_client, clk, irc_client, routes, forum, irc_out, newRelayLimiter(clk, relay_rate, relay_window), ignored, opted_out, blocked, hooks, cache, app_config.adminRoles, app_config.nameStrip, support, marker, app_config.voiceChannel, voice_channel, func() {
+			shutdown(irc_client, dis_client, "as you wish", irc_out, dis_out)
+		})
+	}
+
+	if dis_client != nil {
+		if dis_err = dis_client.OpenGateway(context.TODO()); dis_err != nil {
+			if !runIRCOnly(dis_err, discord_optional, "Unable to connect to gateway") {
+				slog.Error("[DISCORD] Errors while connecting to gateway", slog.Any("err", dis_err))
+				return
+			}
+
+			dis_client.Close(context.TODO())
+			dis_client = nil
+		}
+	}
+
+	nick_map, nick_err := parseNickMap(envList("SPAWNBOT_NICK_MAP"))
+
+	if nick_err != nil {
+		panic(nick_err)
+	}
+
+	registerIRCHandlers(irc_log, irc_client, clk, cmdHandler, dis_send, routes, dis_out, newRelayLimiter(clk, relay_rate, relay_window), nick_map, ignored, blocked, hooks, cache, support)
+
+	// With SPAWNBOT_IRC_CHANNEL_PATTERN set (e.g. "#spawn-*"), matching
+	// channels found by LIST every SPAWNBOT_CHANNEL_DISCOVERY_INTERVAL are
+	// joined and bridged to the Discord channel of the same name.
+	var discovery *channelDiscovery
+	if pattern := os.Getenv("SPAWNBOT_IRC_CHANNEL_PATTERN"); pattern != "" && dis_client != nil {
+		discovery = registerChannelDiscovery(irc_log, irc_client, clk, dis_client, routes, pattern, envDuration("SPAWNBOT_CHANNEL_DISCOVERY_INTERVAL", time.Hour), envDuration("SPAWNBOT_JOIN_DELAY", time.Second))
+	}
+
+	// INVITEs from admins are always accepted, as are those to channels
+	// matching SPAWNBOT_INVITE_ALLOW.
+	registerInviteHandler(irc_log, irc_client, cmdHandler, envList("SPAWNBOT_INVITE_ALLOW"), discovery)
+
+	if dis_client != nil && dis_status != "" {
+		irc_client.Handlers.Add(girc.CONNECTED, func(c *girc.Client, e girc.Event) {
+			setPresence(dis_client, dis_status, true)
+		})
+		irc_client.Handlers.Add(girc.DISCONNECTED, func(c *girc.Client, e girc.Event) {
+			setPresence(dis_client, dis_status, false)
+		})
+	}
+
+	// Connection alerts POSTed to SPAWNBOT_ALERT_WEBHOOK, if set, at most once
+	// per SPAWNBOT_ALERT_DEBOUNCE for each event.
+	if alerts := newAlerter(clk, os.Getenv("SPAWNBOT_ALERT_WEBHOOK"), envDuration("SPAWNBOT_ALERT_DEBOUNCE", 5*time.Minute)); alerts != nil {
+		irc_client.Handlers.Add(girc.DISCONNECTED, func(c *girc.Client, e girc.Event) {
+			alerts.disconnected("irc", c.Server())
+		})
+		irc_client.Handlers.Add(girc.CONNECTED, func(c *girc.Client, e girc.Event) {
+			alerts.connected("irc", c.Server())
+		})
+
+		if dis_client != nil && dis_client.HasGateway() {
+			go watchGateway(clk, dis_client, alerts, 10*time.Second)
+		}
 	}
 
 	// =============================================================================================
@@ -199,12 +914,33 @@ func main() {
 	//  |__/  |__/|________/ \______/  \______/ |__/  \__/|__/  \__/|________/ \______/    |__/
 	// =============================================================================================
 	// slog.Info("[IRC] Connecting to server...")
+	// A fatal gateway close needs someone to fix the config, so exit rather
+	// than carry on half-bridged, unless Discord is optional.
+	if dis_client != nil {
+		go func() {
+			<-gateway_fatal
+			gatewayClosed(discord_optional, dis_send, func() {
+				shutdown(irc_client, dis_client, "discord gateway closed", irc_out, dis_out)
+			})
+		}()
+	}
+
+	// Repeated identical failures are summarised every 5 minutes instead of
+	// logged on each attempt.
+	reconnects := newReconnectLog(5 * time.Minute)
+	irc_client.Handlers.Add(girc.CONNECTED, func(c *girc.Client, e girc.Event) {
+		reconnects.reset(clk.Now())
+	})
+	irc_client.Handlers.Add(girc.ERROR, func(c *girc.Client, e girc.Event) {
+		reconnects.closed(e.Last())
+	})
+
 	for {
 		if err := irc_client.Connect(); err != nil {
-			slog.Error(err.Error())
-
-			slog.Info("[IRC] Reconnecting in 30 seconds...")
-			time.Sleep(30 * time.Second)
+			reconnects.failed(err, clk.Now())
+			<-clk.After(reconnects.backoff())
+		} else if restartRequested.Load() {
+			reexec()
 		} else {
 			return
 		}
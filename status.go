@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/lrstanley/girc"
+)
+
+// bridgeStatus renders the compact, multi-line connection health report used
+// by !bridgestatus, as of now. dis_client may be nil when running IRC-only.
+func bridgeStatus(irc_client *girc.Client, dis_client bot.Client, irc_out, dis_out *outbox, now time.Time) []string {
+	uptime := "n/a"
+	if since, err := irc_client.ConnSince(); err == nil && irc_client.IsConnected() {
+		uptime = since.Round(time.Second).String()
+	}
+
+	gateway := "disabled"
+	if dis_client != nil && dis_client.HasGateway() {
+		gateway = dis_client.Gateway().Status().String()
+	}
+
+	return []string{
+		fmt.Sprintf("IRC: connected=%t uptime=%s", irc_client.IsConnected(), uptime),
+		fmt.Sprintf("Discord: gateway=%s", gateway),
+		fmt.Sprintf("Last relay: to IRC %s, to Discord %s", since(irc_out.lastSent(), now), since(dis_out.lastSent(), now)),
+		fmt.Sprintf("Queues: to IRC %d, to Discord %d", irc_out.depth(), dis_out.depth()),
+	}
+}
+
+// since formats how long before now t was, or "never" for the zero time.
+func since(t, now time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+
+	return now.Sub(t).Round(time.Second).String() + " ago"
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lrstanley/girc"
+)
+
+func TestBridgeStatus(t *testing.T) {
+	irc_client := girc.New(girc.Config{Server: "irc.example.org", Nick: "SpawnBot", User: "SpawnBot"})
+	irc_out, dis_out := newOutbox("IRC", 10), newOutbox("DISCORD", 10)
+
+	sent := make(chan struct{})
+	irc_out.push(func() { close(sent) })
+	<-sent
+	time.Sleep(10 * time.Millisecond) // let the worker record the send
+
+	status := strings.Join(bridgeStatus(irc_client, nil, irc_out, dis_out, time.Now()), "\n")
+
+	for _, want := range []string{
+		"IRC: connected=false uptime=n/a",
+		"Discord: gateway=disabled",
+		"to IRC 0s ago",
+		"to Discord never",
+		"Queues: to IRC 0, to Discord 0",
+	} {
+		if !strings.Contains(status, want) {
+			t.Errorf("status is missing %q:\n%s", want, status)
+		}
+	}
+}
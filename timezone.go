@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// timeIn formats now in the IANA timezone name (UTC if empty), for !time.
+func timeIn(name string, now time.Time) (string, error) {
+	if name == "" {
+		name = "UTC"
+	}
+
+	// LoadLocation also accepts "Local", which would leak the host's zone.
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return "", fmt.Errorf("unknown timezone %q, try e.g. Europe/London or America/New_York", sanitizeIRC(name))
+	}
+
+	return fmt.Sprintf("%s: %s", loc, now.In(loc).Format("Mon 2 Jan 2006 15:04:05 MST")), nil
+}
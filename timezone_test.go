@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeIn(t *testing.T) {
+	now := time.Date(2024, 7, 1, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{"", "UTC: Mon 1 Jul 2024 12:30:00 UTC", false},
+		{"UTC", "UTC: Mon 1 Jul 2024 12:30:00 UTC", false},
+		{"Europe/London", "Europe/London: Mon 1 Jul 2024 13:30:00 BST", false},
+		{"America/New_York", "America/New_York: Mon 1 Jul 2024 08:30:00 EDT", false},
+		{"Mars/Olympus_Mons", "", true},
+		{"Local", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := timeIn(tt.name, now)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("timeIn(%q) = %q, %v, want %q (error %v)", tt.name, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
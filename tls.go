@@ -0,0 +1,31 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+)
+
+// errCertPair is returned when only one of the certificate and key is set.
+var errCertPair = errors.New("SPAWNBOT_IRC_CERT and SPAWNBOT_IRC_KEY must be set together")
+
+// loadClientCert loads the TLS client certificate used to authenticate to
+// IRC with SASL EXTERNAL (e.g. QuakeNet-style CertFP) from the PEM files at
+// cert and key, returning a TLS config presenting it to server. With neither
+// set, it returns nil: no certificate is used.
+func loadClientCert(cert, key, server string) (*tls.Config, error) {
+	if cert == "" && key == "" {
+		return nil, nil
+	}
+
+	if cert == "" || key == "" {
+		return nil, errCertPair
+	}
+
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load irc client certificate: %w", err)
+	}
+
+	return &tls.Config{ServerName: server, Certificates: []tls.Certificate{pair}}, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertPair writes a self-signed certificate and its key as PEM files in
+// dir, returning their paths.
+func writeCertPair(t *testing.T, dir string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "SpawnBot"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key_der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert_path, key_path := filepath.Join(dir, "spawnbot.crt"), filepath.Join(dir, "spawnbot.key")
+	if err := os.WriteFile(cert_path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(key_path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key_der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return cert_path, key_path
+}
+
+func TestLoadClientCert(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := writeCertPair(t, dir)
+
+	config, err := loadClientCert(cert, key, "irc.quakenet.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Certificates) != 1 || config.ServerName != "irc.quakenet.org" {
+		t.Errorf("loadClientCert() = %d certificates for %q, want 1 for irc.quakenet.org", len(config.Certificates), config.ServerName)
+	}
+
+	if config, err := loadClientCert("", "", "irc.quakenet.org"); config != nil || err != nil {
+		t.Errorf("loadClientCert() without a certificate = %v, %v, want nil, nil", config, err)
+	}
+
+	if _, err := loadClientCert(cert, "", "irc.quakenet.org"); err != errCertPair {
+		t.Errorf("loadClientCert() without a key = %v, want %v", err, errCertPair)
+	}
+
+	if _, err := loadClientCert(filepath.Join(dir, "missing.crt"), key, "irc.quakenet.org"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("loadClientCert() with a missing certificate = %v, want a not exist error", err)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/disgoorg/disgo/events"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// voiceUpdate describes event on IRC if it's someone joining or leaving the
+// Discord voice channel voice, e.g. "* alice joined voice". Bots, users on
+// ignored and those who opted out of the bridge aren't announced. Names are
+// passed through strip.
+func voiceUpdate(event *events.GuildVoiceStateUpdate, voice snowflake.ID, strip *nameStrip, ignored, opted_out *ignoreList) (string, bool) {
+	user := event.Member.User
+	if user.Bot || ignored.has(user.Username) || opted_out.has(user.ID.String()) {
+		return "", false
+	}
+
+	return voiceNotice(strip.apply(user.Username), voice, event.OldVoiceState.ChannelID, event.VoiceState.ChannelID)
+}
+
+// voiceNotice describes name moving from the voice channel before to after
+// (nil when not in one), e.g. "* alice joined voice", if either is voice.
+// Mutes, deafens and the like leave the channel unchanged and aren't
+// reported.
+func voiceNotice(name string, voice snowflake.ID, before, after *snowflake.ID) (string, bool) {
+	was := before != nil && *before == voice
+	is := after != nil && *after == voice
+
+	switch {
+	case is && !was:
+		return "* " + name + " joined voice", true
+	case was && !is:
+		return "* " + name + " left voice", true
+	}
+
+	return "", false
+}
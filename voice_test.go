@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log/slog"
+	"spawnbot/cmdhandler"
+	"spawnbot/internal/testutil"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/lrstanley/girc"
+)
+
+func TestVoiceUpdate(t *testing.T) {
+	const voice, other = snowflake.ID(1), snowflake.ID(2)
+	in := func(id snowflake.ID) *snowflake.ID { return &id }
+
+	update := func(name string, id snowflake.ID, bot bool, before, after *snowflake.ID) *events.GuildVoiceStateUpdate {
+		return &events.GuildVoiceStateUpdate{
+			GenericGuildVoiceState: &events.GenericGuildVoiceState{
+				VoiceState: discord.VoiceState{UserID: id, ChannelID: after},
+				Member:     discord.Member{User: discord.User{ID: id, Username: name, Bot: bot}},
+			},
+			OldVoiceState: discord.VoiceState{UserID: id, ChannelID: before},
+		}
+	}
+
+	ignored, _ := newIgnoreList("", []string{"troll"})
+	opted_out, _ := newIgnoreList("", []string{"99"})
+	strip, _ := newNameStrip("irc_")
+
+	tests := []struct {
+		name  string
+		event *events.GuildVoiceStateUpdate
+		want  string
+	}{
+		{"join", update("alice", 10, false, nil, in(voice)), "* alice joined voice"},
+		{"leave", update("alice", 10, false, in(voice), nil), "* alice left voice"},
+		{"moved in", update("alice", 10, false, in(other), in(voice)), "* alice joined voice"},
+		{"moved out", update("alice", 10, false, in(voice), in(other)), "* alice left voice"},
+		{"muted", update("alice", 10, false, in(voice), in(voice)), ""},
+		{"other channel", update("alice", 10, false, nil, in(other)), ""},
+		{"stripped name", update("irc_bob", 11, false, nil, in(voice)), "* bob joined voice"},
+		{"bot", update("robot", 12, true, nil, in(voice)), ""},
+		{"ignored", update("troll", 13, false, nil, in(voice)), ""},
+		{"opted out", update("carol", 99, false, nil, in(voice)), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := voiceUpdate(tt.event, voice, strip, ignored, opted_out)
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("voiceUpdate() = %q, %v, want %q", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelayVoice(t *testing.T) {
+	t.Setenv("SPAWNBOT_IRC_RELAY_AS_NOTICE", "true")
+
+	dis_client, _ := fakeDiscord(t)
+	fake := testutil.NewCommander(t)
+	ignored, _ := newIgnoreList("", nil)
+	opted_out, _ := newIgnoreList("", []string{"99"})
+	registerDiscordHandlers(slog.Default(), dis_client, cmdhandler.RealClock{}, fake.Client, spawnRoutes(), nil, newOutbox("IRC", 10), nil, ignored, opted_out, nil, nil, newMessageCache(10), nil, nil, nil, "", 1, "#voice", func() {})
+
+	joined := func(name string, id snowflake.ID) {
+		voice := snowflake.ID(1)
+		dis_client.EventManager().DispatchEvent(&events.GuildVoiceStateUpdate{
+			GenericGuildVoiceState: &events.GenericGuildVoiceState{
+				GenericEvent: events.NewGenericEvent(dis_client, 0, 0),
+				VoiceState:   discord.VoiceState{UserID: id, ChannelID: &voice},
+				Member:       discord.Member{User: discord.User{ID: id, Username: name}},
+			},
+			OldVoiceState: discord.VoiceState{UserID: id},
+		})
+	}
+
+	// The opted-out user goes unannounced, so alice's join is sent first.
+	joined("carol", 99)
+	joined("alice", 10)
+
+	select {
+	case e := <-fake.Sent():
+		if e.Command != girc.NOTICE || e.Params[0] != "#voice" || e.Last() != "* alice joined voice" {
+			t.Errorf("relayed %q, want a NOTICE to #voice that alice joined", e.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nothing relayed")
+	}
+}
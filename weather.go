@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// weather is the current conditions at a location, in metric units.
+type weather struct {
+	Location    string // e.g. "Berlin, DE"
+	Description string // e.g. "light rain"
+	TempC       float64
+	FeelsLikeC  float64
+	Humidity    int // percent
+	WindKPH     float64
+}
+
+// weatherProvider looks up the current weather, so !weather isn't tied to one
+// API.
+type weatherProvider interface {
+	Current(ctx context.Context, location string) (weather, error)
+}
+
+// errUnknownLocation is returned by a weatherProvider which can't find the
+// location asked for.
+var errUnknownLocation = errors.New("unknown location")
+
+// openWeatherMapURL is the OpenWeatherMap current weather endpoint.
+const openWeatherMapURL = "https://api.openweathermap.org/data/2.5/weather"
+
+// openWeatherMap is a weatherProvider backed by the OpenWeatherMap API.
+type openWeatherMap struct {
+	key    string
+	url    string
+	client *http.Client
+}
+
+// newOpenWeatherMap returns a provider using the API key, or nil if key is
+// empty.
+func newOpenWeatherMap(key string) *openWeatherMap {
+	if key == "" {
+		return nil
+	}
+
+	return &openWeatherMap{key: key, url: openWeatherMapURL, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// openWeatherMapResponse is the subset of the API's response we use.
+type openWeatherMapResponse struct {
+	Name string `json:"name"`
+	Sys  struct {
+		Country string `json:"country"`
+	} `json:"sys"`
+	Weather []struct {
+		Description string `json:"description"`
+	} `json:"weather"`
+	Main struct {
+		Temp      float64 `json:"temp"`
+		FeelsLike float64 `json:"feels_like"`
+		Humidity  int     `json:"humidity"`
+	} `json:"main"`
+	Wind struct {
+		Speed float64 `json:"speed"` // m/s
+	} `json:"wind"`
+	Message string `json:"message"` // set on errors
+}
+
+func (o *openWeatherMap) Current(ctx context.Context, location string) (weather, error) {
+	query := url.Values{"q": {location}, "appid": {o.key}, "units": {"metric"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.url+"?"+query.Encode(), nil)
+	if err != nil {
+		return weather{}, err
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return weather{}, err
+	}
+	defer resp.Body.Close()
+
+	var body openWeatherMapResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil && resp.StatusCode < 300 {
+		return weather{}, fmt.Errorf("invalid weather response: %w", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return weather{}, errUnknownLocation
+	case resp.StatusCode >= 300:
+		if body.Message != "" {
+			return weather{}, fmt.Errorf("weather API returned %s: %s", resp.Status, body.Message)
+		}
+		return weather{}, fmt.Errorf("weather API returned %s", resp.Status)
+	}
+
+	w := weather{
+		Location:   body.Name,
+		TempC:      body.Main.Temp,
+		FeelsLikeC: body.Main.FeelsLike,
+		Humidity:   body.Main.Humidity,
+		WindKPH:    body.Wind.Speed * 3.6,
+	}
+	if body.Sys.Country != "" {
+		w.Location += ", " + body.Sys.Country
+	}
+	if len(body.Weather) > 0 {
+		w.Description = body.Weather[0].Description
+	}
+
+	return w, nil
+}
+
+// weatherReply answers "!weather <location>" using provider: the weather at
+// location, or why it couldn't be found.
+func weatherReply(ctx context.Context, provider weatherProvider, location string) string {
+	w, err := provider.Current(ctx, location)
+	switch {
+	case errors.Is(err, errUnknownLocation):
+		return "I don't know where " + sanitizeIRC(location) + " is."
+	case err != nil:
+		slog.Error("[CMD] Unable to fetch weather", slog.String("location", location), slog.Any("err", err))
+		return "couldn't fetch the weather, try again later."
+	}
+
+	return sanitizeIRC(formatWeather(w))
+}
+
+// formatWeather describes w in one line, e.g. "Berlin, DE: light rain, 12°C
+// (feels like 10°C), humidity 81%, wind 15 km/h".
+func formatWeather(w weather) string {
+	out := w.Location + ":"
+	if w.Description != "" {
+		out += " " + w.Description + ","
+	}
+
+	return fmt.Sprintf("%s %.0f°C (feels like %.0f°C), humidity %d%%, wind %.0f km/h", out, roundZero(w.TempC), roundZero(w.FeelsLikeC), w.Humidity, w.WindKPH)
+}
+
+// roundZero rounds f to a whole number, avoiding "-0" for small negatives.
+func roundZero(f float64) float64 {
+	return math.Round(f) + 0
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// stubWeather is a weatherProvider answering from a map of locations.
+type stubWeather map[string]weather
+
+func (s stubWeather) Current(ctx context.Context, location string) (weather, error) {
+	if location == "down" {
+		return weather{}, errors.New("connection refused")
+	}
+
+	w, ok := s[location]
+	if !ok {
+		return weather{}, errUnknownLocation
+	}
+
+	return w, nil
+}
+
+func TestWeatherReply(t *testing.T) {
+	provider := stubWeather{
+		"berlin": {Location: "Berlin, DE", Description: "light rain", TempC: 12.4, FeelsLikeC: 10.2, Humidity: 81, WindKPH: 14.8},
+		"oslo":   {Location: "Oslo, NO", TempC: -0.3, FeelsLikeC: -4.6, Humidity: 90, WindKPH: 3},
+	}
+
+	tests := []struct {
+		location string
+		want     string
+	}{
+		{"berlin", "Berlin, DE: light rain, 12°C (feels like 10°C), humidity 81%, wind 15 km/h"},
+		{"oslo", "Oslo, NO: 0°C (feels like -5°C), humidity 90%, wind 3 km/h"},
+		{"atlantis", "I don't know where atlantis is."},
+		{"down", "couldn't fetch the weather, try again later."},
+	}
+
+	for _, tt := range tests {
+		if got := weatherReply(context.Background(), provider, tt.location); got != tt.want {
+			t.Errorf("weatherReply(%q) = %q, want %q", tt.location, got, tt.want)
+		}
+	}
+}
+
+func TestOpenWeatherMap(t *testing.T) {
+	if newOpenWeatherMap("") != nil {
+		t.Error("newOpenWeatherMap(\"\") returned a provider, want none without a key")
+	}
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch {
+		case query.Get("appid") != "key":
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"cod":401,"message":"Invalid API key"}`)
+		case query.Get("q") == "berlin" && query.Get("units") == "metric":
+			fmt.Fprint(w, `{"name":"Berlin","sys":{"country":"DE"},"weather":[{"description":"light rain"}],"main":{"temp":12.4,"feels_like":10.2,"humidity":81},"wind":{"speed":4.1}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"cod":"404","message":"city not found"}`)
+		}
+	}))
+	t.Cleanup(api.Close)
+
+	provider := newOpenWeatherMap("key")
+	provider.url = api.URL
+
+	w, err := provider.Current(context.Background(), "berlin")
+	want := weather{Location: "Berlin, DE", Description: "light rain", TempC: 12.4, FeelsLikeC: 10.2, Humidity: 81, WindKPH: 4.1 * 3.6}
+	if err != nil || w != want {
+		t.Errorf("Current(berlin) = %+v, %v, want %+v", w, err, want)
+	}
+
+	if _, err := provider.Current(context.Background(), "atlantis"); !errors.Is(err, errUnknownLocation) {
+		t.Errorf("Current(atlantis) = %v, want errUnknownLocation", err)
+	}
+
+	provider.key = "wrong"
+	if _, err := provider.Current(context.Background(), "berlin"); err == nil || errors.Is(err, errUnknownLocation) {
+		t.Errorf("Current() with a bad key = %v, want the API's error", err)
+	}
+}
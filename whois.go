@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lrstanley/girc"
+)
+
+// errNoSuchNick is returned by queryWhois when the server reports that the
+// nick isn't online.
+var errNoSuchNick = errors.New("no such nick")
+
+// whoisReply assembles the numerics the server sends in response to a WHOIS.
+type whoisReply struct {
+	nick string
+
+	mu       sync.Mutex
+	ident    string
+	host     string
+	realName string
+	channels []string
+	missing  bool
+}
+
+// add records e if it is a WHOIS numeric for r.nick, reporting whether the
+// reply is complete.
+func (r *whoisReply) add(e girc.Event) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(e.Params) < 2 || girc.ToRFC1459(e.Params[1]) != girc.ToRFC1459(r.nick) {
+		return false
+	}
+
+	switch e.Command {
+	case girc.RPL_WHOISUSER:
+		if len(e.Params) >= 6 {
+			r.nick = e.Params[1]
+			r.ident, r.host, r.realName = e.Params[2], e.Params[3], e.Params[5]
+		}
+	case girc.RPL_WHOISCHANNELS:
+		r.channels = append(r.channels, strings.Fields(e.Last())...)
+	case girc.ERR_NOSUCHNICK:
+		r.missing = true
+	case girc.RPL_ENDOFWHOIS:
+		return true
+	}
+
+	return false
+}
+
+// mask returns the nick!ident@host of the user.
+func (r *whoisReply) mask() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.nick + "!" + r.ident + "@" + r.host
+}
+
+// String formats the reply for !whois, e.g.
+// "nick (ident@host): Real Name, on #a #b".
+func (r *whoisReply) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := fmt.Sprintf("%s (%s@%s): %s", r.nick, r.ident, r.host, r.realName)
+	if len(r.channels) > 0 {
+		out += ", on " + strings.Join(r.channels, " ")
+	}
+
+	return out
+}
+
+// queryWhois sends a WHOIS for nick and waits up to timeout for the reply,
+// correlating the numerics with a temporary handler.
+func queryWhois(c *girc.Client, nick string, timeout time.Duration) (*whoisReply, error) {
+	reply := &whoisReply{nick: nick}
+	_, done := c.Handlers.AddTmp(girc.ALL_EVENTS, timeout, func(_ *girc.Client, e girc.Event) bool {
+		return reply.add(e)
+	})
+
+	c.Cmd.Whois(nick)
+
+	// done is closed on the end of the reply, or once timeout expires.
+	<-done
+
+	reply.mu.Lock()
+	defer reply.mu.Unlock()
+
+	if reply.missing {
+		return nil, fmt.Errorf("%w: %s", errNoSuchNick, nick)
+	}
+
+	if reply.host == "" {
+		return nil, fmt.Errorf("whois for %s timed out", nick)
+	}
+
+	return reply, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/lrstanley/girc"
+)
+
+func TestWhoisReply(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []string
+		complete bool
+		want     string
+		missing  bool
+	}{
+		{
+			name: "full reply",
+			lines: []string{
+				":irc.example.org 311 SpawnBot Alice alice example.org * :Alice Liddell",
+				":irc.example.org 319 SpawnBot alice :@#spawn +#dev",
+				":irc.example.org 319 SpawnBot alice :#offtopic",
+				":irc.example.org 318 SpawnBot alice :End of /WHOIS list.",
+			},
+			complete: true,
+			want:     "Alice (alice@example.org): Alice Liddell, on @#spawn +#dev #offtopic",
+		},
+		{
+			name: "no channels",
+			lines: []string{
+				":irc.example.org 311 SpawnBot alice alice example.org * :Alice",
+				":irc.example.org 318 SpawnBot alice :End of /WHOIS list.",
+			},
+			complete: true,
+			want:     "alice (alice@example.org): Alice",
+		},
+		{
+			name: "other nicks ignored",
+			lines: []string{
+				":irc.example.org 311 SpawnBot bob bob example.com * :Bob",
+				":irc.example.org 311 SpawnBot alice alice example.org * :Alice",
+				":irc.example.org 318 SpawnBot bob :End of /WHOIS list.",
+			},
+			want: "alice (alice@example.org): Alice",
+		},
+		{
+			name: "no such nick",
+			lines: []string{
+				":irc.example.org 401 SpawnBot alice :No such nick/channel",
+				":irc.example.org 318 SpawnBot alice :End of /WHOIS list.",
+			},
+			complete: true,
+			missing:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := &whoisReply{nick: "alice"}
+
+			complete := false
+			for _, line := range tt.lines {
+				complete = reply.add(*girc.ParseEvent(line))
+			}
+
+			if complete != tt.complete {
+				t.Errorf("reply complete = %v, want %v", complete, tt.complete)
+			}
+			if reply.missing != tt.missing {
+				t.Errorf("reply missing = %v, want %v", reply.missing, tt.missing)
+			}
+			if !tt.missing {
+				if got := reply.String(); got != tt.want {
+					t.Errorf("String() = %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}